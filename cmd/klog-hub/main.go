@@ -43,6 +43,16 @@ func main() {
 
 	flags.StringVar(&options.Loghub.LogGRPC.Listen, "grpc-public-listen", options.Loghub.LogGRPC.Listen, "Address on which to listen for public request")
 	flags.StringVar(&options.Loghub.MeshGRPC.Listen, "grpc-mesh-listen", options.Loghub.MeshGRPC.Listen, "Address on which to listen for internal requests")
+	flags.StringVar(&options.Loghub.Archive, "archive", options.Loghub.Archive, "Location of the archived logs to include in searches, e.g. s3://bucket/prefix")
+	flags.DurationVar(&options.Loghub.ArchiveRetention, "archive-retention", options.Loghub.ArchiveRetention, "How long to keep archived logs (0 to keep forever)")
+	flags.StringSliceVar(&options.Loghub.ArchiveNamespaceRetention, "archive-namespace-retention", options.Loghub.ArchiveNamespaceRetention, "Per-namespace archive retention, as namespace=duration")
+	flags.DurationVar(&options.Loghub.ArchivePruneInterval, "archive-prune-interval", options.Loghub.ArchivePruneInterval, "How often to remove archived logs past their retention (0 to disable)")
+	flags.DurationVar(&options.Loghub.MemberConnectTimeout, "member-connect-timeout", options.Loghub.MemberConnectTimeout, "How long to wait to start a call to a node (0 to wait forever)")
+	flags.DurationVar(&options.Loghub.MemberFirstByteTimeout, "member-first-byte-timeout", options.Loghub.MemberFirstByteTimeout, "How long to wait for the first stream when listing the streams of a node (0 to wait forever)")
+	flags.DurationVar(&options.Loghub.MemberSearchIdleTimeout, "member-search-idle-timeout", options.Loghub.MemberSearchIdleTimeout, "How long to wait for each result of a search on a node (0 to wait forever)")
+	flags.IntVar(&options.Loghub.MemberRetries, "member-retries", options.Loghub.MemberRetries, "How many times to retry a call to a node that timed out or failed transiently")
+	flags.DurationVar(&options.Loghub.MemberRetryBackoff, "member-retry-backoff", options.Loghub.MemberRetryBackoff, "Delay before the first retry of a call to a node, doubling for each subsequent retry")
+	flags.DurationVar(&options.Loghub.MemberRetryMaxBackoff, "member-retry-max-backoff", options.Loghub.MemberRetryMaxBackoff, "Maximum delay between retries of a call to a node (0 for no limit)")
 	flags.StringVar(&options.GrpcPublicTlsCert, "grpc-public-tls-cert", options.GrpcPublicTlsCert, "Path to TLS certificate")
 	flags.StringVar(&options.GrpcPublicTlsKey, "grpc-public-tls-key", options.GrpcPublicTlsKey, "Path to TLS private key")
 
@@ -6,6 +6,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/spf13/pflag"
 	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
 	"kope.io/klogs/pkg/logspoke"
 	"net"
 	"os"
@@ -31,9 +32,29 @@ func main() {
 	}
 	options.Listen = "http://" + podIP.String() + ":7777"
 
+	flags.StringSliceVar(&options.Sources, "source", options.Sources, "Log sources to collect, one of "+strings.Join(logspoke.SourceNames(), ","))
 	flags.StringVar(&options.PodDir, "pod-dir", options.PodDir, "Directory where pods files are stored")
+	flags.StringSliceVar(&options.PodLogVolumes, "pod-log-volume", options.PodLogVolumes, "Names (globs) of pod volumes to collect log files from; pods can add volumes with the "+logspoke.LogVolumesAnnotation+" annotation")
+	flags.StringSliceVar(&options.PodLogVolumeTypes, "pod-log-volume-type", options.PodLogVolumeTypes, "Types of pod volumes to collect log files from, e.g. empty-dir,csi,host-path")
+	flags.StringSliceVar(&options.PodLogInclude, "pod-log-include", options.PodLogInclude, "Globs of files to collect from pod log volumes (default all)")
+	flags.StringSliceVar(&options.PodLogExclude, "pod-log-exclude", options.PodLogExclude, "Globs of files to skip in pod log volumes")
+	flags.StringVar(&options.HostRoot, "host-root", options.HostRoot, "Directory where the host filesystem is mounted, for reading hostPath volumes")
+	flags.StringVar(&options.KubeletURL, "kubelet-url", options.KubeletURL, "Kubelet API to read pod specs from, e.g. https://<node-ip>:10250 (disabled if empty)")
+	flags.StringVar(&options.KubeletTokenFile, "kubelet-token-file", options.KubeletTokenFile, "Path to the bearer token for the kubelet API")
+	flags.StringVar(&options.KubeletCAFile, "kubelet-ca-file", options.KubeletCAFile, "Path to the CA certificate that signed the kubelet serving certificate")
+	flags.BoolVar(&options.KubeletInsecureTLS, "kubelet-insecure-tls", options.KubeletInsecureTLS, "Do not verify the kubelet serving certificate")
 	flags.StringVar(&options.ContainerDir, "container-dir", options.ContainerDir, "Directory where container files are stored")
-	flags.StringVar(&options.ArchiveSink, "archive", options.ArchiveSink, "Destination to upload archived files")
+	flags.StringSliceVar(&options.ContainerLabels, "container-label", options.ContainerLabels, "Globs of docker container labels to expose as label.<name> fields, e.g. app.example.com/*")
+	flags.StringVar(&options.HostLogDir, "host-log-dir", options.HostLogDir, "Directory where host log files are stored")
+	flags.StringSliceVar(&options.HostLogs, "host-log", options.HostLogs, "Host log files to collect, as name=glob relative to host-log-dir")
+	flags.StringVar(&options.JournalDir, "journal-dir", options.JournalDir, "Directory where systemd journal files are stored")
+	flags.StringVar(&options.CRILogDir, "cri-log-dir", options.CRILogDir, "Directory where CRI container log files are stored")
+	flags.StringVar(&options.RetentionDir, "retention-dir", options.RetentionDir, "Directory in which to keep the logs of terminated containers (disabled if empty)")
+	flags.Int64Var(&options.RetentionMaxBytes, "retention-max-bytes", options.RetentionMaxBytes, "Maximum size of the retained logs (0 for no limit)")
+	flags.DurationVar(&options.RetentionMaxAge, "retention-max-age", options.RetentionMaxAge, "Maximum age of the retained logs (0 for no limit)")
+	flags.StringVar(&options.ArchiveSink, "archive", options.ArchiveSink, "Destination to upload archived files, one of "+strings.Join(archive.SinkSchemes(), ",")+" URLs, e.g. s3://bucket/prefix or file:///var/archive")
+	flags.StringVar(&options.ArchiveQueueDir, "archive-queue-dir", options.ArchiveQueueDir, "Directory in which to journal pending archive uploads (in memory if empty)")
+	flags.IntVar(&options.ArchiveConcurrency, "archive-concurrency", options.ArchiveConcurrency, "Number of concurrent archive uploads")
 	flags.StringVar(&options.JoinHub, "hub", options.JoinHub, "Hub server to register with")
 	flags.StringVar(&options.Listen, "listen", options.Listen, "Address on which to listen")
 	flags.StringVar(&options.NodeName, "nodename", options.NodeName, "Node name, or @path to load from path")
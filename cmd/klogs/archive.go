@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"io"
+	"kope.io/klogs/pkg/client"
+)
+
+func NewCmdArchive(factory client.Factory, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Manage archived logs",
+	}
+
+	cmd.AddCommand(NewCmdArchiveList(factory, out))
+	cmd.AddCommand(NewCmdArchiveGet(factory, out))
+	cmd.AddCommand(NewCmdArchivePrune(factory, out))
+	cmd.AddCommand(NewCmdArchiveVerify(factory, out))
+
+	return cmd
+}
+
+func NewCmdArchiveList(factory client.Factory, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ls [namespace=<ns>] [pod=<pod>] [container=<name>] [age=<duration>]",
+		Aliases: []string{"list"},
+		Short:   "List archived logs",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := client.RunListArchive(factory, out, args)
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+
+	return cmd
+}
+
+func NewCmdArchiveGet(factory client.Factory, out io.Writer) *cobra.Command {
+	options := &client.GetArchiveOptions{}
+	options.Dir = "."
+
+	cmd := &cobra.Command{
+		Use:     "get [namespace=<ns>] [pod=<pod>] [container=<name>] [age=<duration>]",
+		Aliases: []string{"restore"},
+		Short:   "Download archived logs into <dir>/<namespace>/<pod>/<container>",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := client.RunGetArchive(factory, out, args, options)
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&options.Dir, "dir", "d", options.Dir, "Directory to download into")
+
+	return cmd
+}
+
+func NewCmdArchivePrune(factory client.Factory, out io.Writer) *cobra.Command {
+	options := &client.PruneArchiveOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove archived logs past their retention",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := client.RunPruneArchive(factory, out, options)
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+
+	cmd.PersistentFlags().BoolVar(&options.DryRun, "dry-run", options.DryRun, "Report the logs that would be removed, without removing them")
+
+	return cmd
+}
+
+func NewCmdArchiveVerify(factory client.Factory, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check archived logs for missing, corrupted or out-of-sequence files",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := client.RunVerifyArchive(factory, out)
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+
+	return cmd
+}
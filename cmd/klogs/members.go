@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"io"
+	"kope.io/klogs/pkg/client"
+)
+
+func NewCmdMembers(factory client.Factory, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "members",
+		Short: "List the nodes in the mesh, and their health",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := client.RunListMembers(factory, out)
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+
+	return cmd
+}
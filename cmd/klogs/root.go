@@ -37,6 +37,8 @@ func NewRootCommand(out io.Writer) (*cobra.Command, error) {
 	// create subcommands
 	cmd.AddCommand(NewCmdStreams(factory, out))
 	cmd.AddCommand(NewCmdSearch(factory, out))
+	cmd.AddCommand(NewCmdArchive(factory, out))
+	cmd.AddCommand(NewCmdMembers(factory, out))
 
 	return cmd, nil
 }
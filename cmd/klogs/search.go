@@ -22,6 +22,7 @@ func NewCmdSearch(factory client.Factory, out io.Writer) *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVarP(&options.Output, "output", "o", options.Output, "Output format: raw, describe")
+	cmd.PersistentFlags().BoolVar(&options.AllRestarts, "all-restarts", options.AllRestarts, "Merge the logs from all restarts of the containers into a single timeline")
 
 	return cmd
 }
@@ -4,21 +4,26 @@ import (
 	"github.com/spf13/cobra"
 	"io"
 	"kope.io/klogs/pkg/client"
+	"strings"
 )
 
 func NewCmdStreams(factory client.Factory, out io.Writer) *cobra.Command {
 	options := &client.ListStreamsOptions{}
+	options.SortBy = "host"
 
 	cmd := &cobra.Command{
 		Use:   "streams",
 		Short: "Streams",
 		Run: func(cmd *cobra.Command, args []string) {
-			err := client.RunListStreams(factory, out, options)
+			err := client.RunListStreams(factory, out, args, options)
 			if err != nil {
 				exitWithError(err)
 			}
 		},
 	}
 
+	cmd.PersistentFlags().BoolVarP(&options.Watch, "watch", "w", options.Watch, "Watch for streams being added, updated or removed")
+	cmd.PersistentFlags().StringVar(&options.SortBy, "sort-by", options.SortBy, "Column to sort by: "+strings.Join(client.StreamColumnNames(), ", "))
+
 	return cmd
 }
@@ -0,0 +1,353 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// EncryptedSuffix is added to the keys of encrypted objects, after any compression suffix
+const EncryptedSuffix = ".enc"
+
+// Encrypted objects use envelope encryption: each object has a random AES-256 data key, stored in the object header
+// wrapped (AES-GCM) by a key from the keyring.  The contents follow as a sequence of AES-GCM sealed chunks, each
+// prefixed by its length.  The chunk nonce is the chunk number plus a flag marking the final chunk, so chunks
+// cannot be reordered and truncation is detected.
+//
+// Only the contents of archived files are encrypted.  So that archives can be listed and pruned without the keys,
+// the keys of objects (which name the namespace, pod, container or volume), and the manifests, index entries and
+// chain heads stay plaintext: they record the path, fields, size and timestamps of each file.  The content hashes in
+// them (and in the Klogs-Sha256 metadata of S3 objects) are HMACs keyed from the keyring, see ContentHash, so they do
+// not reveal or confirm the contents.
+const (
+	encryptionMagic     = "KLOGSENC"
+	encryptionVersion   = 1
+	encryptionChunkSize = 64 * 1024
+	dataKeySize         = 32
+)
+
+// Keyring holds the keys that wrap the data keys of encrypted objects.  New objects are encrypted with the primary
+// key; the other keys are kept so objects written before a key rotation remain readable.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+	// hashKeys are the keys for content hashes, derived from the keys
+	hashKeys map[string][]byte
+}
+
+// LoadKeyring reads a keyring file, e.g. a Kubernetes secret mounted into the pod.  See ParseKeyring for the format.
+func LoadKeyring(p string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring %q: %v", p, err)
+	}
+	k, err := ParseKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing keyring %q: %v", p, err)
+	}
+	return k, nil
+}
+
+// ParseKeyring parses a keyring, with one <id>=<base64 encoded 32 byte key> per line.  The first key is the primary.
+// To rotate keys, add the new key as the first line and keep the older keys below it.
+func ParseKeyring(data []byte) (*Keyring, error) {
+	k := &Keyring{
+		keys:     make(map[string]cipher.AEAD),
+		hashKeys: make(map[string][]byte),
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens := strings.SplitN(line, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("invalid line %q, expected <id>=<base64 key>", line)
+		}
+		id := strings.TrimSpace(tokens[0])
+		if len(id) > 255 {
+			return nil, fmt.Errorf("key id %q is too long", id)
+		}
+		if _, found := k.keys[id]; found {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(tokens[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", id, err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes, was %d", id, dataKeySize, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		k.keys[id] = aead
+		k.hashKeys[id] = deriveHashKey(key)
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if k.primary == "" {
+		return nil, fmt.Errorf("no keys found")
+	}
+	return k, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error building cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// contentHashLabel separates the key for content hashes from the key that wraps data keys
+const contentHashLabel = "klogs content hash"
+
+func deriveHashKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(contentHashLabel))
+	return mac.Sum(nil)
+}
+
+// ContentHash returns the hash we record for archived contents, and the id of the key it is keyed with.  Without a
+// keyring it is a plain SHA-256 and the id is empty; with a keyring it is an HMAC-SHA256 keyed from the primary key,
+// so the hashes of encrypted objects cannot be matched against guessed contents.
+func (k *Keyring) ContentHash() (hash.Hash, string) {
+	if k == nil {
+		return sha256.New(), ""
+	}
+	return hmac.New(sha256.New, k.hashKeys[k.primary]), k.primary
+}
+
+// contentHashWithKey returns the hash for checking contents hashed with the key id by ContentHash
+func (k *Keyring) contentHashWithKey(id string) (hash.Hash, error) {
+	if id == "" {
+		return sha256.New(), nil
+	}
+	if k == nil {
+		return nil, fmt.Errorf("contents were hashed with key %q, but no encryption keys are configured", id)
+	}
+	hashKey := k.hashKeys[id]
+	if hashKey == nil {
+		return nil, fmt.Errorf("contents were hashed with key %q, which is not in the keyring", id)
+	}
+	return hmac.New(sha256.New, hashKey), nil
+}
+
+// headerPrefix is the start of the header, which authenticates the wrapped data key
+func headerPrefix(id string) []byte {
+	b := []byte(encryptionMagic)
+	b = append(b, encryptionVersion, byte(len(id)))
+	return append(b, id...)
+}
+
+// chunkNonce builds the nonce for a chunk; as each data key is only used for one object, a counter is sufficient
+func chunkNonce(aead cipher.AEAD, chunk uint64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, chunk)
+	if final {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+// NewEncryptor returns a writer that encrypts to out with a new data key.  Close must be called to write the final
+// chunk; it does not close out.
+func (k *Keyring) NewEncryptor(out io.Writer) (io.WriteCloser, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("error generating data key: %v", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kek := k.keys[k.primary]
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+	header := headerPrefix(k.primary)
+	wrapped := kek.Seal(nonce, nonce, dataKey, header)
+
+	header = append(header, 0, 0)
+	binary.BigEndian.PutUint16(header[len(header)-2:], uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	if _, err := out.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptor{
+		out:  out,
+		aead: aead,
+	}, nil
+}
+
+type encryptor struct {
+	out    io.Writer
+	aead   cipher.AEAD
+	buffer []byte
+	chunk  uint64
+	closed bool
+}
+
+func (e *encryptor) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("write to closed encryptor")
+	}
+	e.buffer = append(e.buffer, p...)
+	// We only know a chunk is not the final one once there is more data
+	for len(e.buffer) > encryptionChunkSize {
+		if err := e.writeChunk(e.buffer[:encryptionChunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buffer = e.buffer[encryptionChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (e *encryptor) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.writeChunk(e.buffer, true)
+}
+
+func (e *encryptor) writeChunk(data []byte, final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.chunk, final), data, nil)
+	e.chunk++
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := e.out.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := e.out.Write(sealed)
+	return err
+}
+
+// NewDecryptor returns a reader of the decrypted contents of in, unwrapping the data key with the key named in the header
+func (k *Keyring) NewDecryptor(in io.Reader) (io.Reader, error) {
+	prefix := make([]byte, len(encryptionMagic)+2)
+	if _, err := io.ReadFull(in, prefix); err != nil {
+		return nil, fmt.Errorf("error reading encryption header: %v", err)
+	}
+	if string(prefix[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, fmt.Errorf("not an encrypted object")
+	}
+	if version := prefix[len(encryptionMagic)]; version != encryptionVersion {
+		return nil, fmt.Errorf("unknown encryption version %d", version)
+	}
+
+	rest := make([]byte, int(prefix[len(prefix)-1])+2)
+	if _, err := io.ReadFull(in, rest); err != nil {
+		return nil, fmt.Errorf("error reading encryption header: %v", err)
+	}
+	id := string(rest[:len(rest)-2])
+	wrapped := make([]byte, binary.BigEndian.Uint16(rest[len(rest)-2:]))
+	if _, err := io.ReadFull(in, wrapped); err != nil {
+		return nil, fmt.Errorf("error reading encryption header: %v", err)
+	}
+
+	kek := k.keys[id]
+	if kek == nil {
+		return nil, fmt.Errorf("object was encrypted with key %q, which is not in the keyring", id)
+	}
+	if len(wrapped) < kek.NonceSize() {
+		return nil, fmt.Errorf("invalid encryption header")
+	}
+	dataKey, err := kek.Open(nil, wrapped[:kek.NonceSize()], wrapped[kek.NonceSize():], headerPrefix(id))
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key with key %q: %v", id, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptor{
+		in:   in,
+		aead: aead,
+	}, nil
+}
+
+type decryptor struct {
+	in     io.Reader
+	aead   cipher.AEAD
+	buffer []byte
+	chunk  uint64
+	final  bool
+}
+
+func (d *decryptor) Read(p []byte) (int, error) {
+	for len(d.buffer) == 0 {
+		if d.final {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buffer)
+	d.buffer = d.buffer[n:]
+	return n, nil
+}
+
+func (d *decryptor) readChunk() error {
+	var length [4]byte
+	if _, err := io.ReadFull(d.in, length[:]); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("encrypted object is truncated")
+		}
+		return err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > encryptionChunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("invalid encrypted chunk length %d", n)
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(d.in, sealed); err != nil {
+		return err
+	}
+
+	// Try as an intermediate chunk first, then as the final chunk
+	data, err := d.aead.Open(nil, chunkNonce(d.aead, d.chunk, false), sealed, nil)
+	if err != nil {
+		data, err = d.aead.Open(nil, chunkNonce(d.aead, d.chunk, true), sealed, nil)
+		if err != nil {
+			return fmt.Errorf("error decrypting chunk %d: %v", d.chunk, err)
+		}
+		d.final = true
+
+		var extra [1]byte
+		if _, err := io.ReadFull(d.in, extra[:]); err == nil {
+			return fmt.Errorf("unexpected data after final encrypted chunk")
+		}
+	}
+	d.chunk++
+	d.buffer = data
+	return nil
+}
@@ -0,0 +1,310 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testKey returns a deterministic key line for the keyring
+func testKey(id string, seed int64) string {
+	key := make([]byte, dataKeySize)
+	rand.New(rand.NewSource(seed)).Read(key)
+	return id + "=" + base64.StdEncoding.EncodeToString(key)
+}
+
+func newTestKeyring(t *testing.T, lines ...string) *Keyring {
+	k, err := ParseKeyring([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("error parsing keyring: %v", err)
+	}
+	return k
+}
+
+// encrypt encrypts data, writing it in uneven pieces
+func encrypt(t *testing.T, k *Keyring, data []byte) []byte {
+	var b bytes.Buffer
+	e, err := k.NewEncryptor(&b)
+	if err != nil {
+		t.Fatalf("error building encryptor: %v", err)
+	}
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := e.Write(data[:n]); err != nil {
+			t.Fatalf("error encrypting: %v", err)
+		}
+		data = data[n:]
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("error closing encryptor: %v", err)
+	}
+	return b.Bytes()
+}
+
+func decrypt(k *Keyring, data []byte) ([]byte, error) {
+	d, err := k.NewDecryptor(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(d)
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// headerLength returns the length of the header of an object encrypted with the key id
+func headerLength(k *Keyring, id string) int {
+	kek := k.keys[id]
+	return len(headerPrefix(id)) + 2 + kek.NonceSize() + dataKeySize + kek.Overhead()
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	k := newTestKeyring(t, testKey("k1", 1))
+	sealedChunk := 4 + encryptionChunkSize + 16
+
+	grid := []struct {
+		size   int
+		chunks int
+	}{
+		{size: 0, chunks: 1},
+		{size: 1, chunks: 1},
+		{size: encryptionChunkSize - 1, chunks: 1},
+		{size: encryptionChunkSize, chunks: 1},
+		{size: encryptionChunkSize + 1, chunks: 2},
+		{size: 3 * encryptionChunkSize, chunks: 3},
+	}
+	for _, g := range grid {
+		data := testData(g.size)
+		encrypted := encrypt(t, k, data)
+
+		// Each chunk adds its length and the GCM tag
+		expectedSize := headerLength(k, "k1") + g.size + g.chunks*(sealedChunk-encryptionChunkSize)
+		if len(encrypted) != expectedSize {
+			t.Errorf("size %d: encrypted to %d bytes, expected %d", g.size, len(encrypted), expectedSize)
+		}
+		if g.size > 16 && bytes.Contains(encrypted, data[:16]) {
+			t.Errorf("size %d: plaintext found in encrypted object", g.size)
+		}
+
+		decrypted, err := decrypt(k, encrypted)
+		if err != nil {
+			t.Errorf("size %d: error decrypting: %v", g.size, err)
+		} else if !bytes.Equal(decrypted, data) {
+			t.Errorf("size %d: decrypted data does not match", g.size)
+		}
+	}
+}
+
+func TestEncryptionTampering(t *testing.T) {
+	k := newTestKeyring(t, testKey("k1", 1))
+	data := testData(3*encryptionChunkSize + 100)
+	encrypted := encrypt(t, k, data)
+
+	header := headerLength(k, "k1")
+	sealedChunk := 4 + encryptionChunkSize + k.keys["k1"].Overhead()
+	chunk := func(i int) []byte {
+		return encrypted[header+i*sealedChunk : header+(i+1)*sealedChunk]
+	}
+
+	flip := func(offset int) []byte {
+		b := append([]byte(nil), encrypted...)
+		b[offset] ^= 1
+		return b
+	}
+	concat := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	grid := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{name: "magic", data: flip(0), expected: "not an encrypted object"},
+		{name: "version", data: flip(len(encryptionMagic)), expected: "unknown encryption version"},
+		{name: "key id", data: flip(len(encryptionMagic) + 2), expected: "not in the keyring"},
+		{name: "wrapped data key", data: flip(header - 1), expected: "error unwrapping data key"},
+		{name: "first chunk", data: flip(header + 10), expected: "error decrypting chunk 0"},
+		{name: "final chunk", data: flip(len(encrypted) - 1), expected: "error decrypting chunk 3"},
+		{name: "chunk length", data: flip(header), expected: "invalid encrypted chunk length"},
+		{
+			name:     "reordered chunks",
+			data:     concat(encrypted[:header], chunk(1), chunk(0), encrypted[header+2*sealedChunk:]),
+			expected: "error decrypting chunk 0",
+		},
+		{
+			name:     "truncated at chunk boundary",
+			data:     encrypted[:header+2*sealedChunk],
+			expected: "encrypted object is truncated",
+		},
+		{
+			name:     "final chunk dropped",
+			data:     encrypted[:header+3*sealedChunk],
+			expected: "encrypted object is truncated",
+		},
+		{
+			name:     "truncated within chunk",
+			data:     encrypted[:header+sealedChunk+100],
+			expected: "unexpected EOF",
+		},
+		{
+			name:     "data after final chunk",
+			data:     concat(encrypted, []byte{0}),
+			expected: "unexpected data after final encrypted chunk",
+		},
+		{
+			name:     "truncated header",
+			data:     encrypted[:header-1],
+			expected: "error reading encryption header",
+		},
+	}
+	for _, g := range grid {
+		_, err := decrypt(k, g.data)
+		if err == nil {
+			t.Errorf("%s: expected error decrypting", g.name)
+		} else if !strings.Contains(err.Error(), g.expected) {
+			t.Errorf("%s: error was %q, expected %q", g.name, err, g.expected)
+		}
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	before := newTestKeyring(t, testKey("k1", 1))
+	data := testData(encryptionChunkSize + 1)
+	old := encrypt(t, before, data)
+
+	// k2 is now the primary; k1 is kept to read older objects
+	rotated := newTestKeyring(t, "# rotated", testKey("k2", 2), "", testKey("k1", 1))
+	if decrypted, err := decrypt(rotated, old); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("unable to read object written with the secondary key: %v", err)
+	}
+
+	current := encrypt(t, rotated, data)
+	if !bytes.HasPrefix(current, headerPrefix("k2")) {
+		t.Errorf("new objects are not encrypted with the primary key")
+	}
+	if _, err := decrypt(before, current); err == nil || !strings.Contains(err.Error(), `key "k2", which is not in the keyring`) {
+		t.Errorf("unexpected error reading with the old keyring: %v", err)
+	}
+
+	// Once k1 is dropped, older objects fail with an error naming the key
+	onlyNew := newTestKeyring(t, testKey("k2", 2))
+	if _, err := decrypt(onlyNew, old); err == nil || !strings.Contains(err.Error(), `key "k1", which is not in the keyring`) {
+		t.Errorf("unexpected error reading with the key removed: %v", err)
+	}
+
+	// The same id with a different key cannot unwrap the data key
+	replaced := newTestKeyring(t, testKey("k1", 3))
+	if _, err := decrypt(replaced, old); err == nil || !strings.Contains(err.Error(), `error unwrapping data key with key "k1"`) {
+		t.Errorf("unexpected error reading with a replaced key: %v", err)
+	}
+}
+
+func TestDecodeEncrypted(t *testing.T) {
+	k := newTestKeyring(t, testKey("k1", 1))
+	var b bytes.Buffer
+	e, err := k.NewEncryptor(&b)
+	if err != nil {
+		t.Fatalf("error building encryptor: %v", err)
+	}
+	fmt.Fprintf(e, "hello\n")
+	e.Close()
+
+	in, err := Decode("pods/uid/app.log"+EncryptedSuffix, ioutil.NopCloser(bytes.NewReader(b.Bytes())), k)
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	if data, err := ioutil.ReadAll(in); err != nil || string(data) != "hello\n" {
+		t.Errorf("decoded %q, %v", data, err)
+	}
+
+	if _, err := Decode("pods/uid/app.log"+EncryptedSuffix, ioutil.NopCloser(bytes.NewReader(b.Bytes())), nil); err == nil || !strings.Contains(err.Error(), "no encryption keys are configured") {
+		t.Errorf("unexpected error decoding without a keyring: %v", err)
+	}
+}
+
+func TestParseKeyringInvalid(t *testing.T) {
+	grid := []struct {
+		data     string
+		expected string
+	}{
+		{data: "", expected: "no keys found"},
+		{data: "# only a comment\n", expected: "no keys found"},
+		{data: "k1", expected: "invalid line"},
+		{data: "k1=not base64!", expected: "invalid key"},
+		{data: "k1=" + base64.StdEncoding.EncodeToString([]byte("short")), expected: "must be 32 bytes"},
+		{data: testKey("k1", 1) + "\n" + testKey("k1", 2), expected: "duplicate key id"},
+	}
+	for _, g := range grid {
+		_, err := ParseKeyring([]byte(g.data))
+		if err == nil || !strings.Contains(err.Error(), g.expected) {
+			t.Errorf("ParseKeyring(%q) error was %v, expected %q", g.data, err, g.expected)
+		}
+	}
+}
+
+func contentHash(t *testing.T, k *Keyring, data []byte) (string, string) {
+	hasher, id := k.ContentHash()
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil)), id
+}
+
+func TestContentHash(t *testing.T) {
+	data := []byte("2017-01-02T03:04:05Z a line that is easy to guess\n")
+	plain := sha256.Sum256(data)
+
+	// Without a keyring we record the plain SHA-256
+	if hash, id := contentHash(t, nil, data); hash != hex.EncodeToString(plain[:]) || id != "" {
+		t.Errorf("hash without keyring was %s (key %q), expected the SHA-256", hash, id)
+	}
+
+	k1 := newTestKeyring(t, testKey("k1", 1))
+	hash, id := contentHash(t, k1, data)
+	if hash == hex.EncodeToString(plain[:]) || id != "k1" {
+		t.Fatalf("hash with keyring was %s (key %q), expected a keyed hash", hash, id)
+	}
+	if again, _ := contentHash(t, newTestKeyring(t, testKey("k1", 1)), data); again != hash {
+		t.Errorf("hash with the same key was %s, expected %s", again, hash)
+	}
+	if other, _ := contentHash(t, newTestKeyring(t, testKey("k1", 2)), data); other == hash {
+		t.Errorf("hash with a different key was the same")
+	}
+
+	// After rotation, contents hashed with the old key can still be checked
+	rotated := newTestKeyring(t, testKey("k2", 2), testKey("k1", 1))
+	if _, id := contentHash(t, rotated, data); id != "k2" {
+		t.Errorf("rotated keyring hashed with key %q, expected k2", id)
+	}
+	hasher, err := rotated.contentHashWithKey("k1")
+	if err != nil {
+		t.Fatalf("error building hash for k1: %v", err)
+	}
+	hasher.Write(data)
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != hash {
+		t.Errorf("hash with old key was %s, expected %s", actual, hash)
+	}
+
+	if _, err := newTestKeyring(t, testKey("k2", 2)).contentHashWithKey("k1"); err == nil || !strings.Contains(err.Error(), "not in the keyring") {
+		t.Errorf("unexpected error for missing hash key: %v", err)
+	}
+	var none *Keyring
+	if _, err := none.contentHashWithKey("k1"); err == nil {
+		t.Errorf("expected error for keyed hash without a keyring")
+	}
+}
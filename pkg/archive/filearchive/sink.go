@@ -0,0 +1,191 @@
+package filearchive
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
+	"kope.io/klogs/pkg/proto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sink copies files into a directory, e.g. a local disk or an NFS mount.  Files are encrypted if the URL sets
+// encryptionKeys=<path of keyring> (see archive.ParseKeyring), and linked into per-stream hash chains if it sets
+// hashChain=true.
+type Sink struct {
+	basedir string
+	// keyring is nil if files are not encrypted
+	keyring   *archive.Keyring
+	hashChain bool
+}
+
+var _ archive.Sink = &Sink{}
+var _ archive.ObjectStore = &Sink{}
+var _ archive.Deleter = &Sink{}
+var _ archive.KeyringReader = &Sink{}
+
+func init() {
+	archive.RegisterSink("file", func(u *url.URL) (archive.Sink, error) {
+		return NewSink(u)
+	})
+}
+
+func NewSink(u *url.URL) (*Sink, error) {
+	if u.Host != "" {
+		return nil, fmt.Errorf("file archive location must be file:///<path>, was %q", archive.RedactLocation(u.String()))
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("file archive location must include a path")
+	}
+
+	s := &Sink{
+		basedir: u.Path,
+	}
+	query := u.Query()
+	if hashChain := query.Get("hashChain"); hashChain != "" {
+		b, err := strconv.ParseBool(hashChain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hashChain %q: %v", hashChain, err)
+		}
+		s.hashChain = b
+	}
+	if keys := query.Get("encryptionKeys"); keys != "" {
+		keyring, err := archive.LoadKeyring(keys)
+		if err != nil {
+			return nil, err
+		}
+		s.keyring = keyring
+	}
+	if err := os.MkdirAll(s.basedir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating archive directory %q: %v", s.basedir, err)
+	}
+	return s, nil
+}
+
+func (s *Sink) AddToArchive(sourcePath string, key string, fileInfo *proto.LogFile) error {
+	glog.V(2).Infof("found file to archive: %q %q", sourcePath, fileInfo)
+	storedKey := key
+	if s.keyring != nil {
+		storedKey += archive.EncryptedSuffix
+	}
+	dest := filepath.Join(s.basedir, filepath.FromSlash(storedKey))
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating directory for %q: %v", dest, err)
+	}
+
+	in, err := os.OpenFile(sourcePath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open file %q: %v", sourcePath, err)
+	}
+	defer in.Close()
+
+	hasher, hashKey := s.keyring.ContentHash()
+	source := io.TeeReader(in, hasher)
+
+	// Write to a temporary file, so readers never see a partial copy
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating %q: %v", tmp, err)
+	}
+	defer os.Remove(tmp)
+
+	if s.keyring != nil {
+		var encryptor io.WriteCloser
+		encryptor, err = s.keyring.NewEncryptor(out)
+		if err == nil {
+			_, err = io.Copy(encryptor, source)
+		}
+		if err == nil {
+			err = encryptor.Close()
+		}
+	} else {
+		_, err = io.Copy(out, source)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %q: %v", tmp, err)
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("error renaming %q to %q: %v", tmp, dest, err)
+	}
+	glog.V(2).Infof("Copied file to %s", dest)
+
+	return archive.WriteManifest(s, storedKey, fileInfo, hex.EncodeToString(hasher.Sum(nil)), hashKey, s.hashChain)
+}
+
+func (s *Sink) Keyring() *archive.Keyring {
+	return s.keyring
+}
+
+func (s *Sink) PutObject(key string, data []byte) error {
+	p := filepath.Join(s.basedir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("error creating directory for %q: %v", p, err)
+	}
+	if err := ioutil.WriteFile(p+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("error writing %q: %v", p, err)
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func (s *Sink) List(prefix string) ([]*archive.Object, error) {
+	root := filepath.Join(s.basedir, filepath.FromSlash(prefix))
+
+	var objects []*archive.Object
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.basedir, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, &archive.Object{
+			Key:          filepath.ToSlash(rel),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %q: %v", root, err)
+	}
+	return objects, nil
+}
+
+func (s *Sink) Open(key string) (io.ReadCloser, error) {
+	p := filepath.Join(s.basedir, filepath.FromSlash(key))
+	f, err := os.OpenFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &archive.NotFoundError{Key: key}
+		}
+		return nil, fmt.Errorf("unable to open file %q: %v", p, err)
+	}
+	return archive.Decode(key, f, s.keyring)
+}
+
+func (s *Sink) Delete(key string) error {
+	p := filepath.Join(s.basedir, filepath.FromSlash(key))
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+package filearchive
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "filearchive")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	return dir
+}
+
+// newTestSink builds a sink through the registry, as the spoke does
+func newTestSink(t *testing.T, dir string, query string) *Sink {
+	sink, err := archive.NewSink("file://" + filepath.Join(dir, "archive") + query)
+	if err != nil {
+		t.Fatalf("error building sink: %v", err)
+	}
+	return sink.(*Sink)
+}
+
+// archiveFile writes the data to a source file and archives it under key
+func archiveFile(t *testing.T, s *Sink, key string, data string, fileInfo *proto.LogFile) {
+	p := filepath.Join(s.basedir, "..", "source.log")
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatalf("error writing %q: %v", p, err)
+	}
+	if err := s.AddToArchive(p, key, fileInfo); err != nil {
+		t.Fatalf("error archiving %q: %v", key, err)
+	}
+}
+
+func readObject(t *testing.T, s *Sink, key string) string {
+	in, err := s.Open(key)
+	if err != nil {
+		t.Fatalf("error opening %q: %v", key, err)
+	}
+	defer in.Close()
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		t.Fatalf("error reading %q: %v", key, err)
+	}
+	return string(data)
+}
+
+func listKeys(t *testing.T, reader archive.Reader, prefix string) []string {
+	objects, err := reader.List(prefix)
+	if err != nil {
+		t.Fatalf("error listing %q: %v", prefix, err)
+	}
+	var keys []string
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func manifestKeys(manifests []*archive.Manifest) []string {
+	var keys []string
+	for _, m := range manifests {
+		keys = append(keys, m.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func namespaceFields(namespace string) *proto.Fields {
+	return &proto.Fields{Fields: []*proto.Field{{Key: "pod.namespace", Value: namespace}}}
+}
+
+var day1 = time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestSink(t, dir, "")
+
+	archiveFile(t, s, "pods/uid-1/logs/app.log", "app line\n", &proto.LogFile{Path: "logs/app.log"})
+	archiveFile(t, s, "containers/shop/web-0/web/cid-1/0.log", "container line\n", &proto.LogFile{Path: "0.log"})
+
+	if actual := readObject(t, s, "pods/uid-1/logs/app.log"); actual != "app line\n" {
+		t.Errorf("read %q", actual)
+	}
+	if actual := readObject(t, s, "containers/shop/web-0/web/cid-1/0.log"); actual != "container line\n" {
+		t.Errorf("read %q", actual)
+	}
+	if _, err := s.Open("pods/uid-1/logs/missing.log"); !archive.IsNotFound(err) {
+		t.Errorf("expected NotFoundError, was %v", err)
+	}
+
+	expected := []string{"pods/uid-1/logs/app.log"}
+	if actual := listKeys(t, s, "pods"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("listed %q, expected %q", actual, expected)
+	}
+	if actual := listKeys(t, s, "no-such-prefix"); actual != nil {
+		t.Errorf("listed %q for missing prefix", actual)
+	}
+
+	m, err := archive.ReadManifest(s, archive.ManifestKey("pods/uid-1/logs/app.log"))
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if m.Key != "pods/uid-1/logs/app.log" || m.File.Path != "logs/app.log" || m.Sha256 == "" || m.Chain != nil {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+}
+
+func TestManifests(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestSink(t, dir, "")
+
+	// Spans day1 and day1+1
+	archiveFile(t, s, "pods/uid-1/logs/a.log", "a\n", &proto.LogFile{
+		MinTimestamp: uint64(day1.UnixNano()),
+		MaxTimestamp: uint64(day1.Add(20 * time.Hour).UnixNano()),
+	})
+	// No timestamps, so indexed by modification time on day1+2
+	archiveFile(t, s, "pods/uid-2/logs/b.log", "b\n", &proto.LogFile{LastModified: day1.AddDate(0, 0, 2).Unix()})
+	// Without a manifest
+	if err := s.PutObject("pods/uid-3/logs/c.log", []byte("c\n")); err != nil {
+		t.Fatalf("error writing object: %v", err)
+	}
+
+	manifests, err := archive.ListManifests(s, "")
+	if err != nil {
+		t.Fatalf("error listing manifests: %v", err)
+	}
+	expected := []string{"pods/uid-1/logs/a.log", "pods/uid-2/logs/b.log", "pods/uid-3/logs/c.log"}
+	if actual := manifestKeys(manifests); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("listed manifests %q, expected %q", actual, expected)
+	}
+	for _, m := range manifests {
+		if m.Key == "pods/uid-3/logs/c.log" && (m.File.Size != 2 || m.Sha256 != "") {
+			t.Errorf("unexpected manifest for object without manifest: %+v", m)
+		}
+	}
+
+	grid := []struct {
+		start    time.Time
+		end      time.Time
+		expected []string
+	}{
+		{start: day1, end: day1, expected: []string{"pods/uid-1/logs/a.log"}},
+		{start: day1.AddDate(0, 0, 1), end: day1.AddDate(0, 0, 2), expected: []string{"pods/uid-1/logs/a.log", "pods/uid-2/logs/b.log"}},
+		{start: day1.AddDate(0, 0, 2), end: day1.AddDate(0, 0, 2), expected: []string{"pods/uid-2/logs/b.log"}},
+		{start: day1.AddDate(0, 0, 3), end: day1.AddDate(0, 0, 10), expected: nil},
+	}
+	for _, g := range grid {
+		manifests, err := archive.ListManifestsByDay(s, g.start, g.end)
+		if err != nil {
+			t.Fatalf("error listing manifests by day: %v", err)
+		}
+		if actual := manifestKeys(manifests); !reflect.DeepEqual(actual, g.expected) {
+			t.Errorf("manifests from %v to %v were %q, expected %q", g.start, g.end, actual, g.expected)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestSink(t, dir, "")
+
+	now := day1.AddDate(0, 0, 10)
+	archiveFile(t, s, "pods/uid-1/logs/old.log", "old\n", &proto.LogFile{
+		Fields:       namespaceFields("shop"),
+		MinTimestamp: uint64(now.Add(-72 * time.Hour).UnixNano()),
+		MaxTimestamp: uint64(now.Add(-48 * time.Hour).UnixNano()),
+	})
+	archiveFile(t, s, "pods/uid-2/logs/system.log", "system\n", &proto.LogFile{
+		Fields:       namespaceFields("kube-system"),
+		MaxTimestamp: uint64(now.Add(-48 * time.Hour).UnixNano()),
+	})
+	archiveFile(t, s, "pods/uid-3/logs/new.log", "new\n", &proto.LogFile{
+		Fields:       namespaceFields("shop"),
+		MaxTimestamp: uint64(now.Add(-time.Hour).UnixNano()),
+	})
+
+	policy := &archive.RetentionPolicy{
+		MaxAge:          24 * time.Hour,
+		NamespaceMaxAge: map[string]time.Duration{"kube-system": 7 * 24 * time.Hour},
+	}
+
+	before := listKeys(t, s, "")
+	removed, err := archive.Prune(s, policy, now, true)
+	if err != nil {
+		t.Fatalf("error pruning: %v", err)
+	}
+	if actual := manifestKeys(removed); !reflect.DeepEqual(actual, []string{"pods/uid-1/logs/old.log"}) {
+		t.Errorf("dry run would remove %q", actual)
+	}
+	if after := listKeys(t, s, ""); !reflect.DeepEqual(after, before) {
+		t.Errorf("dry run removed objects: %q", after)
+	}
+
+	removed, err = archive.Prune(s, policy, now, false)
+	if err != nil {
+		t.Fatalf("error pruning: %v", err)
+	}
+	if actual := manifestKeys(removed); !reflect.DeepEqual(actual, []string{"pods/uid-1/logs/old.log"}) {
+		t.Errorf("removed %q", actual)
+	}
+	if response := archive.PruneResponse(removed); response.Size != 0 || len(response.Files) != 1 {
+		t.Errorf("unexpected prune response %v", response)
+	}
+
+	// The file, its manifest and its index entries are gone; the other files are untouched
+	for _, key := range listKeys(t, s, "") {
+		if m, err := archive.ReadManifest(s, key); err == nil && m.Key == "pods/uid-1/logs/old.log" {
+			t.Errorf("index entry or manifest %q left behind", key)
+		}
+		if key == "pods/uid-1/logs/old.log" {
+			t.Errorf("file was not removed")
+		}
+	}
+	manifests, err := archive.ListManifests(s, "")
+	if err != nil {
+		t.Fatalf("error listing manifests: %v", err)
+	}
+	expected := []string{"pods/uid-2/logs/system.log", "pods/uid-3/logs/new.log"}
+	if actual := manifestKeys(manifests); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("remaining files were %q, expected %q", actual, expected)
+	}
+}
+
+func TestPruneGrownFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestSink(t, dir, "")
+
+	// The file is archived covering day1, and again after it was truncated and grew, covering the next two days
+	archiveFile(t, s, "pods/uid-1/logs/app.log", "first\n", &proto.LogFile{
+		MinTimestamp: uint64(day1.UnixNano()),
+		MaxTimestamp: uint64(day1.Add(time.Hour).UnixNano()),
+	})
+	archiveFile(t, s, "pods/uid-1/logs/app.log", "second\nthird\n", &proto.LogFile{
+		MinTimestamp: uint64(day1.AddDate(0, 0, 1).UnixNano()),
+		MaxTimestamp: uint64(day1.AddDate(0, 0, 2).UnixNano()),
+	})
+	if index := listKeys(t, s, "index"); len(index) != 3 {
+		t.Fatalf("expected 3 index entries, found %q", index)
+	}
+
+	policy := &archive.RetentionPolicy{MaxAge: 24 * time.Hour}
+	removed, err := archive.Prune(s, policy, day1.AddDate(0, 0, 10), false)
+	if err != nil {
+		t.Fatalf("error pruning: %v", err)
+	}
+	if len(removed) != 1 {
+		t.Errorf("removed %d files, expected 1", len(removed))
+	}
+	if remaining := listKeys(t, s, ""); len(remaining) != 0 {
+		t.Errorf("objects left behind: %q", remaining)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const stream = "containers/cid-1"
+	segments := []string{
+		"containers/shop/web-0/web/cid-1/0.log.20170102",
+		"containers/shop/web-0/web/cid-1/0.log.20170103",
+		"containers/shop/web-0/web/cid-1/0.log",
+	}
+
+	type problem struct {
+		Key  string
+		Type proto.ArchiveProblemType
+	}
+	grid := []struct {
+		name       string
+		tamper     func(s *Sink)
+		verified   int32
+		unverified int32
+		problems   []problem
+	}{
+		{
+			name:     "intact",
+			tamper:   func(s *Sink) {},
+			verified: 3,
+		},
+		{
+			name: "corrupted file",
+			tamper: func(s *Sink) {
+				ioutil.WriteFile(filepath.Join(s.basedir, segments[0]), []byte("altered\n"), 0644)
+			},
+			verified: 2,
+			problems: []problem{{Key: segments[0], Type: proto.ArchiveProblemType_CORRUPTED}},
+		},
+		{
+			name: "missing file",
+			tamper: func(s *Sink) {
+				s.Delete(segments[1])
+			},
+			verified: 2,
+			problems: []problem{{Key: segments[1], Type: proto.ArchiveProblemType_MISSING}},
+		},
+		{
+			name: "missing segment",
+			tamper: func(s *Sink) {
+				s.Delete(segments[1])
+				s.Delete(archive.ManifestKey(segments[1]))
+			},
+			verified: 2,
+			problems: []problem{{Key: stream, Type: proto.ArchiveProblemType_MISSING}},
+		},
+		{
+			name: "missing latest segment",
+			tamper: func(s *Sink) {
+				s.Delete(segments[2])
+				s.Delete(archive.ManifestKey(segments[2]))
+			},
+			verified: 2,
+			problems: []problem{{Key: stream, Type: proto.ArchiveProblemType_MISSING}},
+		},
+		{
+			name: "file without manifest",
+			tamper: func(s *Sink) {
+				s.PutObject("pods/uid-1/logs/app.log", []byte("app\n"))
+			},
+			verified:   3,
+			unverified: 1,
+		},
+	}
+	for _, g := range grid {
+		dir := tempDir(t)
+		s := newTestSink(t, dir, "?hashChain=true")
+		for i, key := range segments {
+			archiveFile(t, s, key, key+"\n", &proto.LogFile{Path: key})
+			// Archiving the latest segment again (e.g. it grew) replaces its link
+			if i == len(segments)-1 {
+				archiveFile(t, s, key, key+"\nmore\n", &proto.LogFile{Path: key})
+			}
+		}
+		g.tamper(s)
+
+		response, err := archive.Verify(s)
+		os.RemoveAll(dir)
+		if err != nil {
+			t.Fatalf("%s: error verifying: %v", g.name, err)
+		}
+
+		var problems []problem
+		for _, p := range response.Problems {
+			problems = append(problems, problem{Key: p.Key, Type: p.Type})
+		}
+		if response.Verified != g.verified || response.Unverified != g.unverified || !reflect.DeepEqual(problems, g.problems) {
+			t.Errorf("%s: verified %d, unverified %d, problems %v; expected %d, %d, %v", g.name, response.Verified, response.Unverified, response.Problems, g.verified, g.unverified, g.problems)
+		}
+	}
+}
+
+func TestVerifyRearchivedSegment(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestSink(t, dir, "?hashChain=true")
+
+	// b grows again after c was archived, and then again while it is the latest segment
+	archiveFile(t, s, "pods/uid-1/logs/a.log", "a\n", &proto.LogFile{})
+	archiveFile(t, s, "pods/uid-1/logs/b.log", "b\n", &proto.LogFile{})
+	archiveFile(t, s, "pods/uid-1/logs/c.log", "c\n", &proto.LogFile{})
+	archiveFile(t, s, "pods/uid-1/logs/b.log", "b\nmore\n", &proto.LogFile{})
+	archiveFile(t, s, "pods/uid-1/logs/b.log", "b\nmore\nand more\n", &proto.LogFile{})
+	archiveFile(t, s, "pods/uid-1/logs/a.log", "a\nmore\n", &proto.LogFile{})
+
+	response, err := archive.Verify(s)
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	if response.Verified != 3 || len(response.Problems) != 0 {
+		t.Errorf("verified %d, problems %v; expected 3 verified and no problems", response.Verified, response.Problems)
+	}
+
+	m, err := archive.ReadManifest(s, archive.ManifestKey("pods/uid-1/logs/b.log"))
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	var sequences []int64
+	for _, link := range m.Superseded {
+		sequences = append(sequences, link.Sequence)
+	}
+	if m.Chain.Sequence != 4 || !reflect.DeepEqual(sequences, []int64{2}) {
+		t.Errorf("b has link %d and superseded links %v, expected 4 and [2]", m.Chain.Sequence, sequences)
+	}
+
+	// Removing b removes both of its links
+	s.Delete("pods/uid-1/logs/b.log")
+	s.Delete(archive.ManifestKey("pods/uid-1/logs/b.log"))
+	response, err = archive.Verify(s)
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	var messages []string
+	for _, p := range response.Problems {
+		messages = append(messages, p.Message)
+	}
+	expected := []string{"segment 2 is missing", "segment 4 is missing"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("problems were %q, expected %q", messages, expected)
+	}
+}
+
+// writeKeyring writes a keyring with a key for each id, derived from the id
+func writeKeyring(t *testing.T, dir string, name string, ids ...string) string {
+	var lines []string
+	for _, id := range ids {
+		key := sha256.Sum256([]byte(id))
+		lines = append(lines, id+"="+base64.StdEncoding.EncodeToString(key[:]))
+	}
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatalf("error writing %q: %v", p, err)
+	}
+	return p
+}
+
+func TestVerifyEncrypted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestSink(t, dir, "?hashChain=true&encryptionKeys="+writeKeyring(t, dir, "keys", "k1"))
+
+	const data = "2017-01-02T03:04:05Z a line that is easy to guess\n"
+	archiveFile(t, s, "containers/shop/web-0/web/cid-1/0.log", data, &proto.LogFile{Path: "0.log", LastModified: day1.Unix()})
+
+	// The plaintext hash would let anyone confirm a guess of the contents
+	plain := sha256.Sum256([]byte(data))
+	for _, key := range listKeys(t, s, "") {
+		if !archive.IsMetadataKey(key) {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(s.basedir, key))
+		if err != nil {
+			t.Fatalf("error reading %q: %v", key, err)
+		}
+		if strings.Contains(string(contents), hex.EncodeToString(plain[:])) {
+			t.Errorf("%s contains the plaintext hash", key)
+		}
+	}
+	m, err := archive.ReadManifest(s, archive.ManifestKey("containers/shop/web-0/web/cid-1/0.log.enc"))
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if m.HashKey != "k1" || m.Sha256 == "" {
+		t.Errorf("manifest hash was %q with key %q", m.Sha256, m.HashKey)
+	}
+
+	// After rotating keys, verification checks the old objects with the key they were hashed with
+	rotated := newTestSink(t, dir, "?hashChain=true&encryptionKeys="+writeKeyring(t, dir, "rotated", "k2", "k1"))
+	archiveFile(t, rotated, "containers/shop/web-0/web/cid-1/0.log.20170103", data, &proto.LogFile{Path: "0.log.20170103", LastModified: day1.Unix()})
+	response, err := archive.Verify(rotated)
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	if response.Verified != 2 || len(response.Problems) != 0 {
+		t.Errorf("verified %d, problems %v; expected 2 verified and no problems", response.Verified, response.Problems)
+	}
+
+	// Without the old key, the old object cannot be verified
+	onlyNew := newTestSink(t, dir, "?hashChain=true&encryptionKeys="+writeKeyring(t, dir, "new", "k2"))
+	response, err = archive.Verify(onlyNew)
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	if response.Verified != 1 || len(response.Problems) != 1 || response.Problems[0].Key != m.Key {
+		t.Errorf("verified %d, problems %v; expected 1 verified and a problem with %s", response.Verified, response.Problems, m.Key)
+	}
+}
+
+func TestPruneChain(t *testing.T) {
+	now := day1.AddDate(0, 0, 10)
+	oldFile := &proto.LogFile{MaxTimestamp: uint64(now.Add(-48 * time.Hour).UnixNano())}
+	newFile := &proto.LogFile{MaxTimestamp: uint64(now.Add(-time.Hour).UnixNano())}
+	policy := &archive.RetentionPolicy{MaxAge: 24 * time.Hour}
+
+	type upload struct {
+		name string
+		file *proto.LogFile
+	}
+	grid := []struct {
+		name     string
+		uploads  []upload
+		expected []string
+	}{
+		{
+			name:     "expired prefix",
+			uploads:  []upload{{"a", oldFile}, {"b", oldFile}, {"c", newFile}, {"d", oldFile}},
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "newest first",
+			uploads:  []upload{{"a", newFile}, {"b", oldFile}, {"c", oldFile}},
+			expected: nil,
+		},
+		{
+			name:     "all expired",
+			uploads:  []upload{{"a", oldFile}, {"b", oldFile}},
+			expected: []string{"a", "b"},
+		},
+		{
+			// b is archived again after c, so it is both segment 2 and 4; c cannot go without b
+			name:     "archived again",
+			uploads:  []upload{{"a", oldFile}, {"b", oldFile}, {"c", oldFile}, {"b", newFile}},
+			expected: []string{"a"},
+		},
+		{
+			name:     "archived again, all expired",
+			uploads:  []upload{{"a", oldFile}, {"b", oldFile}, {"c", oldFile}, {"b", oldFile}, {"d", newFile}},
+			expected: []string{"a", "b", "c"},
+		},
+	}
+	for _, g := range grid {
+		dir := tempDir(t)
+		s := newTestSink(t, dir, "?hashChain=true")
+		for i, u := range g.uploads {
+			archiveFile(t, s, "pods/uid-1/logs/"+u.name+".log", fmt.Sprintf("%s %d\n", u.name, i), u.file)
+		}
+
+		removed, err := archive.Prune(s, policy, now, false)
+		if err != nil {
+			t.Fatalf("%s: error pruning: %v", g.name, err)
+		}
+		var actual []string
+		for _, m := range removed {
+			actual = append(actual, strings.TrimSuffix(path.Base(m.Key), ".log"))
+		}
+		if !reflect.DeepEqual(actual, g.expected) {
+			t.Errorf("%s: removed %q, expected %q", g.name, actual, g.expected)
+		}
+
+		response, err := archive.Verify(s)
+		os.RemoveAll(dir)
+		if err != nil {
+			t.Fatalf("%s: error verifying: %v", g.name, err)
+		}
+		if len(response.Problems) != 0 {
+			t.Errorf("%s: verify found problems after pruning: %v", g.name, response.Problems)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"kope.io/klogs/pkg/proto"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The head of the hash chain of each stream is stored under chains/<stream>.json, so the next segment can be linked
+// to it and verification can detect missing latest segments.
+const chainsPrefix = "chains"
+
+// ChainLink links an archived object to the previous segment of its stream: Hash covers Previous, Key and Sha256,
+// so altering, removing or reordering segments breaks the chain.
+type ChainLink struct {
+	// Stream identifies the chain, e.g. containers/<container id>
+	Stream   string `json:"stream"`
+	Sequence int64  `json:"sequence"`
+	Key      string `json:"key"`
+	Sha256   string `json:"sha256"`
+	// Previous is the Hash of the previous segment, empty for the first
+	Previous string `json:"previous,omitempty"`
+	Hash     string `json:"hash"`
+}
+
+// chainMutex serializes updates to the chain heads; each stream is only archived by the node it is on
+var chainMutex sync.Mutex
+
+// chainStream returns the stream of the chain for the key, or "" if the key is not from a known stream
+func chainStream(key string) string {
+	streamInfo, _ := ParseKey(key)
+	if streamInfo == nil {
+		return ""
+	}
+	return StreamID(streamInfo)
+}
+
+func chainHeadKey(stream string) string {
+	return path.Join(chainsPrefix, stream) + ".json"
+}
+
+func linkHash(previous, key, sha256Hash string) string {
+	hash := sha256.Sum256([]byte(previous + "\n" + key + "\n" + sha256Hash))
+	return hex.EncodeToString(hash[:])
+}
+
+func (l *ChainLink) computeHash() string {
+	return linkHash(l.Previous, l.Key, l.Sha256)
+}
+
+// appendToChain links the object into the chain of its stream, and records it as the chain head.
+// Archiving the latest segment again (e.g. a log file that grew) replaces its link rather than adding one.  Archiving
+// an earlier segment again adds a new link, and the earlier links of the object are returned so its manifest keeps
+// them: the segments after them are still linked to them.
+func appendToChain(store ObjectStore, key string, hash string) (*ChainLink, []*ChainLink, error) {
+	stream := chainStream(key)
+	if stream == "" {
+		return nil, nil, nil
+	}
+
+	chainMutex.Lock()
+	defer chainMutex.Unlock()
+
+	head, err := readChainLink(store, chainHeadKey(stream))
+	if err != nil && !IsNotFound(err) {
+		return nil, nil, err
+	}
+
+	// Later segments are linked to the earlier links of the object, so its manifest keeps them
+	var superseded []*ChainLink
+	if head != nil {
+		previous, err := ReadManifest(store, ManifestKey(key))
+		if err != nil && !IsNotFound(err) {
+			glog.Warningf("unable to read earlier chain links of %q: %v", key, err)
+		}
+		if previous != nil {
+			superseded = previous.Superseded
+			if head.Key != key && previous.Chain != nil {
+				superseded = append(superseded, previous.Chain)
+			}
+		}
+	}
+
+	link := &ChainLink{
+		Stream:   stream,
+		Sequence: 1,
+		Key:      key,
+		Sha256:   hash,
+	}
+	if head != nil {
+		if head.Key == key {
+			link.Sequence = head.Sequence
+			link.Previous = head.Previous
+		} else {
+			link.Sequence = head.Sequence + 1
+			link.Previous = head.Hash
+		}
+	}
+	link.Hash = link.computeHash()
+
+	data, err := json.Marshal(link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error serializing chain link: %v", err)
+	}
+	if err := store.PutObject(chainHeadKey(stream), data); err != nil {
+		return nil, nil, err
+	}
+	return link, superseded, nil
+}
+
+func readChainLink(reader Reader, key string) (*ChainLink, error) {
+	in, err := reader.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %v", key, err)
+	}
+
+	link := &ChainLink{}
+	if err := json.Unmarshal(data, link); err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", key, err)
+	}
+	return link, nil
+}
+
+// Verify re-reads every archived object, checking it against the checksum in its manifest, and checks the hash
+// chains for missing, altered or reordered segments.
+func Verify(reader Reader) (*proto.VerifyArchiveResponse, error) {
+	response := &proto.VerifyArchiveResponse{}
+	addProblem := func(key string, problemType proto.ArchiveProblemType, format string, args ...interface{}) {
+		response.Problems = append(response.Problems, &proto.ArchiveProblem{
+			Key:     key,
+			Type:    problemType,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	objects, err := reader.List("")
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, o := range objects {
+		exists[o.Key] = true
+	}
+
+	var keyring *Keyring
+	if keyringReader, ok := reader.(KeyringReader); ok {
+		keyring = keyringReader.Keyring()
+	}
+
+	manifests := make(map[string]*Manifest)
+	for _, o := range objects {
+		if !strings.HasPrefix(o.Key, manifestsPrefix+"/") {
+			continue
+		}
+		m, err := ReadManifest(reader, o.Key)
+		if err != nil {
+			addProblem(o.Key, proto.ArchiveProblemType_CORRUPTED, "unreadable manifest: %v", err)
+			continue
+		}
+		if ManifestKey(m.Key) != o.Key {
+			addProblem(o.Key, proto.ArchiveProblemType_CORRUPTED, "manifest is for %q", m.Key)
+			continue
+		}
+		manifests[m.Key] = m
+	}
+
+	for _, o := range objects {
+		if IsMetadataKey(o.Key) {
+			continue
+		}
+		m := manifests[o.Key]
+		if m == nil || m.Sha256 == "" {
+			response.Unverified++
+			continue
+		}
+
+		hash, err := hashObject(reader, o.Key, keyring, m.HashKey)
+		if err != nil {
+			addProblem(o.Key, proto.ArchiveProblemType_CORRUPTED, "%v", err)
+		} else if hash != m.Sha256 {
+			addProblem(o.Key, proto.ArchiveProblemType_CORRUPTED, "checksum is %s, expected %s", hash, m.Sha256)
+		} else {
+			response.Verified++
+		}
+	}
+
+	chains := make(map[string][]*ChainLink)
+	for key, m := range manifests {
+		if !exists[key] {
+			addProblem(key, proto.ArchiveProblemType_MISSING, "manifest exists but file is missing")
+		}
+
+		if m.Chain == nil {
+			continue
+		}
+		if m.Chain.Key != key || m.Chain.Sha256 != m.Sha256 || m.Chain.computeHash() != m.Chain.Hash {
+			addProblem(key, proto.ArchiveProblemType_CORRUPTED, "chain link does not match manifest")
+			continue
+		}
+		chains[m.Chain.Stream] = append(chains[m.Chain.Stream], m.Chain)
+
+		// Superseded links no longer match the contents, but the segments archived after them are linked to them
+		for _, link := range m.Superseded {
+			if link.Key != key || link.Stream != m.Chain.Stream || link.computeHash() != link.Hash {
+				addProblem(key, proto.ArchiveProblemType_CORRUPTED, "superseded chain link %d does not match manifest", link.Sequence)
+				continue
+			}
+			chains[link.Stream] = append(chains[link.Stream], link)
+		}
+	}
+
+	for stream, links := range chains {
+		sort.Slice(links, func(i, j int) bool { return links[i].Sequence < links[j].Sequence })
+
+		// Earlier segments may have been pruned, so we only check between the segments we have
+		for i := 1; i < len(links); i++ {
+			prev, link := links[i-1], links[i]
+			switch {
+			case link.Sequence == prev.Sequence:
+				addProblem(link.Key, proto.ArchiveProblemType_OUT_OF_SEQUENCE, "segment %d of %s is also %q", link.Sequence, stream, prev.Key)
+			case link.Sequence > prev.Sequence+1:
+				addProblem(stream, proto.ArchiveProblemType_MISSING, "%s missing", segmentRange(prev.Sequence+1, link.Sequence-1))
+			case link.Previous != prev.Hash:
+				addProblem(link.Key, proto.ArchiveProblemType_OUT_OF_SEQUENCE, "segment %d of %s does not follow %q", link.Sequence, stream, prev.Key)
+			}
+		}
+
+		last := links[len(links)-1]
+		head, err := readChainLink(reader, chainHeadKey(stream))
+		if err != nil {
+			addProblem(stream, proto.ArchiveProblemType_MISSING, "unable to read chain head: %v", err)
+			continue
+		}
+		if head.Sequence > last.Sequence {
+			addProblem(stream, proto.ArchiveProblemType_MISSING, "%s missing", segmentRange(last.Sequence+1, head.Sequence))
+		} else if head.Sequence < last.Sequence || head.Hash != last.Hash {
+			addProblem(stream, proto.ArchiveProblemType_OUT_OF_SEQUENCE, "chain head does not match segment %d", last.Sequence)
+		}
+	}
+
+	sort.SliceStable(response.Problems, func(i, j int) bool { return response.Problems[i].Key < response.Problems[j].Key })
+	return response, nil
+}
+
+func segmentRange(from, to int64) string {
+	if from == to {
+		return fmt.Sprintf("segment %d is", from)
+	}
+	return fmt.Sprintf("segments %d-%d are", from, to)
+}
+
+// hashObject returns the hex content hash of the (decoded) contents of the object, keyed by hashKey
+func hashObject(reader Reader, key string, keyring *Keyring, hashKey string) (string, error) {
+	hasher, err := keyring.contentHashWithKey(hashKey)
+	if err != nil {
+		return "", err
+	}
+
+	in, err := reader.Open(key)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	if _, err := io.Copy(hasher, in); err != nil {
+		return "", fmt.Errorf("error reading %q: %v", key, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
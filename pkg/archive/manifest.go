@@ -0,0 +1,254 @@
+package archive
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"kope.io/klogs/pkg/proto"
+	"path"
+	"strings"
+	"time"
+)
+
+// The manifest of each archived object is stored under manifests/<key>.json, and copied into the per-day index
+// as index/<yyyy-mm-dd>/<hash of key>.json for each day the file has entries for.  Index entries are independent
+// objects, so spokes never need to update a shared object.
+const (
+	manifestsPrefix = "manifests"
+	indexPrefix     = "index"
+	indexDayFormat  = "2006-01-02"
+)
+
+// Manifest describes an archived object, so searches and listings can prune without reading the objects
+type Manifest struct {
+	// Key is the key of the archived object
+	Key string `json:"key"`
+	// File is the catalog entry of the original file: fields, size, min & max timestamps
+	File       proto.LogFile `json:"file"`
+	ArchivedAt time.Time     `json:"archivedAt"`
+	// Sha256 is the hex SHA-256 of the archived contents, before compression or encryption.  For encrypted objects it
+	// is an HMAC-SHA256 keyed by HashKey, see Keyring.ContentHash.
+	Sha256 string `json:"sha256,omitempty"`
+	// HashKey is the id of the keyring key the Sha256 of an encrypted object is keyed with
+	HashKey string `json:"hashKey,omitempty"`
+	// Chain links the object into the hash chain of its stream, if enabled
+	Chain *ChainLink `json:"chain,omitempty"`
+	// Superseded are the earlier links of an object that was archived again after later segments of its stream, e.g.
+	// a pod volume file that grew again.  They no longer match the contents, but the later segments follow them.
+	Superseded []*ChainLink `json:"superseded,omitempty"`
+}
+
+// ObjectStore is implemented by sinks that can also store small metadata objects
+type ObjectStore interface {
+	Reader
+	PutObject(key string, data []byte) error
+}
+
+// ManifestKey returns the key of the manifest for the archived object
+func ManifestKey(key string) string {
+	return path.Join(manifestsPrefix, key) + ".json"
+}
+
+// IndexPrefix returns the prefix of the index entries for the day
+func IndexPrefix(day time.Time) string {
+	return path.Join(indexPrefix, day.UTC().Format(indexDayFormat))
+}
+
+// maxIndexDays is the most days we index a file under; a longer range usually means misparsed timestamps
+const maxIndexDays = 31
+
+// manifestDays returns the days covered by the file, using the modification time if it has no timestamps.
+// If the timestamps are implausible (after the modification time) we use the modification time; if they span more
+// than maxIndexDays we index only the last maxIndexDays.
+func manifestDays(file *proto.LogFile) []time.Time {
+	modified := time.Unix(file.LastModified, 0).UTC()
+	start, end := modified, modified
+	if file.MaxTimestamp != 0 {
+		end = time.Unix(0, int64(file.MaxTimestamp)).UTC()
+		start = end
+		if file.MinTimestamp != 0 {
+			start = time.Unix(0, int64(file.MinTimestamp)).UTC()
+		}
+	}
+
+	if start.After(end) {
+		start = end
+	}
+	if file.LastModified != 0 && end.After(modified.Add(24*time.Hour)) {
+		glog.Warningf("file %q has timestamps %v-%v, but was modified at %v; indexing by modification time", file.Path, start, end, modified)
+		start, end = modified, modified
+	} else if end.Sub(start) > maxIndexDays*24*time.Hour {
+		glog.Warningf("file %q has timestamps %v-%v; indexing only the last %d days", file.Path, start, end, maxIndexDays)
+		start = end.AddDate(0, 0, -(maxIndexDays - 1))
+	}
+
+	var days []time.Time
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	for !day.After(end) {
+		days = append(days, day)
+		day = day.AddDate(0, 0, 1)
+	}
+	return days
+}
+
+// WriteManifest records the manifest for the archived object, and adds it to the per-day index.
+// hash is the hex content hash of the contents, keyed by hashKey (see Keyring.ContentHash); if hashChain is set the
+// object is also linked into the chain of its stream.
+func WriteManifest(store ObjectStore, key string, fileInfo *proto.LogFile, hash string, hashKey string, hashChain bool) error {
+	m := &Manifest{
+		Key:        key,
+		File:       *fileInfo,
+		ArchivedAt: time.Now().UTC(),
+		Sha256:     hash,
+		HashKey:    hashKey,
+	}
+	if hashChain {
+		link, superseded, err := appendToChain(store, key, hash)
+		if err != nil {
+			return err
+		}
+		m.Chain = link
+		m.Superseded = superseded
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error serializing manifest: %v", err)
+	}
+
+	if err := store.PutObject(ManifestKey(key), data); err != nil {
+		return err
+	}
+
+	for _, indexKey := range indexKeys(key, fileInfo) {
+		if err := store.PutObject(indexKey, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexEntryName is the name of the index entries for the archived object, under each day
+func indexEntryName(key string) string {
+	hash := sha1.Sum([]byte(key))
+	return hex.EncodeToString(hash[:]) + ".json"
+}
+
+// indexKeys returns the keys of the index entries for the archived object
+func indexKeys(key string, fileInfo *proto.LogFile) []string {
+	name := indexEntryName(key)
+
+	var keys []string
+	for _, day := range manifestDays(fileInfo) {
+		keys = append(keys, path.Join(IndexPrefix(day), name))
+	}
+	return keys
+}
+
+// ReadManifest reads a manifest object, from manifests/ or the index
+func ReadManifest(reader Reader, manifestKey string) (*Manifest, error) {
+	in, err := reader.Open(manifestKey)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest %q: %v", manifestKey, err)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest %q: %v", manifestKey, err)
+	}
+	return m, nil
+}
+
+// IsMetadataKey returns true if the key is a manifest, index entry or chain head, rather than an archived file
+func IsMetadataKey(key string) bool {
+	for _, prefix := range []string{manifestsPrefix, indexPrefix, chainsPrefix} {
+		if strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ListManifests returns the manifests of the archived objects under the prefix.  Objects archived without a
+// manifest get a manifest with just the key, size and modification time.
+func ListManifests(reader Reader, prefix string) ([]*Manifest, error) {
+	objects, err := reader.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestObjects, err := reader.List(path.Join(manifestsPrefix, prefix))
+	if err != nil {
+		return nil, err
+	}
+	hasManifest := make(map[string]bool)
+	for _, o := range manifestObjects {
+		hasManifest[o.Key] = true
+	}
+
+	var manifests []*Manifest
+	for _, o := range objects {
+		if IsMetadataKey(o.Key) {
+			continue
+		}
+
+		manifestKey := ManifestKey(o.Key)
+		if hasManifest[manifestKey] {
+			m, err := ReadManifest(reader, manifestKey)
+			if err == nil {
+				manifests = append(manifests, m)
+				continue
+			}
+			glog.Warningf("ignoring unreadable manifest %q: %v", manifestKey, err)
+		}
+
+		manifests = append(manifests, &Manifest{
+			Key: o.Key,
+			File: proto.LogFile{
+				Path:         o.Key,
+				Size:         o.Size,
+				LastModified: o.LastModified.Unix(),
+			},
+		})
+	}
+	return manifests, nil
+}
+
+// ListManifestsByDay returns the manifests of the objects with entries on the days from start to end, using the
+// per-day index.  Objects archived without a manifest are not in the index.
+func ListManifestsByDay(reader Reader, start time.Time, end time.Time) ([]*Manifest, error) {
+	seen := make(map[string]bool)
+	var manifests []*Manifest
+
+	start = start.UTC()
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	for !day.After(end) {
+		objects, err := reader.List(IndexPrefix(day))
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects {
+			m, err := ReadManifest(reader, o.Key)
+			if err != nil {
+				glog.Warningf("ignoring unreadable index entry %q: %v", o.Key, err)
+				continue
+			}
+			if seen[m.Key] {
+				continue
+			}
+			seen[m.Key] = true
+			manifests = append(manifests, m)
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return manifests, nil
+}
@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"kope.io/klogs/pkg/proto"
+	"testing"
+	"time"
+)
+
+func TestManifestDays(t *testing.T) {
+	day := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+	at := func(days int, hours int) uint64 {
+		return uint64(day.AddDate(0, 0, days).Add(time.Duration(hours) * time.Hour).UnixNano())
+	}
+
+	grid := []struct {
+		name  string
+		file  proto.LogFile
+		first time.Time
+		days  int
+	}{
+		{
+			name:  "modification time only",
+			file:  proto.LogFile{LastModified: day.Add(5 * time.Hour).Unix()},
+			first: day,
+			days:  1,
+		},
+		{
+			name:  "three days",
+			file:  proto.LogFile{MinTimestamp: at(0, 23), MaxTimestamp: at(2, 1), LastModified: day.AddDate(0, 0, 2).Add(2 * time.Hour).Unix()},
+			first: day,
+			days:  3,
+		},
+		{
+			name:  "max timestamp only",
+			file:  proto.LogFile{MaxTimestamp: at(1, 1)},
+			first: day.AddDate(0, 0, 1),
+			days:  1,
+		},
+		{
+			// e.g. a line with a misparsed timestamp from years ago
+			name:  "span capped",
+			file:  proto.LogFile{MinTimestamp: at(-3650, 0), MaxTimestamp: at(0, 1), LastModified: day.Add(2 * time.Hour).Unix()},
+			first: day.AddDate(0, 0, -(maxIndexDays - 1)),
+			days:  maxIndexDays,
+		},
+		{
+			name:  "timestamps after modification",
+			file:  proto.LogFile{MinTimestamp: at(0, 1), MaxTimestamp: at(3650, 0), LastModified: day.Add(2 * time.Hour).Unix()},
+			first: day,
+			days:  1,
+		},
+		{
+			name:  "min after max",
+			file:  proto.LogFile{MinTimestamp: at(5, 0), MaxTimestamp: at(0, 1)},
+			first: day,
+			days:  1,
+		},
+	}
+	for _, g := range grid {
+		days := manifestDays(&g.file)
+		if len(days) != g.days {
+			t.Errorf("%s: indexed under %d days, expected %d", g.name, len(days), g.days)
+			continue
+		}
+		if !days[0].Equal(g.first) {
+			t.Errorf("%s: first day was %v, expected %v", g.name, days[0], g.first)
+		}
+		for i := 1; i < len(days); i++ {
+			if !days[i].Equal(days[i-1].AddDate(0, 0, 1)) {
+				t.Errorf("%s: day %d was %v after %v", g.name, i, days[i], days[i-1])
+			}
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package archive
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"kope.io/klogs/pkg/proto"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backoff bounds for retrying failed uploads
+var (
+	uploadInitialBackoff = time.Second
+	uploadMaxBackoff     = 5 * time.Minute
+)
+
+// UploadQueue is a Sink that uploads to another Sink in the background, retrying failed uploads with exponential backoff.
+// Pending uploads and their attempt counts are recorded in a journal directory, so they survive restarts.  Queued files are pinned with a hard
+// link (or else a copy) in the journal directory, so they can still be uploaded if the original file is deleted,
+// e.g. by kubelet removing a pod; keep the journal on the same filesystem as the logs to avoid copies.
+type UploadQueue struct {
+	sink       Sink
+	journalDir string
+	// pinDir holds the pinned files: the journal directory, or a temporary directory if there is no journal
+	pinDir string
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+	// pending are the uploads not yet completed, by key
+	pending map[string]*pendingUpload
+	// queued are the keys that are ready, in progress or waiting to retry
+	queued map[string]bool
+	// ready are the keys to upload next
+	ready []string
+	// lastPinSeq is the sequence number of the last pinned file; each upload gets its own pin
+	lastPinSeq uint64
+}
+
+var _ Sink = &UploadQueue{}
+var _ OpenFileSink = &UploadQueue{}
+
+// pendingUpload is an upload in the queue, and the journal entry for it
+type pendingUpload struct {
+	SourcePath string `json:"sourcePath"`
+	// PinnedPath is our link to (or copy of) the source file, from which we upload
+	PinnedPath string        `json:"pinnedPath,omitempty"`
+	Key        string        `json:"key"`
+	File       proto.LogFile `json:"file"`
+	Attempts   int           `json:"attempts,omitempty"`
+
+	// uploading is set while a worker is uploading; the worker then owns the pinned file
+	uploading bool
+}
+
+// NewUploadQueue builds an UploadQueue uploading to sink with concurrency workers.
+// If journalDir is empty, pending uploads are only held in memory.
+func NewUploadQueue(sink Sink, journalDir string, concurrency int) (*UploadQueue, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("upload concurrency must be at least 1, was %d", concurrency)
+	}
+
+	q := &UploadQueue{
+		sink:       sink,
+		journalDir: journalDir,
+		pinDir:     journalDir,
+		pending:    make(map[string]*pendingUpload),
+		queued:     make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+
+	if journalDir != "" {
+		if err := os.MkdirAll(journalDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating upload journal directory %q: %v", journalDir, err)
+		}
+		if err := q.loadJournal(); err != nil {
+			return nil, err
+		}
+	} else {
+		pinDir, err := ioutil.TempDir("", "klogs-upload-queue")
+		if err != nil {
+			return nil, fmt.Errorf("error creating upload queue directory: %v", err)
+		}
+		q.pinDir = pinDir
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go q.runWorker()
+	}
+	return q, nil
+}
+
+// AddToArchive queues the file for upload; a pending upload of the same key is replaced
+func (q *UploadQueue) AddToArchive(sourcePath string, key string, fileInfo *proto.LogFile) error {
+	u := &pendingUpload{
+		SourcePath: sourcePath,
+		Key:        key,
+		File:       *fileInfo,
+	}
+
+	pinnedPath := q.pinPath(sourcePath, key)
+	if err := pinFile(sourcePath, pinnedPath); err != nil {
+		glog.Warningf("unable to pin %q, will upload from the original file: %v", sourcePath, err)
+	} else {
+		u.PinnedPath = pinnedPath
+	}
+
+	return q.add(u)
+}
+
+// AddOpenFileToArchive queues the open file for upload, copying its contents so it can be uploaded even if the file
+// has been deleted
+func (q *UploadQueue) AddOpenFileToArchive(in *os.File, key string, fileInfo *proto.LogFile) error {
+	u := &pendingUpload{
+		SourcePath: in.Name(),
+		Key:        key,
+		File:       *fileInfo,
+		PinnedPath: q.pinPath(in.Name(), key),
+	}
+
+	// We read with ReadAt, so we don't depend on the file offset
+	if err := copyToFile(io.NewSectionReader(in, 0, math.MaxInt64), u.PinnedPath); err != nil {
+		return fmt.Errorf("error copying %q: %v", in.Name(), err)
+	}
+
+	return q.add(u)
+}
+
+func (q *UploadQueue) add(u *pendingUpload) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// We journal with the mutex held, so a worker recording a failed attempt of the upload we replace cannot
+	// overwrite our entry
+	if err := q.writeJournal(u); err != nil {
+		removePin(u)
+		return err
+	}
+
+	// The pinned file of an upload in progress is removed by the worker when it is done
+	if previous := q.pending[u.Key]; previous != nil && !previous.uploading {
+		removePin(previous)
+	}
+	q.pending[u.Key] = u
+	q.enqueue(u.Key)
+	return nil
+}
+
+// enqueue marks the key ready, unless it is already queued.  Must be called with the mutex held.
+func (q *UploadQueue) enqueue(key string) {
+	if q.queued[key] {
+		return
+	}
+	q.queued[key] = true
+	q.ready = append(q.ready, key)
+	q.cond.Signal()
+}
+
+func (q *UploadQueue) runWorker() {
+	for {
+		q.mutex.Lock()
+		for len(q.ready) == 0 {
+			q.cond.Wait()
+		}
+		key := q.ready[0]
+		q.ready = q.ready[1:]
+		u := q.pending[key]
+		if u == nil {
+			delete(q.queued, key)
+			q.mutex.Unlock()
+			continue
+		}
+		u.uploading = true
+		q.mutex.Unlock()
+
+		err := q.upload(u)
+
+		q.mutex.Lock()
+		u.uploading = false
+		if q.pending[key] != u {
+			// Replaced while we were uploading; the key is still queued, so we upload the replacement next
+			removePin(u)
+			q.ready = append(q.ready, key)
+			q.cond.Signal()
+			q.mutex.Unlock()
+			continue
+		}
+
+		if err != nil {
+			u.Attempts++
+			if err := q.writeJournal(u); err != nil {
+				glog.Warningf("error recording failed upload of %q: %v", u.SourcePath, err)
+			}
+			backoff := uploadBackoff(u.Attempts)
+			glog.Warningf("error uploading %q (attempt %d, will retry in %v): %v", u.SourcePath, u.Attempts, backoff, err)
+			q.retryAfter(key, backoff)
+			q.mutex.Unlock()
+			continue
+		}
+
+		delete(q.queued, key)
+		delete(q.pending, key)
+		q.removeJournal(key)
+		removePin(u)
+		q.mutex.Unlock()
+	}
+}
+
+// uploadBackoff returns how long we wait before retrying an upload that has failed attempts times
+func uploadBackoff(attempts int) time.Duration {
+	backoff := uploadInitialBackoff
+	for i := 1; i < attempts && backoff < uploadMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > uploadMaxBackoff {
+		backoff = uploadMaxBackoff
+	}
+	return backoff
+}
+
+// retryAfter makes the key ready again after the backoff; the key must already be queued
+func (q *UploadQueue) retryAfter(key string, backoff time.Duration) {
+	time.AfterFunc(backoff, func() {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+
+		q.ready = append(q.ready, key)
+		q.cond.Signal()
+	})
+}
+
+// upload performs the upload, from the pinned file if we have one.  If the pinned file has gone missing we upload
+// from the original file; if that no longer exists either, the upload can never succeed and we drop it.
+func (q *UploadQueue) upload(u *pendingUpload) error {
+	p := u.SourcePath
+	if u.PinnedPath != "" {
+		if _, err := os.Stat(u.PinnedPath); err != nil {
+			glog.Errorf("pinned copy of %q is unavailable, uploading from the original file: %v", u.SourcePath, err)
+		} else {
+			p = u.PinnedPath
+		}
+	}
+	if _, err := os.Stat(p); err != nil && os.IsNotExist(err) {
+		glog.Errorf("dropping upload of %q to %q: file no longer exists", u.SourcePath, u.Key)
+		return nil
+	}
+	return q.sink.AddToArchive(p, u.Key, &u.File)
+}
+
+// pinPath returns a new path at which to pin the file for the key: <sha1(key)>-<seq>.pinned.  Each upload has its
+// own pin, so replacing a pending upload never affects the file of an upload in progress.  We keep any .gz suffix,
+// as sinks use it to recognize files that are already compressed.
+func (q *UploadQueue) pinPath(sourcePath string, key string) string {
+	q.mutex.Lock()
+	q.lastPinSeq++
+	seq := q.lastPinSeq
+	q.mutex.Unlock()
+
+	hash := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(hash[:]) + "-" + strconv.FormatUint(seq, 10) + pinSuffix
+	if strings.HasSuffix(sourcePath, ".gz") {
+		name += ".gz"
+	}
+	return filepath.Join(q.pinDir, name)
+}
+
+// pinSuffix is added to the names of pinned files
+const pinSuffix = ".pinned"
+
+// parsePinSeq returns the sequence number in the name of a pinned file, or 0 if it has none
+func parsePinSeq(name string) uint64 {
+	i := strings.Index(name, pinSuffix)
+	if i < 0 {
+		return 0
+	}
+	name = name[:i]
+	seq, err := strconv.ParseUint(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// pinFile hard links the file to dest, or copies it if it cannot be linked (e.g. it is on another filesystem)
+func pinFile(sourcePath string, dest string) error {
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(sourcePath, tmp); err == nil {
+		return os.Rename(tmp, dest)
+	}
+
+	in, err := os.OpenFile(sourcePath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return copyToFile(in, dest)
+}
+
+// copyToFile writes the contents of in to dest, replacing it atomically
+func copyToFile(in io.Reader, dest string) error {
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func removePin(u *pendingUpload) {
+	if u.PinnedPath == "" {
+		return
+	}
+	if err := os.Remove(u.PinnedPath); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("error removing pinned file %q: %v", u.PinnedPath, err)
+	}
+}
+
+// journalPath returns the path of the journal entry for the key
+func (q *UploadQueue) journalPath(key string) string {
+	hash := sha1.Sum([]byte(key))
+	return filepath.Join(q.journalDir, hex.EncodeToString(hash[:])+".json")
+}
+
+func (q *UploadQueue) writeJournal(u *pendingUpload) error {
+	if q.journalDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("error serializing upload journal entry: %v", err)
+	}
+
+	p := q.journalPath(u.Key)
+	if err := ioutil.WriteFile(p+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("error writing %q: %v", p, err)
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func (q *UploadQueue) removeJournal(key string) {
+	if q.journalDir == "" {
+		return
+	}
+
+	p := q.journalPath(key)
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("error removing upload journal entry %q: %v", p, err)
+	}
+}
+
+// loadJournal queues the uploads that were pending when we last stopped
+func (q *UploadQueue) loadJournal() error {
+	files, err := ioutil.ReadDir(q.journalDir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %q: %v", q.journalDir, err)
+	}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		p := filepath.Join(q.journalDir, f.Name())
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("error reading %q: %v", p, err)
+		}
+		u := &pendingUpload{}
+		if err := json.Unmarshal(data, u); err != nil {
+			glog.Warningf("ignoring invalid upload journal entry %q: %v", p, err)
+			continue
+		}
+		q.pending[u.Key] = u
+		if u.Attempts > 0 {
+			// Resume the backoff where we left off
+			q.queued[u.Key] = true
+			q.retryAfter(u.Key, uploadBackoff(u.Attempts))
+		} else {
+			q.enqueue(u.Key)
+		}
+	}
+
+	// Remove pinned files left behind by uploads that completed, or that we failed to journal
+	pinned := make(map[string]bool)
+	for _, u := range q.pending {
+		pinned[u.PinnedPath] = true
+	}
+	for _, f := range files {
+		if seq := parsePinSeq(f.Name()); seq > q.lastPinSeq {
+			q.lastPinSeq = seq
+		}
+		p := filepath.Join(q.journalDir, f.Name())
+		if strings.Contains(f.Name(), pinSuffix) && !pinned[p] {
+			if err := os.Remove(p); err != nil {
+				glog.Warningf("error removing stale pinned file %q: %v", p, err)
+			}
+		}
+	}
+
+	glog.Infof("loaded %d pending uploads from %q", len(q.pending), q.journalDir)
+	return nil
+}
@@ -0,0 +1,246 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink records the contents of the files it is asked to archive
+type fakeSink struct {
+	mutex    sync.Mutex
+	uploads  map[string][]string
+	calls    int
+	failures int
+
+	// if set, the first upload signals started and then waits for release
+	started chan struct{}
+	release chan struct{}
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{uploads: make(map[string][]string)}
+}
+
+func (s *fakeSink) AddToArchive(sourcePath string, key string, fileInfo *proto.LogFile) error {
+	data, err := ioutil.ReadFile(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.calls++
+	first := s.calls == 1
+	fail := s.calls <= s.failures
+	s.mutex.Unlock()
+
+	if first && s.started != nil {
+		close(s.started)
+		<-s.release
+	}
+	if fail {
+		return fmt.Errorf("injected failure")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.uploads[key] = append(s.uploads[key], string(data))
+	return nil
+}
+
+func (s *fakeSink) uploaded(key string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.uploads[key]
+}
+
+func (s *fakeSink) callCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls
+}
+
+func (q *UploadQueue) pendingCount() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending)
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	for deadline := time.Now().Add(10 * time.Second); !fn(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	return dir
+}
+
+// writeFile replaces the file, as log rotation does, so existing hard links keep the old contents
+func writeFile(t *testing.T, p string, data string) {
+	os.Remove(p)
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatalf("error writing %q: %v", p, err)
+	}
+}
+
+func pinnedFiles(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading %q: %v", dir, err)
+	}
+	var pinned []string
+	for _, f := range files {
+		if strings.Contains(f.Name(), pinSuffix) {
+			pinned = append(pinned, f.Name())
+		}
+	}
+	return pinned
+}
+
+func TestUploadQueueReplaceDuringUpload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	journalDir := filepath.Join(dir, "journal")
+	src := filepath.Join(dir, "app.log")
+
+	sink := newFakeSink()
+	sink.started = make(chan struct{})
+	sink.release = make(chan struct{})
+	q, err := NewUploadQueue(sink, journalDir, 1)
+	if err != nil {
+		t.Fatalf("error building queue: %v", err)
+	}
+
+	writeFile(t, src, "one")
+	if err := q.AddToArchive(src, "pods/uid/app.log", &proto.LogFile{}); err != nil {
+		t.Fatalf("error queueing: %v", err)
+	}
+	<-sink.started
+
+	// Replacing the upload in progress must not affect either pinned file
+	writeFile(t, src, "two")
+	if err := q.AddToArchive(src, "pods/uid/app.log", &proto.LogFile{}); err != nil {
+		t.Fatalf("error queueing: %v", err)
+	}
+	if pinned := pinnedFiles(t, journalDir); len(pinned) != 2 {
+		t.Errorf("expected 2 pinned files, found %v", pinned)
+	}
+	os.Remove(src)
+	close(sink.release)
+
+	waitFor(t, "uploads", func() bool { return q.pendingCount() == 0 })
+	if actual := sink.uploaded("pods/uid/app.log"); len(actual) != 2 || actual[0] != "one" || actual[1] != "two" {
+		t.Errorf("uploaded %q, expected [one two]", actual)
+	}
+	if pinned := pinnedFiles(t, journalDir); len(pinned) != 0 {
+		t.Errorf("pinned files left behind: %v", pinned)
+	}
+}
+
+func TestUploadQueueMissingPin(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	journalDir := filepath.Join(dir, "journal")
+	src := filepath.Join(dir, "app.log")
+	writeFile(t, src, "contents")
+
+	// A journal entry whose pinned file was lost; we fall back to the original file
+	q := &UploadQueue{journalDir: journalDir}
+	if err := os.MkdirAll(journalDir, 0755); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	u := &pendingUpload{SourcePath: src, PinnedPath: filepath.Join(journalDir, "lost-1.pinned"), Key: "pods/uid/app.log"}
+	if err := q.writeJournal(u); err != nil {
+		t.Fatalf("error writing journal: %v", err)
+	}
+
+	sink := newFakeSink()
+	q, err := NewUploadQueue(sink, journalDir, 1)
+	if err != nil {
+		t.Fatalf("error building queue: %v", err)
+	}
+	waitFor(t, "upload", func() bool { return q.pendingCount() == 0 })
+	if actual := sink.uploaded(u.Key); len(actual) != 1 || actual[0] != "contents" {
+		t.Errorf("uploaded %q, expected [contents]", actual)
+	}
+}
+
+func TestUploadQueueAttemptsJournaled(t *testing.T) {
+	defer func(d time.Duration) { uploadInitialBackoff = d }(uploadInitialBackoff)
+	uploadInitialBackoff = time.Hour
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	journalDir := filepath.Join(dir, "journal")
+	src := filepath.Join(dir, "app.log.gz")
+	writeFile(t, src, "contents")
+
+	sink := newFakeSink()
+	sink.failures = 1
+	q, err := NewUploadQueue(sink, journalDir, 1)
+	if err != nil {
+		t.Fatalf("error building queue: %v", err)
+	}
+	if err := q.AddToArchive(src, "pods/uid/app.log.gz", &proto.LogFile{}); err != nil {
+		t.Fatalf("error queueing: %v", err)
+	}
+
+	p := q.journalPath("pods/uid/app.log.gz")
+	journaled := &pendingUpload{}
+	waitFor(t, "failed attempt to be journaled", func() bool {
+		data, err := ioutil.ReadFile(p)
+		return err == nil && json.Unmarshal(data, journaled) == nil && journaled.Attempts == 1
+	})
+	if !strings.HasSuffix(journaled.PinnedPath, "-1"+pinSuffix+".gz") {
+		t.Errorf("unexpected pinned path %q", journaled.PinnedPath)
+	}
+
+	// After a restart we keep backing off, and do not reuse the pin sequence numbers
+	restarted, err := NewUploadQueue(newFakeSink(), journalDir, 1)
+	if err != nil {
+		t.Fatalf("error building queue: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if restarted.pendingCount() != 1 {
+		t.Errorf("upload was retried without backing off")
+	}
+	if next := restarted.pinPath(src, "pods/uid/other.log"); !strings.HasSuffix(next, "-2"+pinSuffix+".gz") {
+		t.Errorf("unexpected pinned path %q after restart", next)
+	}
+	if sink.callCount() != 1 {
+		t.Errorf("sink was called %d times, expected 1", sink.callCount())
+	}
+}
+
+func TestUploadBackoff(t *testing.T) {
+	grid := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: time.Second},
+		{attempts: 2, expected: 2 * time.Second},
+		{attempts: 4, expected: 8 * time.Second},
+		{attempts: 9, expected: 256 * time.Second},
+		{attempts: 10, expected: 5 * time.Minute},
+		{attempts: 100, expected: 5 * time.Minute},
+	}
+	for _, g := range grid {
+		if actual := uploadBackoff(g.attempts); actual != g.expected {
+			t.Errorf("uploadBackoff(%d) was %v, expected %v", g.attempts, actual, g.expected)
+		}
+	}
+}
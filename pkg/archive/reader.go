@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Object is a file in the archive
+type Object struct {
+	// Key is relative to the base of the archive, as passed to AddToArchive plus any suffix added by the sink (e.g. .gz)
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// NotFoundError is returned by Open if the object does not exist
+type NotFoundError struct {
+	Key string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("object %q not found", e.Key)
+}
+
+// IsNotFound returns true if the error is a NotFoundError
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// Reader reads the files in an archive; the sinks implement it
+type Reader interface {
+	// List returns the objects whose keys are under the prefix directory ("" for all objects)
+	List(prefix string) ([]*Object, error)
+	// Open streams the contents of the object, decrypting and decompressing it as needed
+	Open(key string) (io.ReadCloser, error)
+}
+
+// KeyringReader is implemented by readers of archives that may be encrypted
+type KeyringReader interface {
+	// Keyring returns the keys of the archive, or nil if it is not encrypted
+	Keyring() *Keyring
+}
+
+// NewReader builds the reader for the archive at the URL, see NewSink
+func NewReader(location string) (Reader, error) {
+	sink, err := NewSink(location)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := sink.(Reader)
+	if !ok {
+		return nil, fmt.Errorf("archive location %q does not support reading", RedactLocation(location))
+	}
+	return reader, nil
+}
+
+// Decode wraps the contents of the object with a decryptor and decompressor, as indicated by the key suffixes.
+// keyring may be nil if the archive is not encrypted.
+func Decode(key string, in io.ReadCloser, keyring *Keyring) (io.ReadCloser, error) {
+	if !strings.HasSuffix(key, EncryptedSuffix) {
+		return Decompress(key, in)
+	}
+
+	if keyring == nil {
+		in.Close()
+		return nil, fmt.Errorf("%q is encrypted, but no encryption keys are configured", key)
+	}
+	decrypted, err := keyring.NewDecryptor(in)
+	if err != nil {
+		in.Close()
+		return nil, fmt.Errorf("error decrypting %q: %v", key, err)
+	}
+	return Decompress(strings.TrimSuffix(key, EncryptedSuffix), &readCloser{Reader: decrypted, Closer: in})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Decompress wraps the contents of the object with a decompressor, if the key has a compression suffix
+func Decompress(key string, in io.ReadCloser) (io.ReadCloser, error) {
+	if !strings.HasSuffix(key, ".gz") {
+		return in, nil
+	}
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		in.Close()
+		return nil, fmt.Errorf("error building gzip decompressor for %q: %v", key, err)
+	}
+	return &gzipReadCloser{Reader: gz, in: in}, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	in io.ReadCloser
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.in.Close()
+}
@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SinkFactory builds a sink from its URL
+type SinkFactory func(u *url.URL) (Sink, error)
+
+var sinkFactories = make(map[string]SinkFactory)
+
+// RegisterSink makes a sink available for URLs with the scheme
+func RegisterSink(scheme string, factory SinkFactory) {
+	if _, found := sinkFactories[scheme]; found {
+		panic(fmt.Sprintf("sink %q registered twice", scheme))
+	}
+	sinkFactories[scheme] = factory
+}
+
+// SinkSchemes returns the URL schemes of all registered sinks
+func SinkSchemes() []string {
+	var schemes []string
+	for k := range sinkFactories {
+		schemes = append(schemes, k)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NewSink builds the sink for the URL, e.g. s3://bucket/prefix or file:///var/archive
+func NewSink(location string) (Sink, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		// The url.Error would repeat the location
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid archive location %q: %v", RedactLocation(location), err)
+	}
+
+	factory := sinkFactories[u.Scheme]
+	if factory == nil {
+		return nil, fmt.Errorf("unknown scheme in archive location %q (known schemes: %v)", RedactLocation(location), SinkSchemes())
+	}
+	return factory(u)
+}
+
+// RedactLocation removes any userinfo from the location, so we don't log credentials
+func RedactLocation(location string) string {
+	i := strings.Index(location, "://")
+	if i == -1 {
+		return location
+	}
+	authority := location[i+3:]
+	if end := strings.IndexAny(authority, "/?#"); end != -1 {
+		authority = authority[:end]
+	}
+	at := strings.LastIndex(authority, "@")
+	if at == -1 {
+		return location
+	}
+	return location[:i+3] + "<redacted>@" + location[i+3+at+1:]
+}
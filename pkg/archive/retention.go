@@ -0,0 +1,206 @@
+package archive
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"kope.io/klogs/pkg/proto"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy is how long archived files are kept
+type RetentionPolicy struct {
+	// MaxAge is the default retention; zero keeps files forever
+	MaxAge time.Duration
+	// NamespaceMaxAge overrides MaxAge for files from pods in the namespace
+	NamespaceMaxAge map[string]time.Duration
+}
+
+// Deleter is implemented by sinks that can remove objects
+type Deleter interface {
+	Delete(key string) error
+}
+
+// ParseNamespaceRetention parses namespace=duration overrides, e.g. kube-system=168h
+func ParseNamespaceRetention(specs []string) (map[string]time.Duration, error) {
+	overrides := make(map[string]time.Duration)
+	for _, spec := range specs {
+		tokens := strings.SplitN(spec, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("invalid namespace retention %q, expected namespace=duration", spec)
+		}
+		d, err := time.ParseDuration(tokens[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration in namespace retention %q: %v", spec, err)
+		}
+		overrides[tokens[0]] = d
+	}
+	return overrides, nil
+}
+
+func (p *RetentionPolicy) maxAge(namespace string) time.Duration {
+	if d, found := p.NamespaceMaxAge[namespace]; found {
+		return d
+	}
+	return p.MaxAge
+}
+
+// manifestNamespace returns the namespace of the pod the archived file came from, or "" if not known
+func manifestNamespace(m *Manifest) string {
+	if m.File.Fields != nil {
+		for _, f := range m.File.Fields.Fields {
+			if f.Key == "pod.namespace" {
+				return f.Value
+			}
+		}
+	}
+	if streamInfo, _ := ParseKey(m.Key); streamInfo != nil {
+		return streamInfo.PodNamespace
+	}
+	return ""
+}
+
+// manifestAge returns the time of the newest entry in the file, or else when the file was last written
+func manifestAge(m *Manifest) time.Time {
+	if m.File.MaxTimestamp != 0 {
+		return time.Unix(0, int64(m.File.MaxTimestamp))
+	}
+	return time.Unix(m.File.LastModified, 0)
+}
+
+// Prune removes the archived files past their retention, along with their manifests and index entries.
+// It returns the manifests of the removed files; with dryRun nothing is removed.
+func Prune(reader Reader, policy *RetentionPolicy, now time.Time, dryRun bool) ([]*Manifest, error) {
+	deleter, ok := reader.(Deleter)
+	if !ok && !dryRun {
+		return nil, fmt.Errorf("archive does not support removing files")
+	}
+
+	manifests, err := ListManifests(reader, "")
+	if err != nil {
+		return nil, err
+	}
+
+	expired := policy.expired(manifests, now)
+	if dryRun {
+		return expired, nil
+	}
+
+	// The index entries of each file, by entry name; a file that grew across days has entries from earlier uploads
+	// that the latest manifest does not cover
+	var indexEntries map[string][]string
+
+	for _, m := range expired {
+		if indexEntries == nil {
+			indexEntries, err = listIndexEntries(reader)
+			if err != nil {
+				return expired, err
+			}
+		}
+
+		glog.V(2).Infof("removing expired archived file %q", m.Key)
+		// Remove the file last, so if we fail we will find it again
+		keys := append(indexEntries[indexEntryName(m.Key)], ManifestKey(m.Key), m.Key)
+		for _, key := range keys {
+			if err := deleter.Delete(key); err != nil {
+				return expired, fmt.Errorf("error removing %q: %v", key, err)
+			}
+		}
+	}
+	return expired, nil
+}
+
+// expired returns the manifests of the files past their retention.  Files in a hash chain are only removed from
+// the oldest end of the chain, so verification never sees a gap: a file is kept while an older file in its chain is
+// kept, even if it has expired itself.
+func (p *RetentionPolicy) expired(manifests []*Manifest, now time.Time) []*Manifest {
+	isExpired := make(map[*Manifest]bool)
+	chains := make(map[string][]*Manifest)
+	for _, m := range manifests {
+		if m.Chain != nil {
+			chains[m.Chain.Stream] = append(chains[m.Chain.Stream], m)
+			continue
+		}
+		if p.isExpired(m, now) {
+			isExpired[m] = true
+		}
+	}
+
+	for _, files := range chains {
+		sort.Slice(files, func(i, j int) bool { return minSequence(files[i]) < minSequence(files[j]) })
+
+		// A file archived again later has several links; we can only cut the chain where no kept file has an
+		// earlier link
+		var batch []*Manifest
+		var batchMax int64
+		for i, m := range files {
+			if !p.isExpired(m, now) {
+				break
+			}
+			batch = append(batch, m)
+			if m.Chain.Sequence > batchMax {
+				batchMax = m.Chain.Sequence
+			}
+			if i+1 == len(files) || minSequence(files[i+1]) > batchMax {
+				for _, m := range batch {
+					isExpired[m] = true
+				}
+				batch = nil
+			}
+		}
+	}
+
+	var expired []*Manifest
+	for _, m := range manifests {
+		if isExpired[m] {
+			expired = append(expired, m)
+		}
+	}
+	return expired
+}
+
+func (p *RetentionPolicy) isExpired(m *Manifest, now time.Time) bool {
+	maxAge := p.maxAge(manifestNamespace(m))
+	return maxAge != 0 && manifestAge(m).Add(maxAge).Before(now)
+}
+
+// minSequence returns the position of the earliest link of the file in its chain
+func minSequence(m *Manifest) int64 {
+	sequence := m.Chain.Sequence
+	for _, link := range m.Superseded {
+		if link.Sequence < sequence {
+			sequence = link.Sequence
+		}
+	}
+	return sequence
+}
+
+// listIndexEntries returns the keys of all the index entries, by entry name
+func listIndexEntries(reader Reader) (map[string][]string, error) {
+	objects, err := reader.List(indexPrefix)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]string)
+	for _, o := range objects {
+		name := path.Base(o.Key)
+		entries[name] = append(entries[name], o.Key)
+	}
+	return entries, nil
+}
+
+// PruneResponse builds the PruneArchive response for the removed files
+func PruneResponse(removed []*Manifest) *proto.PruneArchiveResponse {
+	response := &proto.PruneArchiveResponse{}
+	for _, m := range removed {
+		file := m.File
+		response.Files = append(response.Files, &proto.ArchivedFile{
+			Key:  m.Key,
+			File: &file,
+		})
+		response.Size += m.File.Size
+	}
+	return response
+}
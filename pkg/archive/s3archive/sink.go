@@ -1,40 +1,132 @@
 package s3archive
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/golang/glog"
+	"io"
 	"kope.io/klogs/pkg/archive"
 	"kope.io/klogs/pkg/proto"
 	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
+const (
+	CompressionGzip = "gzip"
+	CompressionNone = "none"
+)
+
+// hashMetadataKey is the object metadata in which we record the content hash of the (uncompressed) file; for
+// encrypted objects it is keyed, see archive.Keyring.ContentHash
+const hashMetadataKey = "Klogs-Sha256"
+
+// multipartPartSize is the part size for uploads; larger files are streamed using multipart upload
+const multipartPartSize = 8 * 1024 * 1024
+
+// Sink uploads files to S3, or an S3-compatible store such as MinIO or Ceph.  Options are set as URL query parameters:
+// compression=gzip|none (default gzip), endpoint=<url>, region=<region>, pathStyle=true, and
+// encryptionKeys=<path of keyring> to encrypt objects before upload (see archive.ParseKeyring), and hashChain=true
+// to link objects into per-stream hash chains.
+// Credentials come from the default AWS credential chain (e.g. the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables), or from credentialsFile=<path of an AWS shared credentials file> and profile=<name>.
+// Credentials are not accepted in the URL, which ends up in process arguments and logs.
 type Sink struct {
-	bucket  string
-	basekey string
+	bucket      string
+	basekey     string
+	compression string
+	// keyring is nil if objects are not encrypted
+	keyring   *archive.Keyring
+	hashChain bool
 
 	s3Client s3iface.S3API
+	uploader *s3manager.Uploader
 }
 
 var _ archive.Sink = &Sink{}
+var _ archive.ObjectStore = &Sink{}
+var _ archive.Deleter = &Sink{}
+var _ archive.KeyringReader = &Sink{}
+
+func init() {
+	archive.RegisterSink("s3", func(u *url.URL) (archive.Sink, error) {
+		return NewSink(u)
+	})
+}
 
 func NewSink(u *url.URL) (*Sink, error) {
 	bucket := strings.TrimSuffix(u.Host, "/")
 
 	s := &Sink{
-		bucket:  bucket,
-		basekey: u.Path,
+		bucket:      bucket,
+		basekey:     u.Path,
+		compression: CompressionGzip,
+	}
+
+	if compression := u.Query().Get("compression"); compression != "" {
+		switch compression {
+		case CompressionGzip, CompressionNone:
+			s.compression = compression
+		default:
+			return nil, fmt.Errorf("unknown compression %q (valid values: %s, %s)", compression, CompressionGzip, CompressionNone)
+		}
 	}
 
-	var region string
-	{
-		config := aws.NewConfig().WithRegion("us-east-1")
+	query := u.Query()
+	if hashChain := query.Get("hashChain"); hashChain != "" {
+		b, err := strconv.ParseBool(hashChain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hashChain %q: %v", hashChain, err)
+		}
+		s.hashChain = b
+	}
+	if keys := query.Get("encryptionKeys"); keys != "" {
+		keyring, err := archive.LoadKeyring(keys)
+		if err != nil {
+			return nil, err
+		}
+		s.keyring = keyring
+	}
+
+	config := aws.NewConfig()
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	if pathStyle := query.Get("pathStyle"); pathStyle != "" {
+		b, err := strconv.ParseBool(pathStyle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pathStyle %q: %v", pathStyle, err)
+		}
+		config = config.WithS3ForcePathStyle(b)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("credentials must not be set in the archive location; use the AWS environment variables or credentialsFile")
+	}
+	if credentialsFile := query.Get("credentialsFile"); credentialsFile != "" {
+		if _, err := os.Stat(credentialsFile); err != nil {
+			return nil, fmt.Errorf("error reading credentialsFile: %v", err)
+		}
+		config = config.WithCredentials(credentials.NewSharedCredentials(credentialsFile, query.Get("profile")))
+	}
+
+	region := query.Get("region")
+	if region == "" && query.Get("endpoint") != "" {
+		// S3-compatible stores generally don't implement GetBucketLocation
+		region = "us-east-1"
+	}
+	if region == "" {
+		config := config.Copy().WithRegion("us-east-1")
 		session := session.New()
 		s3Client := s3.New(session, config)
 
@@ -60,15 +152,45 @@ func NewSink(u *url.URL) (*Sink, error) {
 		glog.V(2).Infof("Found bucket %q in region %q", bucket, region)
 	}
 
-	config := aws.NewConfig().WithRegion(region)
 	session := session.New()
-	s.s3Client = s3.New(session, config)
+	s.setClient(s3.New(session, config.WithRegion(region)))
 	return s, nil
 }
 
-func (s *Sink) AddToArchive(sourcePath string, podUID string, fileInfo *proto.LogFile) error {
+func (s *Sink) setClient(s3Client s3iface.S3API) {
+	s.s3Client = s3Client
+	s.uploader = s3manager.NewUploaderWithClient(s3Client, func(u *s3manager.Uploader) {
+		u.PartSize = multipartPartSize
+	})
+}
+
+func (s *Sink) AddToArchive(sourcePath string, key string, fileInfo *proto.LogFile) error {
 	glog.V(2).Infof("found file to archive: %q %q", sourcePath, fileInfo)
-	s3Key := path.Join(s.basekey, "pods", podUID, "logs", fileInfo.Path)
+
+	// Rotated files are often compressed already
+	compress := s.compression == CompressionGzip && !strings.HasSuffix(sourcePath, ".gz")
+	storedKey := key
+	if compress {
+		storedKey += ".gz"
+	}
+	if s.keyring != nil {
+		storedKey += archive.EncryptedSuffix
+	}
+	s3Key := path.Join(s.basekey, storedKey)
+
+	// The file may change after we hash it; if so it will be uploaded again when we next see it change
+	hash, err := hashFile(sourcePath, s.keyring)
+	if err != nil {
+		return err
+	}
+
+	archived, err := s.isArchived(s3Key, hash)
+	if err != nil {
+		glog.Warningf("error checking for existing s3://%s/%s, will upload: %v", s.bucket, s3Key, err)
+	} else if archived {
+		glog.V(2).Infof("File already archived with same content: s3://%s/%s", s.bucket, s3Key)
+		return nil
+	}
 
 	f, err := os.OpenFile(sourcePath, os.O_RDONLY, 0)
 	if err != nil {
@@ -76,20 +198,180 @@ func (s *Sink) AddToArchive(sourcePath string, podUID string, fileInfo *proto.Lo
 	}
 	defer f.Close()
 
-	request := &s3.PutObjectInput{}
-	request.Body = f
+	// The manifest records the hash of what we actually upload, in case the file changed since we hashed it
+	hasher, hashKey := s.keyring.ContentHash()
+	var body io.Reader = io.TeeReader(f, hasher)
+	if compress || s.keyring != nil {
+		source := body
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			pw.CloseWithError(s.encode(pw, source, compress))
+		}()
+		body = pr
+	}
+
+	request := &s3manager.UploadInput{}
+	request.Body = body
 	request.Bucket = aws.String(s.bucket)
 	request.Key = aws.String(s3Key)
+	request.Metadata = map[string]*string{
+		hashMetadataKey: aws.String(hash),
+	}
+	if s.keyring != nil {
+		request.ContentType = aws.String("application/octet-stream")
+	} else if compress {
+		request.ContentType = aws.String("application/gzip")
+	}
 
 	// We don't need Content-MD5: https://github.com/aws/aws-sdk-go/issues/208
 
-	// TODO: Only if changed?
-
-	_, err = s.s3Client.PutObject(request)
+	_, err = s.uploader.Upload(request)
 	if err != nil {
 		return fmt.Errorf("error writing s3://%s/%s: %v", s.bucket, s3Key, err)
 	}
 	glog.V(2).Infof("Uploaded file to s3://%s/%s", s.bucket, s3Key)
 
+	return archive.WriteManifest(s, storedKey, fileInfo, hex.EncodeToString(hasher.Sum(nil)), hashKey, s.hashChain)
+}
+
+// encode writes the contents of in to out, compressing and then encrypting as configured
+func (s *Sink) encode(out io.Writer, in io.Reader, compress bool) error {
+	var encryptor io.WriteCloser
+	if s.keyring != nil {
+		var err error
+		encryptor, err = s.keyring.NewEncryptor(out)
+		if err != nil {
+			return err
+		}
+		out = encryptor
+	}
+
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if encryptor != nil {
+		return encryptor.Close()
+	}
+	return nil
+}
+
+func (s *Sink) PutObject(key string, data []byte) error {
+	s3Key := path.Join(s.basekey, key)
+
+	request := &s3.PutObjectInput{}
+	request.Body = bytes.NewReader(data)
+	request.Bucket = aws.String(s.bucket)
+	request.Key = aws.String(s3Key)
+
+	if _, err := s.s3Client.PutObject(request); err != nil {
+		return fmt.Errorf("error writing s3://%s/%s: %v", s.bucket, s3Key, err)
+	}
+	return nil
+}
+
+// isArchived returns true if the object exists and was uploaded from a file with the same hash
+func (s *Sink) isArchived(s3Key string, hash string) (bool, error) {
+	request := &s3.HeadObjectInput{}
+	request.Bucket = aws.String(s.bucket)
+	request.Key = aws.String(s3Key)
+
+	response, err := s.s3Client.HeadObject(request)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return false, nil
+		}
+		return false, err
+	}
+
+	existing := response.Metadata[hashMetadataKey]
+	return existing != nil && *existing == hash, nil
+}
+
+func (s *Sink) List(prefix string) ([]*archive.Object, error) {
+	listPrefix := path.Join(s.basekey, prefix)
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	request := &s3.ListObjectsInput{}
+	request.Bucket = aws.String(s.bucket)
+	request.Prefix = aws.String(listPrefix)
+
+	var objects []*archive.Object
+	err := s.s3Client.ListObjectsPages(request, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, o := range page.Contents {
+			key := strings.TrimPrefix(strings.TrimPrefix(aws.StringValue(o.Key), s.basekey), "/")
+			objects = append(objects, &archive.Object{
+				Key:          key,
+				Size:         aws.Int64Value(o.Size),
+				LastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing s3://%s/%s: %v", s.bucket, listPrefix, err)
+	}
+	return objects, nil
+}
+
+func (s *Sink) Open(key string) (io.ReadCloser, error) {
+	s3Key := path.Join(s.basekey, key)
+
+	request := &s3.GetObjectInput{}
+	request.Bucket = aws.String(s.bucket)
+	request.Key = aws.String(s3Key)
+
+	response, err := s.s3Client.GetObject(request)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchKey" {
+			return nil, &archive.NotFoundError{Key: key}
+		}
+		return nil, fmt.Errorf("error reading s3://%s/%s: %v", s.bucket, s3Key, err)
+	}
+	return archive.Decode(key, response.Body, s.keyring)
+}
+
+func (s *Sink) Keyring() *archive.Keyring {
+	return s.keyring
+}
+
+func (s *Sink) Delete(key string) error {
+	s3Key := path.Join(s.basekey, key)
+
+	request := &s3.DeleteObjectInput{}
+	request.Bucket = aws.String(s.bucket)
+	request.Key = aws.String(s3Key)
+
+	if _, err := s.s3Client.DeleteObject(request); err != nil {
+		return fmt.Errorf("error deleting s3://%s/%s: %v", s.bucket, s3Key, err)
+	}
 	return nil
 }
+
+// hashFile returns the hex content hash of the file, keyed if we encrypt (see archive.Keyring.ContentHash)
+func hashFile(p string, keyring *archive.Keyring) (string, error) {
+	f, err := os.OpenFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file %q: %v", p, err)
+	}
+	defer f.Close()
+
+	hasher, _ := keyring.ContentHash()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", fmt.Errorf("error reading file %q: %v", p, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
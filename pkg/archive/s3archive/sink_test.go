@@ -0,0 +1,522 @@
+package s3archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"io"
+	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
+	"kope.io/klogs/pkg/proto"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory bucket, implementing the calls made by the Sink and the s3manager uploader
+type fakeS3 struct {
+	s3iface.S3API
+	// client builds the requests the uploader sends (it needs the request form for single part uploads); they are
+	// served from memory, never sent
+	client *s3.S3
+
+	mutex   sync.Mutex
+	objects map[string]*fakeObject
+	// multipart uploads in progress, by upload id
+	multipart map[string]*fakeObject
+	// puts counts the uploads of each key
+	puts map[string]int
+	// parts counts the parts of multipart uploads
+	parts int
+}
+
+type fakeObject struct {
+	data         []byte
+	metadata     map[string]*string
+	lastModified time.Time
+	parts        map[int64][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	config := aws.NewConfig().WithRegion("us-east-1").WithCredentials(credentials.NewStaticCredentials("id", "secret", ""))
+	return &fakeS3{
+		client:    s3.New(session.New(), config),
+		objects:   make(map[string]*fakeObject),
+		multipart: make(map[string]*fakeObject),
+		puts:      make(map[string]int),
+	}
+}
+
+func newTestSink(f *fakeS3, basekey string, compression string) *Sink {
+	s := &Sink{
+		bucket:      "bucket",
+		basekey:     basekey,
+		compression: compression,
+	}
+	s.setClient(f)
+	return s
+}
+
+func readBody(body io.Reader) ([]byte, error) {
+	if seeker, ok := body.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.ReadAll(body)
+}
+
+func (f *fakeS3) store(key string, data []byte, metadata map[string]*string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.objects[key] = &fakeObject{data: data, metadata: metadata, lastModified: time.Now()}
+	f.puts[key]++
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	data, err := readBody(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.store(aws.StringValue(input.Key), data, input.Metadata)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) PutObjectRequest(input *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	req, out := f.client.PutObjectRequest(input)
+	req.Handlers.Send.Clear()
+	req.Handlers.Send.PushBack(func(r *request.Request) {
+		if _, err := f.PutObject(input); err != nil {
+			r.Error = err
+			return
+		}
+		r.HTTPResponse = &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	})
+	return req, out
+}
+
+// GetObjectRequest is used by the uploader to build (but not send) the location of multipart uploads
+func (f *fakeS3) GetObjectRequest(input *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	return f.client.GetObjectRequest(input)
+}
+
+func (f *fakeS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	o := f.objects[aws.StringValue(input.Key)]
+	if o == nil {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadObjectOutput{Metadata: o.metadata, ContentLength: aws.Int64(int64(len(o.data)))}, nil
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	o := f.objects[aws.StringValue(input.Key)]
+	if o == nil {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(o.data)), Metadata: o.metadata}, nil
+}
+
+// ListObjectsPages returns the matching objects in pages of two, so we exercise paging
+func (f *fakeS3) ListObjectsPages(input *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool) error {
+	f.mutex.Lock()
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, aws.StringValue(input.Prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var contents []*s3.Object
+	for _, k := range keys {
+		o := f.objects[k]
+		contents = append(contents, &s3.Object{Key: aws.String(k), Size: aws.Int64(int64(len(o.data))), LastModified: aws.Time(o.lastModified)})
+	}
+	f.mutex.Unlock()
+
+	for i := 0; i < len(contents); i += 2 {
+		end := i + 2
+		if end > len(contents) {
+			end = len(contents)
+		}
+		if !fn(&s3.ListObjectsOutput{Contents: contents[i:end]}, end == len(contents)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, options ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	id := "upload-" + strconv.Itoa(len(f.multipart)+1)
+	f.multipart[id] = &fakeObject{metadata: input.Metadata, parts: make(map[int64][]byte)}
+	return &s3.CreateMultipartUploadOutput{Bucket: input.Bucket, Key: input.Key, UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeS3) UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, options ...request.Option) (*s3.UploadPartOutput, error) {
+	data, err := readBody(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	upload := f.multipart[aws.StringValue(input.UploadId)]
+	if upload == nil {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "no such upload", nil)
+	}
+	upload.parts[aws.Int64Value(input.PartNumber)] = data
+	f.parts++
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", aws.Int64Value(input.PartNumber)))}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, options ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mutex.Lock()
+	upload := f.multipart[aws.StringValue(input.UploadId)]
+	delete(f.multipart, aws.StringValue(input.UploadId))
+	f.mutex.Unlock()
+	if upload == nil {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "no such upload", nil)
+	}
+
+	var data []byte
+	for _, part := range input.MultipartUpload.Parts {
+		data = append(data, upload.parts[aws.Int64Value(part.PartNumber)]...)
+	}
+	f.store(aws.StringValue(input.Key), data, upload.metadata)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, options ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.multipart, aws.StringValue(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) object(key string) *fakeObject {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.objects[key]
+}
+
+func writeTestFile(t *testing.T, dir string, name string, data []byte) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, data, 0644); err != nil {
+		t.Fatalf("error writing %q: %v", p, err)
+	}
+	return p
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatalf("error compressing: %v", err)
+	}
+	return b.Bytes()
+}
+
+func readObject(t *testing.T, s *Sink, key string) []byte {
+	in, err := s.Open(key)
+	if err != nil {
+		t.Fatalf("error opening %q: %v", key, err)
+	}
+	defer in.Close()
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		t.Fatalf("error reading %q: %v", key, err)
+	}
+	return data
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "s3archive")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	return dir
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	contents := []byte("2017-01-02T03:04:05Z first line\n2017-01-02T03:04:06Z second line\n")
+	grid := []struct {
+		compression string
+		name        string
+		data        []byte
+		storedKey   string
+		gzipped     bool
+	}{
+		{compression: CompressionGzip, name: "app.log", data: contents, storedKey: "pods/uid/app.log.gz", gzipped: true},
+		{compression: CompressionNone, name: "app.log", data: contents, storedKey: "pods/uid/app.log", gzipped: false},
+		// Files that are already compressed are uploaded as they are
+		{compression: CompressionGzip, name: "app.log.gz", data: gzipped(t, contents), storedKey: "pods/uid/app.log.gz", gzipped: true},
+	}
+	for _, g := range grid {
+		f := newFakeS3()
+		s := newTestSink(f, "/logs", g.compression)
+
+		p := writeTestFile(t, dir, g.name, g.data)
+		if err := s.AddToArchive(p, "pods/uid/"+g.name, &proto.LogFile{Path: g.name}); err != nil {
+			t.Fatalf("error archiving %s: %v", g.name, err)
+		}
+
+		o := f.object("/logs/" + g.storedKey)
+		if o == nil {
+			t.Errorf("%s %s: object %q not found", g.compression, g.name, g.storedKey)
+			continue
+		}
+		if isGzip := bytes.HasPrefix(o.data, []byte{0x1f, 0x8b}); isGzip != g.gzipped {
+			t.Errorf("%s %s: object gzipped was %v, expected %v", g.compression, g.name, isGzip, g.gzipped)
+		}
+		if actual := readObject(t, s, g.storedKey); !bytes.Equal(actual, contents) {
+			t.Errorf("%s %s: read %q, expected %q", g.compression, g.name, actual, contents)
+		}
+
+		m, err := archive.ReadManifest(s, archive.ManifestKey(g.storedKey))
+		if err != nil {
+			t.Fatalf("error reading manifest: %v", err)
+		}
+		hash := sha256.Sum256(g.data)
+		if m.Key != g.storedKey || m.Sha256 != hex.EncodeToString(hash[:]) {
+			t.Errorf("%s %s: unexpected manifest %v", g.compression, g.name, m)
+		}
+	}
+}
+
+func TestAddToArchiveIdempotent(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newFakeS3()
+	s := newTestSink(f, "", CompressionGzip)
+	p := writeTestFile(t, dir, "app.log", []byte("first\n"))
+
+	for i := 0; i < 2; i++ {
+		if err := s.AddToArchive(p, "pods/uid/app.log", &proto.LogFile{}); err != nil {
+			t.Fatalf("error archiving: %v", err)
+		}
+	}
+	if f.puts["pods/uid/app.log.gz"] != 1 {
+		t.Errorf("unchanged file was uploaded %d times, expected once", f.puts["pods/uid/app.log.gz"])
+	}
+
+	writeTestFile(t, dir, "app.log", []byte("first\nsecond\n"))
+	if err := s.AddToArchive(p, "pods/uid/app.log", &proto.LogFile{}); err != nil {
+		t.Fatalf("error archiving: %v", err)
+	}
+	if f.puts["pods/uid/app.log.gz"] != 2 {
+		t.Errorf("changed file was uploaded %d times in total, expected twice", f.puts["pods/uid/app.log.gz"])
+	}
+	if actual := readObject(t, s, "pods/uid/app.log.gz"); string(actual) != "first\nsecond\n" {
+		t.Errorf("read %q after re-upload", actual)
+	}
+}
+
+func TestEncryptedHashMetadata(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	key := sha256.Sum256([]byte("k1"))
+	keyring, err := archive.ParseKeyring([]byte("k1=" + base64.StdEncoding.EncodeToString(key[:])))
+	if err != nil {
+		t.Fatalf("error parsing keyring: %v", err)
+	}
+	f := newFakeS3()
+	s := newTestSink(f, "", CompressionGzip)
+	s.keyring = keyring
+
+	data := []byte("2017-01-02T03:04:05Z a line that is easy to guess\n")
+	p := writeTestFile(t, dir, "app.log", data)
+	for i := 0; i < 2; i++ {
+		if err := s.AddToArchive(p, "pods/uid/app.log", &proto.LogFile{}); err != nil {
+			t.Fatalf("error archiving: %v", err)
+		}
+	}
+	if f.puts["pods/uid/app.log.gz.enc"] != 1 {
+		t.Errorf("unchanged file was uploaded %d times, expected once", f.puts["pods/uid/app.log.gz.enc"])
+	}
+
+	m, err := archive.ReadManifest(s, archive.ManifestKey("pods/uid/app.log.gz.enc"))
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	plain := sha256.Sum256(data)
+	metadata := aws.StringValue(f.object("pods/uid/app.log.gz.enc").metadata[hashMetadataKey])
+	if metadata == hex.EncodeToString(plain[:]) || m.Sha256 == hex.EncodeToString(plain[:]) {
+		t.Errorf("plaintext hash recorded for encrypted object")
+	}
+	if metadata != m.Sha256 || m.HashKey != "k1" {
+		t.Errorf("object metadata hash %q, manifest hash %q with key %q", metadata, m.Sha256, m.HashKey)
+	}
+}
+
+func TestOpenNotFound(t *testing.T) {
+	s := newTestSink(newFakeS3(), "/logs", CompressionGzip)
+	_, err := s.Open("pods/uid/missing.log.gz")
+	if !archive.IsNotFound(err) {
+		t.Errorf("expected NotFoundError, was %v", err)
+	}
+	if _, err := archive.ReadManifest(s, archive.ManifestKey("pods/uid/missing.log.gz")); !archive.IsNotFound(err) {
+		t.Errorf("expected NotFoundError for manifest, was %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	grid := []struct {
+		basekey string
+		prefix  string
+		keys    []string
+	}{
+		{basekey: "", prefix: "", keys: []string{"logs/pods/uid-1/a.log", "logs/pods/uid-1/b.log", "logs/pods/uid-2/c.log", "other/pods/uid-3/d.log"}},
+		{basekey: "", prefix: "logs/pods/uid-1", keys: []string{"logs/pods/uid-1/a.log", "logs/pods/uid-1/b.log"}},
+		{basekey: "/logs", prefix: "", keys: []string{"pods/uid-1/a.log", "pods/uid-1/b.log", "pods/uid-2/c.log"}},
+		{basekey: "/logs", prefix: "pods/uid-2", keys: []string{"pods/uid-2/c.log"}},
+		// The prefix is a directory, not a string prefix
+		{basekey: "/logs", prefix: "pods/uid", keys: nil},
+	}
+	for _, g := range grid {
+		f := newFakeS3()
+		for _, k := range []string{"logs/pods/uid-1/a.log", "logs/pods/uid-1/b.log", "logs/pods/uid-2/c.log", "other/pods/uid-3/d.log"} {
+			// The basekey is the path of the sink URL, so keys under it start with /
+			if g.basekey != "" {
+				k = "/" + k
+			}
+			f.store(k, []byte("data"), nil)
+		}
+
+		s := newTestSink(f, g.basekey, CompressionGzip)
+		objects, err := s.List(g.prefix)
+		if err != nil {
+			t.Fatalf("error listing: %v", err)
+		}
+		var keys []string
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+			if o.Size != 4 || o.LastModified.IsZero() {
+				t.Errorf("unexpected size %d or modification time %v for %q", o.Size, o.LastModified, o.Key)
+			}
+		}
+		if !reflect.DeepEqual(keys, g.keys) {
+			t.Errorf("basekey %q prefix %q: listed %q, expected %q", g.basekey, g.prefix, keys, g.keys)
+		}
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// Random data does not compress, so the upload spans two parts
+	data := make([]byte, multipartPartSize+multipartPartSize/2)
+	rand.New(rand.NewSource(1)).Read(data)
+	p := writeTestFile(t, dir, "large.log", data)
+
+	f := newFakeS3()
+	s := newTestSink(f, "/logs", CompressionGzip)
+	if err := s.AddToArchive(p, "pods/uid/large.log", &proto.LogFile{}); err != nil {
+		t.Fatalf("error archiving: %v", err)
+	}
+
+	if f.parts < 2 {
+		t.Errorf("uploaded in %d parts, expected multipart upload", f.parts)
+	}
+	o := f.object("/logs/pods/uid/large.log.gz")
+	if o == nil {
+		t.Fatalf("object not found")
+	}
+	hash := sha256.Sum256(data)
+	if aws.StringValue(o.metadata[hashMetadataKey]) != hex.EncodeToString(hash[:]) {
+		t.Errorf("unexpected hash metadata %v", aws.StringValue(o.metadata[hashMetadataKey]))
+	}
+	if actual := readObject(t, s, "pods/uid/large.log.gz"); !bytes.Equal(actual, data) {
+		t.Errorf("read %d bytes, not equal to the %d bytes uploaded", len(actual), len(data))
+	}
+
+	// Re-uploading the unchanged file is skipped, using the hash recorded by the multipart upload
+	if err := s.AddToArchive(p, "pods/uid/large.log", &proto.LogFile{}); err != nil {
+		t.Fatalf("error archiving: %v", err)
+	}
+	if f.puts["/logs/pods/uid/large.log.gz"] != 1 {
+		t.Errorf("unchanged file was uploaded %d times, expected once", f.puts["/logs/pods/uid/large.log.gz"])
+	}
+}
+
+func TestNewSinkCredentials(t *testing.T) {
+	u, _ := url.Parse("s3://AKID:SECRET@bucket/prefix?endpoint=http://127.0.0.1:1")
+	if _, err := NewSink(u); err == nil || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("unexpected error for credentials in the location: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "s3archive")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "credentials")
+	data := "[default]\naws_access_key_id = AKID1\naws_secret_access_key = SECRET1\n\n[archive]\naws_access_key_id = AKID2\naws_secret_access_key = SECRET2\n"
+	if err := ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing %q: %v", p, err)
+	}
+
+	grid := []struct {
+		query    string
+		expected string
+	}{
+		{query: "credentialsFile=" + p, expected: "AKID1"},
+		{query: "credentialsFile=" + p + "&profile=archive", expected: "AKID2"},
+	}
+	for _, g := range grid {
+		u, _ := url.Parse("s3://bucket/prefix?endpoint=http://127.0.0.1:1&" + g.query)
+		s, err := NewSink(u)
+		if err != nil {
+			t.Fatalf("error building sink: %v", err)
+		}
+		creds, err := s.s3Client.(*s3.S3).Config.Credentials.Get()
+		if err != nil {
+			t.Fatalf("error reading credentials: %v", err)
+		}
+		if creds.AccessKeyID != g.expected {
+			t.Errorf("%s: access key was %q, expected %q", g.query, creds.AccessKeyID, g.expected)
+		}
+	}
+
+	u, _ = url.Parse("s3://bucket/prefix?endpoint=http://127.0.0.1:1&credentialsFile=" + filepath.Join(dir, "missing"))
+	if _, err := NewSink(u); err == nil {
+		t.Errorf("expected error for missing credentialsFile")
+	}
+}
@@ -2,8 +2,83 @@ package archive
 
 import (
 	"kope.io/klogs/pkg/proto"
+	"os"
+	"path"
+	"strings"
 )
 
 type Sink interface {
-	AddToArchive(sourcePath string, podUID string, fileInfo *proto.LogFile) error
+	// AddToArchive uploads the file at sourcePath, storing it under key (relative to the base of the sink)
+	AddToArchive(sourcePath string, key string, fileInfo *proto.LogFile) error
+}
+
+// OpenFileSink is implemented by sinks that can archive from an open file, so we can archive files that were
+// deleted while we held them open
+type OpenFileSink interface {
+	AddOpenFileToArchive(in *os.File, key string, fileInfo *proto.LogFile) error
+}
+
+// PodFileKey is the key for a file from a pod log volume: pods/<uid>/<volume>/<path>
+func PodFileKey(stream *proto.StreamInfo, fileInfo *proto.LogFile) string {
+	return path.Join("pods", stream.PodUid, fileInfo.Path)
+}
+
+// ContainerFileKey is the key for a container log: containers/<namespace>/<pod>/<container>/<container id>/<file>
+func ContainerFileKey(stream *proto.StreamInfo, fileInfo *proto.LogFile) string {
+	return path.Join("containers", keyComponent(stream.PodNamespace), keyComponent(stream.PodName), keyComponent(stream.ContainerName), keyComponent(stream.ContainerId), path.Base(fileInfo.Path))
+}
+
+// StreamID identifies the stream a file is archived from: containers/<container id> for container logs, or
+// pods/<uid> for the log volumes of a pod.  It returns "" if the stream is neither.
+func StreamID(stream *proto.StreamInfo) string {
+	if stream.ContainerId != "" {
+		return path.Join("containers", stream.ContainerId)
+	}
+	if stream.PodUid != "" {
+		return path.Join("pods", stream.PodUid)
+	}
+	return ""
+}
+
+// ParseKey recovers the stream and the file path from a key built by PodFileKey or ContainerFileKey.
+// It returns nil if the key is not in either layout.
+func ParseKey(key string) (*proto.StreamInfo, string) {
+	tokens := strings.Split(key, "/")
+	switch tokens[0] {
+	case "pods":
+		if len(tokens) < 3 {
+			return nil, ""
+		}
+		return &proto.StreamInfo{PodUid: tokens[1]}, strings.Join(tokens[2:], "/")
+
+	case "containers":
+		if len(tokens) != 6 {
+			return nil, ""
+		}
+		stream := &proto.StreamInfo{
+			PodNamespace:  parseKeyComponent(tokens[1]),
+			PodName:       parseKeyComponent(tokens[2]),
+			ContainerName: parseKeyComponent(tokens[3]),
+			ContainerId:   parseKeyComponent(tokens[4]),
+		}
+		return stream, tokens[5]
+
+	default:
+		return nil, ""
+	}
+}
+
+// keyComponent substitutes _ for empty values (e.g. containers not run by kubernetes), so the key depth is fixed
+func keyComponent(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+func parseKeyComponent(s string) string {
+	if s == "_" {
+		return ""
+	}
+	return s
 }
@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"kope.io/klogs/pkg/proto"
+	"reflect"
+	"testing"
+)
+
+func TestContainerFileKeyRoundTrip(t *testing.T) {
+	stream := &proto.StreamInfo{
+		PodNamespace:  "shop",
+		PodName:       "web-0",
+		ContainerName: "web",
+		ContainerId:   "abc123",
+	}
+	// Containers not run by kubernetes have no pod
+	dockerStream := &proto.StreamInfo{
+		ContainerId: "def456",
+	}
+
+	grid := []struct {
+		stream   *proto.StreamInfo
+		path     string
+		suffix   string
+		key      string
+		fileName string
+	}{
+		{
+			stream:   stream,
+			path:     "/var/log/pods/shop_web-0_uid-1/web/0.log",
+			key:      "containers/shop/web-0/web/abc123/0.log",
+			fileName: "0.log",
+		},
+		// Rotated segments keep their suffix, so each gets its own key
+		{
+			stream:   stream,
+			path:     "/var/log/pods/shop_web-0_uid-1/web/0.log.20170102-030405",
+			key:      "containers/shop/web-0/web/abc123/0.log.20170102-030405",
+			fileName: "0.log.20170102-030405",
+		},
+		{
+			stream:   stream,
+			path:     "/var/log/pods/shop_web-0_uid-1/web/0.log.20170102-030405.gz",
+			key:      "containers/shop/web-0/web/abc123/0.log.20170102-030405.gz",
+			fileName: "0.log.20170102-030405.gz",
+		},
+		{
+			stream:   stream,
+			path:     "/var/log/pods/shop_web-0_uid-1/web/0.log.20170102-030405.gz",
+			suffix:   EncryptedSuffix,
+			key:      "containers/shop/web-0/web/abc123/0.log.20170102-030405.gz.enc",
+			fileName: "0.log.20170102-030405.gz.enc",
+		},
+		{
+			stream:   dockerStream,
+			path:     "/var/lib/docker/containers/def456/def456-json.log.1",
+			key:      "containers/_/_/_/def456/def456-json.log.1",
+			fileName: "def456-json.log.1",
+		},
+		{
+			stream:   dockerStream,
+			path:     "/var/lib/docker/containers/def456/def456-json.log",
+			suffix:   EncryptedSuffix,
+			key:      "containers/_/_/_/def456/def456-json.log.enc",
+			fileName: "def456-json.log.enc",
+		},
+	}
+	for _, g := range grid {
+		key := ContainerFileKey(g.stream, &proto.LogFile{Path: g.path}) + g.suffix
+		if key != g.key {
+			t.Errorf("ContainerFileKey(%q) was %q, expected %q", g.path, key, g.key)
+			continue
+		}
+
+		streamInfo, fileName := ParseKey(key)
+		if !reflect.DeepEqual(streamInfo, g.stream) {
+			t.Errorf("ParseKey(%q) returned stream %v, expected %v", key, streamInfo, g.stream)
+		}
+		if fileName != g.fileName {
+			t.Errorf("ParseKey(%q) returned file %q, expected %q", key, fileName, g.fileName)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	grid := []struct {
+		key      string
+		expected *proto.StreamInfo
+		fileName string
+	}{
+		{key: "pods/uid-1/logs/app.log.gz", expected: &proto.StreamInfo{PodUid: "uid-1"}, fileName: "logs/app.log.gz"},
+		{key: "pods/uid-1/logs/2017/app.log.enc", expected: &proto.StreamInfo{PodUid: "uid-1"}, fileName: "logs/2017/app.log.enc"},
+		{key: "pods/uid-1", expected: nil},
+		{key: "containers/shop/web-0/web/0.log", expected: nil},
+		{key: "containers/shop/web-0/web/abc123/extra/0.log", expected: nil},
+		{key: "manifests/containers/shop/web-0/web/abc123/0.log", expected: nil},
+		{key: "", expected: nil},
+	}
+	for _, g := range grid {
+		streamInfo, fileName := ParseKey(g.key)
+		if !reflect.DeepEqual(streamInfo, g.expected) {
+			t.Errorf("ParseKey(%q) returned stream %v, expected %v", g.key, streamInfo, g.expected)
+		}
+		if fileName != g.fileName {
+			t.Errorf("ParseKey(%q) returned file %q, expected %q", g.key, fileName, g.fileName)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type PruneArchiveOptions struct {
+	// DryRun reports what would be removed, without removing anything
+	DryRun bool
+}
+
+func RunPruneArchive(f Factory, out io.Writer, o *PruneArchiveOptions) error {
+	client, err := f.ArchiveClient()
+	if err != nil {
+		return err
+	}
+
+	// TODO: What is the right context?
+	ctx := context.Background()
+
+	response, err := client.PruneArchive(ctx, &proto.PruneArchiveRequest{DryRun: o.DryRun})
+	if err != nil {
+		return fmt.Errorf("error pruning archive: %v", err)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSIZE\tLAST")
+	for _, f := range response.Files {
+		var size int64
+		var last uint64
+		if f.File != nil {
+			size = f.File.Size
+			last = f.File.MaxTimestamp
+			if last == 0 {
+				last = uint64(time.Unix(f.File.LastModified, 0).UnixNano())
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Key, formatSize(size), formatTimestamp(last))
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing results: %v", err)
+	}
+
+	verb := "removed"
+	if o.DryRun {
+		verb = "would remove"
+	}
+	_, err = fmt.Fprintf(out, "%s %d files (%d bytes)\n", verb, len(response.Files), response.Size)
+	return err
+}
+
+func RunVerifyArchive(f Factory, out io.Writer) error {
+	client, err := f.ArchiveClient()
+	if err != nil {
+		return err
+	}
+
+	// TODO: What is the right context?
+	ctx := context.Background()
+
+	response, err := client.VerifyArchive(ctx, &proto.VerifyArchiveRequest{})
+	if err != nil {
+		return fmt.Errorf("error verifying archive: %v", err)
+	}
+
+	if len(response.Problems) != 0 {
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tPROBLEM\tMESSAGE")
+		for _, p := range response.Problems {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Key, p.Type, p.Message)
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("error writing results: %v", err)
+		}
+	}
+
+	fmt.Fprintf(out, "verified %d files (%d archived without checksums)\n", response.Verified, response.Unverified)
+	if len(response.Problems) != 0 {
+		return fmt.Errorf("found %d problems in the archive", len(response.Problems))
+	}
+	return nil
+}
+
+// archiveFilterAliases are the short names accepted by archive ls & get
+var archiveFilterAliases = map[string]string{
+	"namespace": "pod.namespace",
+	"pod":       "pod.name",
+	"container": "container.name",
+}
+
+// parseArchiveFilters parses key=value arguments, as for streams, also accepting namespace, pod & container
+func parseArchiveFilters(args []string) ([]*proto.FieldFilter, error) {
+	var filters []*proto.FieldFilter
+	for _, arg := range args {
+		filter, err := parseFieldFilter(arg)
+		if err != nil {
+			return nil, err
+		}
+		if filter == nil {
+			return nil, fmt.Errorf("unexpected argument %q, expected key=value", arg)
+		}
+		if alias, found := archiveFilterAliases[filter.Key]; found {
+			filter.Key = alias
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// listArchive returns the archived files matching the arguments, from the hub
+func listArchive(f Factory, args []string) ([]*proto.ArchivedFile, error) {
+	filters, err := parseArchiveFilters(args)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := f.ArchiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: What is the right context?
+	ctx := context.Background()
+
+	stream, err := client.ListArchive(ctx, &proto.ListArchiveRequest{FieldFilters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("error listing archive: %v", err)
+	}
+
+	var files []*proto.ArchivedFile
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing archive: %v", err)
+		}
+		files = append(files, in)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Key < files[j].Key })
+	return files, nil
+}
+
+func RunListArchive(f Factory, out io.Writer, args []string) error {
+	files, err := listArchive(f, args)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tCONTAINER\tSIZE\tFIRST\tLAST\tKEY")
+	for _, f := range files {
+		var fields *proto.Fields
+		var size int64
+		var first, last uint64
+		if f.File != nil {
+			fields = f.File.Fields
+			size = f.File.Size
+			first = f.File.MinTimestamp
+			last = f.File.MaxTimestamp
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			findField(fields, "pod.namespace"), findField(fields, "pod.name"), findField(fields, "container.name"),
+			formatSize(size), formatTimestamp(first), formatTimestamp(last), f.Key)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing results: %v", err)
+	}
+	return nil
+}
+
+type GetArchiveOptions struct {
+	// Dir is the directory into which we download, as <namespace>/<pod>/<container>/<file>
+	Dir string
+}
+
+func RunGetArchive(f Factory, out io.Writer, args []string, o *GetArchiveOptions) error {
+	files, err := listArchive(f, args)
+	if err != nil {
+		return err
+	}
+
+	client, err := f.ArchiveClient()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, file := range files {
+		p, err := archiveLocalPath(o.Dir, file)
+		if err != nil {
+			return err
+		}
+		n, err := downloadArchivedFile(client, file.Key, p)
+		if err != nil {
+			return err
+		}
+		total += n
+		fmt.Fprintf(out, "%s\n", p)
+	}
+
+	_, err = fmt.Fprintf(out, "downloaded %d files (%d bytes)\n", len(files), total)
+	return err
+}
+
+// archiveLocalPath returns the path under dir to which we download the archived file
+func archiveLocalPath(dir string, file *proto.ArchivedFile) (string, error) {
+	var fields *proto.Fields
+	if file.File != nil {
+		fields = file.File.Fields
+	}
+
+	component := func(s string) string {
+		if s == "" || s == "." || s == ".." || strings.ContainsAny(s, "/\\") {
+			return "_"
+		}
+		return s
+	}
+
+	// The archive stores uncompressed contents, whatever the encoding of the object
+	name := strings.TrimSuffix(strings.TrimSuffix(file.Key, ".enc"), ".gz")
+	if p := findField(fields, "path"); p != "" && findField(fields, "container.id") == "" {
+		// Files from pod log volumes keep their path within the volume
+		name = p
+	} else {
+		name = path.Base(name)
+	}
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "", fmt.Errorf("cannot determine file name for %q", file.Key)
+	}
+
+	return filepath.Join(dir,
+		component(findField(fields, "pod.namespace")),
+		component(findField(fields, "pod.name")),
+		component(findField(fields, "container.name")),
+		filepath.FromSlash(name)), nil
+}
+
+// downloadArchivedFile reads the archived file through the hub, writing it to dest
+func downloadArchivedFile(client proto.ArchiveServiceClient, key string, dest string) (int64, error) {
+	// TODO: What is the right context?
+	ctx := context.Background()
+
+	stream, err := client.ReadArchive(ctx, &proto.ReadArchiveRequest{Key: key})
+	if err != nil {
+		return 0, fmt.Errorf("error reading %q: %v", key, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, fmt.Errorf("error creating directory for %q: %v", dest, err)
+	}
+	tmp := dest + ".tmp"
+	w, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("error creating %q: %v", tmp, err)
+	}
+	defer os.Remove(tmp)
+
+	var n int64
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Close()
+			return 0, fmt.Errorf("error reading %q: %v", key, err)
+		}
+		if _, err := w.Write(in.Data); err != nil {
+			w.Close()
+			return 0, fmt.Errorf("error writing %q: %v", tmp, err)
+		}
+		n += int64(len(in.Data))
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("error writing %q: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return 0, fmt.Errorf("error renaming %q: %v", tmp, err)
+	}
+	return n, nil
+}
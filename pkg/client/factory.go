@@ -14,6 +14,7 @@ import (
 
 type Factory interface {
 	LogServerClient() (proto.LogServerClient, error)
+	ArchiveClient() (proto.ArchiveServiceClient, error)
 }
 
 type DefaultFactory struct {
@@ -26,13 +27,7 @@ type DefaultFactory struct {
 var _ Factory = &DefaultFactory{}
 
 func (f *DefaultFactory) LogServerClient() (proto.LogServerClient, error) {
-	options := &grpc.GRPCClientOptions{
-		Server:   f.Server,
-		Token:    f.Token,
-		Username: f.Username,
-		Password: f.Password,
-	}
-	conn, err := grpc.NewGRPCClient(options)
+	conn, err := grpc.NewGRPCClient(f.clientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -40,6 +35,25 @@ func (f *DefaultFactory) LogServerClient() (proto.LogServerClient, error) {
 	return client, nil
 }
 
+// ArchiveClient returns a client for the archive service of the hub
+func (f *DefaultFactory) ArchiveClient() (proto.ArchiveServiceClient, error) {
+	conn, err := grpc.NewGRPCClient(f.clientOptions())
+	if err != nil {
+		return nil, err
+	}
+	client := proto.NewArchiveServiceClient(conn)
+	return client, nil
+}
+
+func (f *DefaultFactory) clientOptions() *grpc.GRPCClientOptions {
+	return &grpc.GRPCClientOptions{
+		Server:   f.Server,
+		Token:    f.Token,
+		Username: f.Username,
+		Password: f.Password,
+	}
+}
+
 func (f *DefaultFactory) LoadConfigurationFiles() error {
 	var paths []string
 
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"kope.io/klogs/pkg/proto"
+	"text/tabwriter"
+	"time"
+)
+
+func RunListMembers(f Factory, out io.Writer) error {
+	client, err := f.LogServerClient()
+	if err != nil {
+		return err
+	}
+
+	// TODO: What is the right context?
+	ctx := context.Background()
+
+	response, err := client.Health(ctx, &proto.HealthRequest{})
+	if err != nil {
+		return fmt.Errorf("error querying members: %v", err)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATE\tLAST SEEN\tERROR")
+	for _, m := range response.Members {
+		lastSeen := ""
+		if m.LastSeen != 0 {
+			lastSeen = time.Unix(m.LastSeen, 0).Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Id, m.State, lastSeen, m.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing results: %v", err)
+	}
+	return nil
+}
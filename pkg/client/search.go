@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
 	"io"
+	"kope.io/klogs/pkg/grpc"
 	"kope.io/klogs/pkg/proto"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +23,14 @@ const (
 
 type SearchOptions struct {
 	Output string
+	// AllRestarts merges the results from all instances of the containers into a single timeline
+	AllRestarts bool
+}
+
+// searchResult is a single result, with the fields common to its chunk
+type searchResult struct {
+	commonFields *proto.Fields
+	item         *proto.SearchResult
 }
 
 func RunSearch(f Factory, out io.Writer, args []string, o *SearchOptions) error {
@@ -36,46 +48,19 @@ func RunSearch(f Factory, out io.Writer, args []string, o *SearchOptions) error
 		return fmt.Errorf("unknown output format %q", o.Output)
 	}
 
-	if len(args) > 0 {
-		for _, arg := range args {
-			// TODO: build a parser properly!
-			if strings.Contains(arg, "!=") {
-				i := strings.Index(arg, "!=")
-				request.FieldFilters = append(request.FieldFilters, &proto.FieldFilter{
-					Key:   arg[0:i],
-					Value: arg[i+2:],
-					Op:    proto.FieldFilterOperator_NOT_EQ,
-				})
-			} else if strings.Contains(arg, "=") {
-				tokens := strings.SplitN(arg, "=", 2)
-				key := tokens[0]
-				value := tokens[1]
-				if key == "age" {
-					d, err := parseDurationExpression(value)
-					if err != nil {
-						return err
-					}
-					// TODO: Need to sync times somehow
-					ts := time.Now().Add(-d)
-					request.FieldFilters = append(request.FieldFilters, &proto.FieldFilter{
-						Key:   "@timestamp",
-						Value: ts.Format(time.RFC3339Nano),
-						Op:    proto.FieldFilterOperator_GTE,
-					})
-				} else {
-					request.FieldFilters = append(request.FieldFilters, &proto.FieldFilter{
-						Key:   key,
-						Value: value,
-						Op:    proto.FieldFilterOperator_EQ,
-					})
-				}
-			} else {
-				// substring match
-				if request.Contains != "" {
-					return fmt.Errorf("multiple search not yet implemented")
-				}
-				request.Contains = arg
+	for _, arg := range args {
+		filter, err := parseFieldFilter(arg)
+		if err != nil {
+			return err
+		}
+		if filter != nil {
+			request.FieldFilters = append(request.FieldFilters, filter)
+		} else {
+			// substring match
+			if request.Contains != "" {
+				return fmt.Errorf("multiple search not yet implemented")
 			}
+			request.Contains = arg
 		}
 	}
 
@@ -93,6 +78,8 @@ func RunSearch(f Factory, out io.Writer, args []string, o *SearchOptions) error
 		return fmt.Errorf("error making request: %v", err)
 	}
 
+	// Results arrive grouped by file; to interleave restarts we must buffer them all
+	var results []*searchResult
 	for {
 		in, err := stream.Recv()
 		if err == io.EOF {
@@ -102,14 +89,108 @@ func RunSearch(f Factory, out io.Writer, args []string, o *SearchOptions) error
 			return fmt.Errorf("error reading from server: %v", err)
 		}
 
+		if o.AllRestarts {
+			for _, item := range in.Items {
+				results = append(results, &searchResult{commonFields: in.CommonFields, item: item})
+			}
+			continue
+		}
+
 		if err := formatter(in.CommonFields, in.Items, out); err != nil {
 			return fmt.Errorf("error writing results: %v", err)
 		}
 	}
 
+	if o.AllRestarts {
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].item.Timestamp < results[j].item.Timestamp
+		})
+
+		for _, r := range results {
+			items := []*proto.SearchResult{r.item}
+			if o.Output == OutputFormatRaw {
+				if restartCount := findField(r.commonFields, "container.restartCount"); restartCount != "" {
+					if _, err := fmt.Fprintf(out, "[restart %s] ", restartCount); err != nil {
+						return fmt.Errorf("error writing results: %v", err)
+					}
+				}
+			}
+			if err := formatter(r.commonFields, items, out); err != nil {
+				return fmt.Errorf("error writing results: %v", err)
+			}
+		}
+	}
+
+	warnIfIncomplete(stream.Trailer())
+
 	return nil
 }
 
+// warnIfIncomplete prints a warning if the hub reported that some members failed, so the results are incomplete
+func warnIfIncomplete(trailer metadata.MD) {
+	partial := grpc.ParsePartialResults(trailer)
+	if partial == nil {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "results incomplete: %d/%d nodes failed\n", len(partial.Failures), partial.Members)
+	for _, failure := range partial.Failures {
+		fmt.Fprintf(os.Stderr, "  %s\n", failure)
+	}
+}
+
+// findField returns the value of the field with the specified key, or "" if not found
+func findField(fields *proto.Fields, key string) string {
+	if fields == nil {
+		return ""
+	}
+	for _, f := range fields.Fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// parseFieldFilter parses a key=value, key!=value or age=<duration> expression; it returns nil if arg is not a filter
+func parseFieldFilter(arg string) (*proto.FieldFilter, error) {
+	// TODO: build a parser properly!
+	if strings.Contains(arg, "!=") {
+		i := strings.Index(arg, "!=")
+		return &proto.FieldFilter{
+			Key:   arg[0:i],
+			Value: arg[i+2:],
+			Op:    proto.FieldFilterOperator_NOT_EQ,
+		}, nil
+	}
+
+	if strings.Contains(arg, "=") {
+		tokens := strings.SplitN(arg, "=", 2)
+		key := tokens[0]
+		value := tokens[1]
+		if key == "age" {
+			d, err := parseDurationExpression(value)
+			if err != nil {
+				return nil, err
+			}
+			// TODO: Need to sync times somehow
+			ts := time.Now().Add(-d)
+			return &proto.FieldFilter{
+				Key:   "@timestamp",
+				Value: ts.Format(time.RFC3339Nano),
+				Op:    proto.FieldFilterOperator_GTE,
+			}, nil
+		}
+		return &proto.FieldFilter{
+			Key:   key,
+			Value: value,
+			Op:    proto.FieldFilterOperator_EQ,
+		}, nil
+	}
+
+	return nil, nil
+}
+
 func parseDurationExpression(s string) (time.Duration, error) {
 	s = strings.TrimSpace(s)
 
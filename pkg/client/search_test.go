@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"kope.io/klogs/pkg/proto"
+	"testing"
+)
+
+// fakeFactory returns a LogServerClient that sends the chunks in response to a search
+type fakeFactory struct {
+	Factory
+	chunks []*proto.SearchResultChunk
+}
+
+func (f *fakeFactory) LogServerClient() (proto.LogServerClient, error) {
+	return &fakeLogServerClient{chunks: f.chunks}, nil
+}
+
+type fakeLogServerClient struct {
+	proto.LogServerClient
+	chunks []*proto.SearchResultChunk
+}
+
+func (c *fakeLogServerClient) Search(ctx context.Context, in *proto.SearchRequest, opts ...grpc.CallOption) (proto.LogServer_SearchClient, error) {
+	return &fakeSearchClient{chunks: c.chunks}, nil
+}
+
+type fakeSearchClient struct {
+	grpc.ClientStream
+	chunks []*proto.SearchResultChunk
+}
+
+func (c *fakeSearchClient) Recv() (*proto.SearchResultChunk, error) {
+	if len(c.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := c.chunks[0]
+	c.chunks = c.chunks[1:]
+	return chunk, nil
+}
+
+func (c *fakeSearchClient) Trailer() metadata.MD {
+	return nil
+}
+
+// restartChunk builds the results from the log file of one instance of a container
+func restartChunk(restartCount string, lines map[uint64]string, timestamps ...uint64) *proto.SearchResultChunk {
+	chunk := &proto.SearchResultChunk{
+		CommonFields: &proto.Fields{
+			Fields: []*proto.Field{
+				{Key: "container.name", Value: "web"},
+				{Key: "container.restartCount", Value: restartCount},
+			},
+		},
+	}
+	for _, ts := range timestamps {
+		chunk.Items = append(chunk.Items, &proto.SearchResult{
+			Timestamp: ts,
+			Fields:    &proto.Fields{Fields: []*proto.Field{{Key: "log", Value: lines[ts] + "\n"}}},
+		})
+	}
+	return chunk
+}
+
+func TestRunSearchAllRestarts(t *testing.T) {
+	lines := map[uint64]string{
+		100: "starting",
+		200: "crashed",
+		300: "starting again",
+		400: "still running",
+	}
+	// Results arrive grouped by file, and the files of the restarts overlap in time
+	chunks := func() []*proto.SearchResultChunk {
+		return []*proto.SearchResultChunk{
+			restartChunk("1", lines, 300, 400),
+			restartChunk("0", lines, 100, 200),
+		}
+	}
+
+	grid := []struct {
+		allRestarts bool
+		expected    string
+	}{
+		{
+			allRestarts: false,
+			expected:    "starting again\nstill running\nstarting\ncrashed\n",
+		},
+		{
+			allRestarts: true,
+			expected:    "[restart 0] starting\n[restart 0] crashed\n[restart 1] starting again\n[restart 1] still running\n",
+		},
+	}
+	for _, g := range grid {
+		var out bytes.Buffer
+		options := &SearchOptions{Output: OutputFormatRaw, AllRestarts: g.allRestarts}
+		if err := RunSearch(&fakeFactory{chunks: chunks()}, &out, []string{"pod.name=web-0"}, options); err != nil {
+			t.Errorf("RunSearch(allRestarts=%v) returned error: %v", g.allRestarts, err)
+			continue
+		}
+		if actual := out.String(); actual != g.expected {
+			t.Errorf("RunSearch(allRestarts=%v) wrote %q, expected %q", g.allRestarts, actual, g.expected)
+		}
+	}
+}
@@ -5,13 +5,109 @@ import (
 	"golang.org/x/net/context"
 	"io"
 	"kope.io/klogs/pkg/proto"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 type ListStreamsOptions struct {
+	// SortBy is the column to sort by, see streamColumns
+	SortBy string
+	// Watch prints stream events as they happen, instead of listing the streams
+	Watch bool
 }
 
-func RunListStreams(f Factory, out io.Writer, o *ListStreamsOptions) error {
-	request := &proto.GetStreamsRequest{}
+// streamColumn is a column in the streams table
+type streamColumn struct {
+	name  string
+	value func(s *proto.StreamInfo) string
+	less  func(l, r *proto.StreamInfo) bool
+}
+
+var streamColumns = []*streamColumn{
+	{
+		name:  "host",
+		value: func(s *proto.StreamInfo) string { return s.Host },
+	},
+	{
+		name:  "namespace",
+		value: func(s *proto.StreamInfo) string { return s.PodNamespace },
+	},
+	{
+		name:  "pod",
+		value: func(s *proto.StreamInfo) string { return s.PodName },
+	},
+	{
+		name: "container",
+		value: func(s *proto.StreamInfo) string {
+			if s.ContainerName == "" && s.Source != "" {
+				return s.Source
+			}
+			return s.ContainerName
+		},
+	},
+	{
+		name:  "status",
+		value: func(s *proto.StreamInfo) string { return strings.ToLower(s.Status.String()) },
+	},
+	{
+		name:  "files",
+		value: func(s *proto.StreamInfo) string { return strconv.Itoa(int(s.FileCount)) },
+		less:  func(l, r *proto.StreamInfo) bool { return l.FileCount < r.FileCount },
+	},
+	{
+		name:  "size",
+		value: func(s *proto.StreamInfo) string { return formatSize(s.Size) },
+		less:  func(l, r *proto.StreamInfo) bool { return l.Size < r.Size },
+	},
+	{
+		name:  "first",
+		value: func(s *proto.StreamInfo) string { return formatTimestamp(s.FirstTimestamp) },
+		less:  func(l, r *proto.StreamInfo) bool { return l.FirstTimestamp < r.FirstTimestamp },
+	},
+	{
+		name:  "last",
+		value: func(s *proto.StreamInfo) string { return formatTimestamp(s.LastTimestamp) },
+		less:  func(l, r *proto.StreamInfo) bool { return l.LastTimestamp < r.LastTimestamp },
+	},
+}
+
+// StreamColumnNames returns the names of the columns that can be used with SortBy
+func StreamColumnNames() []string {
+	var names []string
+	for _, c := range streamColumns {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func RunListStreams(f Factory, out io.Writer, args []string, o *ListStreamsOptions) error {
+	var sortBy *streamColumn
+	for _, c := range streamColumns {
+		if c.name == o.SortBy {
+			sortBy = c
+		}
+	}
+	if sortBy == nil {
+		return fmt.Errorf("unknown sort column %q (valid columns: %s)", o.SortBy, strings.Join(StreamColumnNames(), ","))
+	}
+
+	host, filters, err := parseStreamFilters(args)
+	if err != nil {
+		return err
+	}
+
+	if o.Watch {
+		return runWatchStreams(f, out, host, filters)
+	}
+
+	request := &proto.GetStreamsRequest{
+		Host:         host,
+		FieldFilters: filters,
+	}
+
 	client, err := f.LogServerClient()
 	if err != nil {
 		return err
@@ -25,19 +121,171 @@ func RunListStreams(f Factory, out io.Writer, o *ListStreamsOptions) error {
 		return fmt.Errorf("error making request: %v", err)
 	}
 
+	// If the stream fails part-way we still print the streams we received
+	var streams []*proto.StreamInfo
+	var recvErr error
 	for {
 		in, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("error reading from server: %v", err)
+			recvErr = fmt.Errorf("error reading from server: %v", err)
+			break
+		}
+		streams = append(streams, in)
+	}
+
+	sortStreams(streams, sortBy)
+	if err := printStreams(out, streams); err != nil {
+		return err
+	}
+
+	if recvErr == nil {
+		warnIfIncomplete(stream.Trailer())
+	}
+	return recvErr
+}
+
+// sortStreams sorts by the column, breaking ties using the other columns in order
+func sortStreams(streams []*proto.StreamInfo, sortBy *streamColumn) {
+	compare := func(c *streamColumn, l, r *proto.StreamInfo) int {
+		if c.less != nil {
+			if c.less(l, r) {
+				return -1
+			}
+			if c.less(r, l) {
+				return 1
+			}
+			return 0
 		}
-		_, err = fmt.Fprintf(out, "%v\n", in)
+		return strings.Compare(c.value(l), c.value(r))
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		l, r := streams[i], streams[j]
+		if c := compare(sortBy, l, r); c != 0 {
+			return c < 0
+		}
+		for _, c := range streamColumns {
+			if c == sortBy {
+				continue
+			}
+			if c := compare(c, l, r); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// printStreams writes the streams as a table
+func printStreams(out io.Writer, streams []*proto.StreamInfo) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	var header []string
+	for _, c := range streamColumns {
+		header = append(header, strings.ToUpper(c.name))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, s := range streams {
+		var row []string
+		for _, c := range streamColumns {
+			v := c.value(s)
+			if v == "" {
+				v = "-"
+			}
+			row = append(row, v)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing results: %v", err)
+	}
+	return nil
+}
+
+// parseStreamFilters parses key=value arguments; a host=<node> filter also routes the request to that node
+func parseStreamFilters(args []string) (string, []*proto.FieldFilter, error) {
+	host := ""
+	var filters []*proto.FieldFilter
+	for _, arg := range args {
+		filter, err := parseFieldFilter(arg)
 		if err != nil {
+			return "", nil, err
+		}
+		if filter == nil {
+			return "", nil, fmt.Errorf("unexpected argument %q, expected key=value", arg)
+		}
+		if filter.Key == "host" && filter.Op == proto.FieldFilterOperator_EQ {
+			host = filter.Value
+		}
+		filters = append(filters, filter)
+	}
+	return host, filters, nil
+}
+
+func runWatchStreams(f Factory, out io.Writer, host string, filters []*proto.FieldFilter) error {
+	request := &proto.WatchStreamsRequest{
+		Host:         host,
+		FieldFilters: filters,
+	}
+	client, err := f.LogServerClient()
+	if err != nil {
+		return err
+	}
+
+	// TODO: What is the right context?
+	ctx := context.Background()
+
+	stream, err := client.WatchStreams(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error making request: %v", err)
+	}
+
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading from server: %v", err)
+		}
+		if in.Stream == nil {
+			continue
+		}
+
+		var row []string
+		row = append(row, in.Type.String())
+		for _, c := range streamColumns {
+			v := c.value(in.Stream)
+			if v == "" {
+				v = "-"
+			}
+			row = append(row, c.name+"="+v)
+		}
+		if _, err := fmt.Fprintln(out, strings.Join(row, " ")); err != nil {
 			return fmt.Errorf("error writing results: %v", err)
 		}
 	}
+}
 
-	return nil
+func formatTimestamp(ts uint64) string {
+	if ts == 0 {
+		return ""
+	}
+	t := time.Unix(int64(ts/1e9), int64(ts%1e9))
+	return t.Format(time.RFC3339)
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ci", float64(size)/float64(div), "KMGTPE"[exp])
 }
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"kope.io/klogs/pkg/proto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSize(t *testing.T) {
+	grid := []struct {
+		size     int64
+		expected string
+	}{
+		{size: 0, expected: "0"},
+		{size: 1023, expected: "1023"},
+		{size: 1024, expected: "1.0Ki"},
+		{size: 1536, expected: "1.5Ki"},
+		{size: 1024*1024 - 1, expected: "1024.0Ki"},
+		{size: 1024 * 1024, expected: "1.0Mi"},
+		{size: 5 * 1024 * 1024 * 1024, expected: "5.0Gi"},
+	}
+	for _, g := range grid {
+		if actual := formatSize(g.size); actual != g.expected {
+			t.Errorf("formatSize(%d) was %q, expected %q", g.size, actual, g.expected)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	if actual := formatTimestamp(0); actual != "" {
+		t.Errorf("formatTimestamp(0) was %q", actual)
+	}
+
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 600, time.UTC)
+	parsed, err := time.Parse(time.RFC3339, formatTimestamp(uint64(ts.UnixNano())))
+	if err != nil {
+		t.Fatalf("error parsing formatted timestamp: %v", err)
+	}
+	if !parsed.Equal(ts.Truncate(time.Second)) {
+		t.Errorf("formatted timestamp was %v, expected %v", parsed, ts)
+	}
+}
+
+func findStreamColumn(t *testing.T, name string) *streamColumn {
+	for _, c := range streamColumns {
+		if c.name == name {
+			return c
+		}
+	}
+	t.Fatalf("column %q not found", name)
+	return nil
+}
+
+func TestSortStreams(t *testing.T) {
+	streams := []*proto.StreamInfo{
+		{Host: "b", PodName: "web-1", Size: 2048, FirstTimestamp: 300},
+		{Host: "a", PodName: "web-2", Size: 10, FirstTimestamp: 100},
+		{Host: "a", PodName: "web-0", Size: 2048, FirstTimestamp: 200},
+		{Host: "c", PodName: "db-0", Size: 100000, FirstTimestamp: 0},
+	}
+
+	grid := []struct {
+		sortBy   string
+		expected []string
+	}{
+		{sortBy: "host", expected: []string{"web-0", "web-2", "web-1", "db-0"}},
+		{sortBy: "pod", expected: []string{"db-0", "web-0", "web-1", "web-2"}},
+		// Sizes are compared numerically, not as formatted strings; web-0 and web-1 have the same size, so host decides
+		{sortBy: "size", expected: []string{"web-2", "web-0", "web-1", "db-0"}},
+		{sortBy: "first", expected: []string{"db-0", "web-2", "web-0", "web-1"}},
+	}
+	for _, g := range grid {
+		sorted := append([]*proto.StreamInfo(nil), streams...)
+		sortStreams(sorted, findStreamColumn(t, g.sortBy))
+
+		var actual []string
+		for _, s := range sorted {
+			actual = append(actual, s.PodName)
+		}
+		if !reflect.DeepEqual(actual, g.expected) {
+			t.Errorf("sort by %s was %v, expected %v", g.sortBy, actual, g.expected)
+		}
+	}
+}
+
+func TestPrintStreams(t *testing.T) {
+	streams := []*proto.StreamInfo{
+		{Host: "node-1", PodNamespace: "shop", PodName: "web-0", ContainerName: "web", Status: proto.StreamStatus_RUNNING, FileCount: 2, Size: 1536},
+		{Host: "node-1", Source: "journal"},
+	}
+
+	var b bytes.Buffer
+	if err := printStreams(&b, streams); err != nil {
+		t.Fatalf("error printing streams: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", b.String())
+	}
+	grid := [][]string{
+		{"HOST", "NAMESPACE", "POD", "CONTAINER", "STATUS", "FILES", "SIZE", "FIRST", "LAST"},
+		{"node-1", "shop", "web-0", "web", "running", "2", "1.5Ki", "-", "-"},
+		// Streams without a container are shown by their source, and empty values as -
+		{"node-1", "-", "-", "journal", "unknown", "0", "0", "-", "-"},
+	}
+	for i, expected := range grid {
+		if actual := strings.Fields(lines[i]); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("line %d was %q, expected %q", i, actual, expected)
+		}
+	}
+}
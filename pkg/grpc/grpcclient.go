@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"net/url"
 	"strings"
@@ -98,3 +99,13 @@ func NewGRPCClient(options *GRPCClientOptions) (*grpc.ClientConn, error) {
 	}
 	return conn, nil
 }
+
+// IsRetryable returns true if the error from a call indicates a transient failure, so the call may succeed if retried
+func IsRetryable(err error) bool {
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,62 @@
+package grpc
+
+import (
+	"google.golang.org/grpc/metadata"
+	"strconv"
+	"strings"
+)
+
+// The hub fans requests out to the mesh members.  If some members fail it still returns the results from the others,
+// and reports the failures in the trailer metadata.
+const MetadataKeyMembers = "klogs-members"
+const MetadataKeyMemberFailures = "klogs-member-failures"
+
+// PartialResults describes the members that did not contribute to the results of a request
+type PartialResults struct {
+	// Members is the number of members the request was sent to
+	Members int
+	// Failures is the error from each member that failed, as "<member>: <error>"
+	Failures []string
+}
+
+// Metadata encodes the failures as trailer metadata
+func (p *PartialResults) Metadata() metadata.MD {
+	md := metadata.Pairs(MetadataKeyMembers, strconv.Itoa(p.Members))
+	for _, failure := range p.Failures {
+		// Header values cannot contain control characters
+		failure = strings.Map(func(r rune) rune {
+			if r < ' ' || r == 0x7f {
+				return ' '
+			}
+			return r
+		}, failure)
+		md[MetadataKeyMemberFailures] = append(md[MetadataKeyMemberFailures], failure)
+	}
+	return md
+}
+
+// ParsePartialResults decodes the failures from trailer metadata, returning nil if no failures were reported
+func ParsePartialResults(md metadata.MD) *PartialResults {
+	failures := md[MetadataKeyMemberFailures]
+	if len(failures) == 0 {
+		return nil
+	}
+
+	p := &PartialResults{
+		Failures: failures,
+	}
+	if members := md[MetadataKeyMembers]; len(members) == 1 {
+		p.Members, _ = strconv.Atoi(members[0])
+	}
+	return p
+}
+
+// TrailerSetter is implemented by server streams
+type TrailerSetter interface {
+	SetTrailer(metadata.MD)
+}
+
+// SetTrailer reports the failures in the trailer of the stream
+func (p *PartialResults) SetTrailer(stream TrailerSetter) {
+	stream.SetTrailer(p.Metadata())
+}
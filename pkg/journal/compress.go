@@ -0,0 +1,122 @@
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Flags of data objects whose payload is compressed; journald only compresses large fields
+const (
+	objectCompressedXZ   = 1 << 0
+	objectCompressedLZ4  = 1 << 1
+	objectCompressedZSTD = 1 << 2
+
+	objectCompressedMask = objectCompressedXZ | objectCompressedLZ4 | objectCompressedZSTD
+)
+
+// maxFieldSize bounds the size of a decompressed field (journald's DATA_SIZE_MAX)
+const maxFieldSize = 768 * 1024 * 1024
+
+// errUnsupportedCompression is returned for XZ-compressed fields, which we skip
+var errUnsupportedCompression = errors.New("xz compression is not supported")
+
+// zstdDecoder is shared; DecodeAll is safe for concurrent use
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxFieldSize))
+
+// decompress returns the payload of a data object compressed as described by flags
+func decompress(flags byte, payload []byte) ([]byte, error) {
+	switch flags & objectCompressedMask {
+	case objectCompressedZSTD:
+		return zstdDecoder.DecodeAll(payload, nil)
+	case objectCompressedLZ4:
+		// journald prefixes the LZ4 block with the uncompressed size
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("truncated lz4 payload")
+		}
+		size := binary.LittleEndian.Uint64(payload[0:8])
+		if size > maxFieldSize {
+			return nil, fmt.Errorf("lz4 payload has invalid size %d", size)
+		}
+		return decompressLZ4Block(payload[8:], int(size))
+	case objectCompressedXZ:
+		return nil, errUnsupportedCompression
+	default:
+		return nil, fmt.Errorf("unknown compression flags %x", flags)
+	}
+}
+
+// decompressLZ4Block decodes a raw LZ4 block (without the frame format) of the given uncompressed size
+func decompressLZ4Block(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+
+	// readLength reads the extra bytes of a literal or match length that did not fit in the token
+	pos := 0
+	readLength := func(n int) (int, error) {
+		for {
+			if pos >= len(src) {
+				return 0, fmt.Errorf("truncated lz4 block")
+			}
+			b := src[pos]
+			pos++
+			n += int(b)
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+
+	for pos < len(src) {
+		token := src[pos]
+		pos++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			var err error
+			if literals, err = readLength(literals); err != nil {
+				return nil, err
+			}
+		}
+		if literals > len(src)-pos || literals > size-len(dst) {
+			return nil, fmt.Errorf("invalid lz4 literal length %d", literals)
+		}
+		dst = append(dst, src[pos:pos+literals]...)
+		pos += literals
+
+		// The last sequence has only literals
+		if pos == len(src) {
+			break
+		}
+
+		if pos+2 > len(src) {
+			return nil, fmt.Errorf("truncated lz4 block")
+		}
+		offset := int(src[pos]) | int(src[pos+1])<<8
+		pos += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, fmt.Errorf("invalid lz4 match offset %d", offset)
+		}
+
+		matchLength := int(token & 15)
+		if matchLength == 15 {
+			var err error
+			if matchLength, err = readLength(matchLength); err != nil {
+				return nil, err
+			}
+		}
+		matchLength += 4
+		if matchLength > size-len(dst) {
+			return nil, fmt.Errorf("invalid lz4 match length %d", matchLength)
+		}
+		// The match may overlap the bytes it produces, so we copy byte by byte
+		for i := 0; i < matchLength; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+
+	if len(dst) != size {
+		return nil, fmt.Errorf("lz4 block decoded to %d bytes, expected %d", len(dst), size)
+	}
+	return dst, nil
+}
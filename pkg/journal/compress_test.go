@@ -0,0 +1,62 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/klauspost/compress/zstd"
+	"strings"
+	"testing"
+)
+
+// lz4Payload prefixes the block with the uncompressed size, as journald does
+func lz4Payload(size int, block ...byte) []byte {
+	payload := make([]byte, 8)
+	binary.LittleEndian.PutUint64(payload, uint64(size))
+	return append(payload, block...)
+}
+
+func TestDecompress(t *testing.T) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("error building zstd encoder: %v", err)
+	}
+	message := "MESSAGE=" + strings.Repeat("a large field ", 100)
+	zstdPayload := encoder.EncodeAll([]byte(message), nil)
+
+	grid := []struct {
+		name     string
+		flags    byte
+		payload  []byte
+		expected string
+		err      bool
+	}{
+		{name: "zstd", flags: objectCompressedZSTD, payload: zstdPayload, expected: message},
+		{name: "zstd corrupted", flags: objectCompressedZSTD, payload: zstdPayload[:len(zstdPayload)/2], err: true},
+		{name: "lz4", flags: objectCompressedLZ4, payload: lz4Payload(12, 0x44, 'a', 'b', 'c', 'd', 4, 0), expected: "abcdabcdabcd"},
+		// The match overlaps the bytes it copies
+		{name: "lz4 run", flags: objectCompressedLZ4, payload: lz4Payload(10, 0x15, 'a', 1, 0), expected: "aaaaaaaaaa"},
+		{name: "lz4 long literals", flags: objectCompressedLZ4, payload: lz4Payload(20, append([]byte{0xf0, 5}, "xxxxxxxxxxxxxxxxxxxx"...)...), expected: strings.Repeat("x", 20)},
+		{name: "lz4 long match", flags: objectCompressedLZ4, payload: lz4Payload(32, 0x2f, 'a', 'b', 2, 0, 11), expected: strings.Repeat("ab", 16)},
+		{name: "lz4 literals then match then literals", flags: objectCompressedLZ4, payload: lz4Payload(8, 0x20, 'a', 'b', 2, 0, 0x20, 'c', 'd'), expected: "abababcd"},
+		{name: "lz4 wrong size", flags: objectCompressedLZ4, payload: lz4Payload(13, 0x44, 'a', 'b', 'c', 'd', 4, 0), err: true},
+		{name: "lz4 offset before start", flags: objectCompressedLZ4, payload: lz4Payload(12, 0x44, 'a', 'b', 'c', 'd', 5, 0), err: true},
+		{name: "lz4 truncated", flags: objectCompressedLZ4, payload: lz4Payload(12, 0x44, 'a', 'b'), err: true},
+		{name: "lz4 no size", flags: objectCompressedLZ4, payload: []byte{1, 2, 3}, err: true},
+		{name: "lz4 huge size", flags: objectCompressedLZ4, payload: lz4Payload(maxFieldSize+1, 0x10, 'a'), err: true},
+		{name: "xz", flags: objectCompressedXZ, payload: []byte{0xfd, '7', 'z', 'X', 'Z', 0}, err: true},
+	}
+	for _, g := range grid {
+		actual, err := decompress(g.flags, g.payload)
+		if g.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got %q", g.name, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", g.name, err)
+		} else if !bytes.Equal(actual, []byte(g.expected)) {
+			t.Errorf("%s: decompressed was %q, expected %q", g.name, actual, g.expected)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/glog"
+	"os"
+	"strings"
+)
+
+// The on-disk format is documented at https://systemd.io/JOURNAL_FILE_FORMAT/
+// We only read the file; we never take locks or modify it, so it is safe to read files
+// that journald is concurrently appending to (we will just not see the newest entries).
+
+var signature = []byte("LPKSHHRH")
+
+const (
+	headerIncompatibleCompressedXZ   = 1 << 0
+	headerIncompatibleCompressedLZ4  = 1 << 1
+	headerIncompatibleKeyedHash      = 1 << 2
+	headerIncompatibleCompressedZSTD = 1 << 3
+	headerIncompatibleCompact        = 1 << 4
+
+	// We decompress LZ4 and ZSTD fields; XZ-compressed fields (from old versions of journald) are skipped with a warning
+	headerIncompatibleSupported = headerIncompatibleCompressedXZ | headerIncompatibleCompressedLZ4 | headerIncompatibleKeyedHash | headerIncompatibleCompressedZSTD | headerIncompatibleCompact
+)
+
+const (
+	objectData       = 1
+	objectEntry      = 3
+	objectEntryArray = 6
+)
+
+// objectHeaderSize is the size of the common header for all objects: type, flags, reserved, size
+const objectHeaderSize = 16
+
+// minHeaderSize is the size of the header fields we read
+const minHeaderSize = 208
+
+type header struct {
+	incompatibleFlags  uint32
+	headerSize         uint64
+	arenaSize          uint64
+	tailObjectOffset   uint64
+	nEntries           uint64
+	entryArrayOffset   uint64
+	headEntryRealtime  uint64
+	tailEntryRealtime  uint64
+	tailEntryMonotonic uint64
+}
+
+// File is a systemd journal file, opened for reading
+type File struct {
+	path   string
+	f      *os.File
+	header header
+
+	// warnedSkippedField is set once we have logged that we could not read a field
+	warnedSkippedField bool
+}
+
+// Entry is a single journal entry
+type Entry struct {
+	Seqnum uint64
+	// Realtime is the wallclock time of the entry, in microseconds since the epoch
+	Realtime  uint64
+	Monotonic uint64
+
+	// Fields holds the (uncompressed) fields of the entry, e.g. MESSAGE, _SYSTEMD_UNIT
+	Fields map[string][]byte
+}
+
+// IsJournalFile returns true if the path looks like a journal file, including files journald has
+// renamed after finding them dirty (system.journal~)
+func IsJournalFile(p string) bool {
+	return strings.HasSuffix(p, ".journal") || strings.HasSuffix(p, ".journal~")
+}
+
+// Open opens the journal file at p and reads its header
+func Open(p string) (*File, error) {
+	f, err := os.OpenFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &File{
+		path: p,
+		f:    f,
+	}
+	if err := j.readHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *File) Close() error {
+	return j.f.Close()
+}
+
+func (j *File) compact() bool {
+	return (j.header.incompatibleFlags & headerIncompatibleCompact) != 0
+}
+
+func (j *File) readHeader() error {
+	b := make([]byte, minHeaderSize)
+	if _, err := j.f.ReadAt(b, 0); err != nil {
+		return fmt.Errorf("error reading journal header from %q: %v", j.path, err)
+	}
+	if !bytes.Equal(b[0:8], signature) {
+		return fmt.Errorf("file %q is not a journal file", j.path)
+	}
+
+	h := &j.header
+	h.incompatibleFlags = binary.LittleEndian.Uint32(b[12:16])
+	h.headerSize = binary.LittleEndian.Uint64(b[88:96])
+	h.arenaSize = binary.LittleEndian.Uint64(b[96:104])
+	h.tailObjectOffset = binary.LittleEndian.Uint64(b[136:144])
+	h.nEntries = binary.LittleEndian.Uint64(b[152:160])
+	h.entryArrayOffset = binary.LittleEndian.Uint64(b[176:184])
+	h.headEntryRealtime = binary.LittleEndian.Uint64(b[184:192])
+	h.tailEntryRealtime = binary.LittleEndian.Uint64(b[192:200])
+	h.tailEntryMonotonic = binary.LittleEndian.Uint64(b[200:208])
+
+	if (h.incompatibleFlags &^ headerIncompatibleSupported) != 0 {
+		return fmt.Errorf("journal file %q has unsupported incompatible flags %x", j.path, h.incompatibleFlags)
+	}
+	if h.headerSize < minHeaderSize {
+		return fmt.Errorf("journal file %q has unexpected header size %d", j.path, h.headerSize)
+	}
+	return nil
+}
+
+// HeadRealtime is the realtime timestamp of the first entry, in microseconds since the epoch
+func (j *File) HeadRealtime() uint64 {
+	return j.header.headEntryRealtime
+}
+
+// TailRealtime is the realtime timestamp of the last entry, in microseconds since the epoch
+func (j *File) TailRealtime() uint64 {
+	return j.header.tailEntryRealtime
+}
+
+// NEntries is the number of entries in the file
+func (j *File) NEntries() uint64 {
+	return j.header.nEntries
+}
+
+// readObjectHeader returns the type, flags and size of the object at offset
+func (j *File) readObjectHeader(offset uint64) (byte, byte, uint64, error) {
+	var b [objectHeaderSize]byte
+	if _, err := j.f.ReadAt(b[:], int64(offset)); err != nil {
+		return 0, 0, 0, err
+	}
+	size := binary.LittleEndian.Uint64(b[8:16])
+	if size < objectHeaderSize {
+		return 0, 0, 0, fmt.Errorf("invalid object size %d at offset %d in %q", size, offset, j.path)
+	}
+	return b[0], b[1], size, nil
+}
+
+// readObject reads the object at offset, checking that it is of the expected type
+func (j *File) readObject(offset uint64, expectedType byte) ([]byte, byte, error) {
+	objectType, flags, size, err := j.readObjectHeader(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if objectType != expectedType {
+		return nil, 0, fmt.Errorf("unexpected object type %d at offset %d in %q (expected %d)", objectType, offset, j.path, expectedType)
+	}
+	if j.header.arenaSize != 0 && size > j.header.arenaSize {
+		return nil, 0, fmt.Errorf("invalid object size %d at offset %d in %q", size, offset, j.path)
+	}
+	b := make([]byte, size)
+	if _, err := j.f.ReadAt(b, int64(offset)); err != nil {
+		return nil, 0, err
+	}
+	return b, flags, nil
+}
+
+// Entries calls fn for each entry in the file, in order.  Iteration stops if fn returns an error.
+func (j *File) Entries(fn func(e *Entry) error) error {
+	itemSize := uint64(8)
+	if j.compact() {
+		itemSize = 4
+	}
+
+	remaining := j.header.nEntries
+	offset := j.header.entryArrayOffset
+	for offset != 0 && remaining != 0 {
+		b, _, err := j.readObject(offset, objectEntryArray)
+		if err != nil {
+			return fmt.Errorf("error reading entry array: %v", err)
+		}
+
+		next := binary.LittleEndian.Uint64(b[16:24])
+		for pos := uint64(24); pos+itemSize <= uint64(len(b)) && remaining != 0; pos += itemSize {
+			var entryOffset uint64
+			if j.compact() {
+				entryOffset = uint64(binary.LittleEndian.Uint32(b[pos : pos+4]))
+			} else {
+				entryOffset = binary.LittleEndian.Uint64(b[pos : pos+8])
+			}
+			if entryOffset == 0 {
+				// Unused slot at the end of the array
+				break
+			}
+			remaining--
+
+			e, err := j.readEntry(entryOffset)
+			if err != nil {
+				return err
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+
+		offset = next
+	}
+
+	return nil
+}
+
+func (j *File) readEntry(offset uint64) (*Entry, error) {
+	b, _, err := j.readObject(offset, objectEntry)
+	if err != nil {
+		return nil, fmt.Errorf("error reading entry: %v", err)
+	}
+	if len(b) < 64 {
+		return nil, fmt.Errorf("truncated entry at offset %d in %q", offset, j.path)
+	}
+
+	e := &Entry{
+		Seqnum:    binary.LittleEndian.Uint64(b[16:24]),
+		Realtime:  binary.LittleEndian.Uint64(b[24:32]),
+		Monotonic: binary.LittleEndian.Uint64(b[32:40]),
+		Fields:    make(map[string][]byte),
+	}
+
+	// Items follow seqnum, realtime, monotonic, boot_id & xor_hash
+	itemSize := 16
+	if j.compact() {
+		itemSize = 4
+	}
+	for pos := 64; pos+itemSize <= len(b); pos += itemSize {
+		var dataOffset uint64
+		if j.compact() {
+			dataOffset = uint64(binary.LittleEndian.Uint32(b[pos : pos+4]))
+		} else {
+			dataOffset = binary.LittleEndian.Uint64(b[pos : pos+8])
+		}
+
+		if err := j.readData(dataOffset, e); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+func (j *File) readData(offset uint64, e *Entry) error {
+	b, flags, err := j.readObject(offset, objectData)
+	if err != nil {
+		return fmt.Errorf("error reading data: %v", err)
+	}
+
+	// hash, next_hash_offset, next_field_offset, entry_offset, entry_array_offset, n_entries
+	payloadOffset := 64
+	if j.compact() {
+		// tail_entry_array_offset, tail_entry_array_n_entries
+		payloadOffset += 8
+	}
+	if len(b) < payloadOffset {
+		return fmt.Errorf("truncated data object at offset %d in %q", offset, j.path)
+	}
+
+	payload := b[payloadOffset:]
+	if (flags & objectCompressedMask) != 0 {
+		payload, err = decompress(flags, payload)
+		if err != nil {
+			// Skipping the field is better than losing the entry; we warn once per file
+			if !j.warnedSkippedField {
+				glog.Warningf("skipping compressed field at offset %d in %q: %v", offset, j.path, err)
+				j.warnedSkippedField = true
+			}
+			return nil
+		}
+	}
+
+	i := bytes.IndexByte(payload, '=')
+	if i <= 0 {
+		return nil
+	}
+	e.Fields[string(payload[:i])] = payload[i+1:]
+	return nil
+}
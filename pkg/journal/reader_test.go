@@ -0,0 +1,186 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// The fixtures were written by systemd-journald 252, in compact and regular (non-compact) mode.  Each directory
+// holds a file journald rotated (archived) and the file it was writing when stopped (offline).  Sequence numbers
+// continue from the rotated file into the active file.
+const (
+	compactRotated = "testdata/compact/system@a85be1806af64c33b3043d61b1977fdf-0000000000000001-00065e3496252e30.journal"
+	compactActive  = "testdata/compact/system.journal"
+	regularRotated = "testdata/regular/system@f78392896b374f2d8197c4cf93d14427-0000000000000001-00065e3496818ac8.journal"
+	regularActive  = "testdata/regular/system.journal"
+
+	runtimeJournalMessage = "Runtime Journal (/run/log/journal/fed6b2924c424cf1b9a322f606b4de6d) is 512.0K, max 4.0M, 3.5M free."
+)
+
+var rotatedMessages = []string{
+	"Journal started",
+	runtimeJournalMessage,
+	"first rotated message",
+	"second rotated message",
+}
+
+var activeMessages = []string{
+	runtimeJournalMessage,
+	"first active message",
+	"key=value message",
+	"kubelet started",
+	"second active message",
+	"Journal stopped",
+}
+
+func readEntries(t *testing.T, p string) []*Entry {
+	j, err := Open(p)
+	if err != nil {
+		t.Fatalf("error opening %q: %v", p, err)
+	}
+	defer j.Close()
+
+	var entries []*Entry
+	err = j.Entries(func(e *Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error reading entries from %q: %v", p, err)
+	}
+	return entries
+}
+
+func messages(entries []*Entry) []string {
+	var messages []string
+	for _, e := range entries {
+		messages = append(messages, string(e.Fields["MESSAGE"]))
+	}
+	return messages
+}
+
+func TestEntries(t *testing.T) {
+	grid := []struct {
+		path     string
+		compact  bool
+		messages []string
+	}{
+		{path: compactRotated, compact: true, messages: rotatedMessages},
+		{path: compactActive, compact: true, messages: activeMessages},
+		{path: regularRotated, compact: false, messages: rotatedMessages},
+		{path: regularActive, compact: false, messages: activeMessages},
+	}
+	for _, g := range grid {
+		j, err := Open(g.path)
+		if err != nil {
+			t.Fatalf("error opening %q: %v", g.path, err)
+		}
+		compact := j.compact()
+		nEntries := j.NEntries()
+		head, tail := j.HeadRealtime(), j.TailRealtime()
+		j.Close()
+
+		if compact != g.compact {
+			t.Errorf("%s: compact was %v, expected %v", g.path, compact, g.compact)
+		}
+
+		entries := readEntries(t, g.path)
+		if actual := messages(entries); !reflect.DeepEqual(actual, g.messages) {
+			t.Errorf("%s: messages were %q, expected %q", g.path, actual, g.messages)
+			continue
+		}
+		if nEntries != uint64(len(entries)) {
+			t.Errorf("%s: header has %d entries, read %d", g.path, nEntries, len(entries))
+		}
+		if head != entries[0].Realtime || tail != entries[len(entries)-1].Realtime {
+			t.Errorf("%s: header timestamps %d-%d do not match entries %d-%d", g.path, head, tail, entries[0].Realtime, entries[len(entries)-1].Realtime)
+		}
+		for i := 1; i < len(entries); i++ {
+			if entries[i].Seqnum != entries[i-1].Seqnum+1 {
+				t.Errorf("%s: entry %d has seqnum %d after %d", g.path, i, entries[i].Seqnum, entries[i-1].Seqnum)
+			}
+			if entries[i].Realtime < entries[i-1].Realtime {
+				t.Errorf("%s: entry %d has realtime %d before %d", g.path, i, entries[i].Realtime, entries[i-1].Realtime)
+			}
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	grid := []struct {
+		field    string
+		expected string
+	}{
+		// The value may itself contain '='
+		{field: "MESSAGE", expected: "key=value message"},
+		{field: "PRIORITY", expected: "3"},
+		{field: "SYSLOG_IDENTIFIER", expected: "fixture-app"},
+		{field: "FIXTURE_FIELD", expected: "extra"},
+		{field: "_TRANSPORT", expected: "journal"},
+	}
+
+	for _, p := range []string{compactActive, regularActive} {
+		entries := readEntries(t, p)
+		e := entries[2]
+		for _, g := range grid {
+			actual, found := e.Fields[g.field]
+			if !found {
+				t.Errorf("%s: field %s not found", p, g.field)
+			} else if string(actual) != g.expected {
+				t.Errorf("%s: field %s was %q, expected %q", p, g.field, actual, g.expected)
+			}
+		}
+		if pid, err := strconv.Atoi(string(e.Fields["_PID"])); err != nil || pid <= 0 {
+			t.Errorf("%s: invalid _PID field %q", p, e.Fields["_PID"])
+		}
+		if _, found := e.Fields["_SYSTEMD_UNIT"]; found {
+			t.Errorf("%s: unexpected _SYSTEMD_UNIT field", p)
+		}
+	}
+}
+
+func TestIsJournalFile(t *testing.T) {
+	grid := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "system.journal", expected: true},
+		{path: filepath.Base(compactRotated), expected: true},
+		{path: "system@0001.journal~", expected: true},
+		{path: "system.journal.gz", expected: false},
+		{path: "kubelet.log", expected: false},
+	}
+	for _, g := range grid {
+		if actual := IsJournalFile(g.path); actual != g.expected {
+			t.Errorf("IsJournalFile(%q) was %v, expected %v", g.path, actual, g.expected)
+		}
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	grid := map[string][]byte{
+		"empty.journal":     {},
+		"text.journal":      []byte("this is not a journal file, but it is long enough to have a header of sorts....................................................................................................................................."),
+		"truncated.journal": []byte("LPKSHHRH"),
+	}
+	for name, data := range grid {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatalf("error writing %q: %v", p, err)
+		}
+		if j, err := Open(p); err == nil {
+			j.Close()
+			t.Errorf("expected error opening %s", name)
+		}
+	}
+}
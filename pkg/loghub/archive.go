@@ -0,0 +1,127 @@
+package loghub
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"io"
+	"kope.io/klogs/pkg/archive"
+	"kope.io/klogs/pkg/logsearch"
+	"kope.io/klogs/pkg/proto"
+	"sync"
+	"time"
+)
+
+// ArchiveServer serves the ArchiveService, and periodically prunes the archive
+type ArchiveServer struct {
+	// reader and searcher are nil if no archive is configured
+	reader   archive.Reader
+	searcher *logsearch.ArchiveSearcher
+	policy   archive.RetentionPolicy
+
+	// pruneMutex stops concurrent prunes racing each other
+	pruneMutex sync.Mutex
+}
+
+var _ proto.ArchiveServiceServer = &ArchiveServer{}
+
+// archiveDataChunkSize is the size of the chunks in which we send archived files
+const archiveDataChunkSize = 64 * 1024
+
+func newArchiveServer(reader archive.Reader, searcher *logsearch.ArchiveSearcher, policy archive.RetentionPolicy) *ArchiveServer {
+	return &ArchiveServer{
+		reader:   reader,
+		searcher: searcher,
+		policy:   policy,
+	}
+}
+
+func (a *ArchiveServer) ListArchive(request *proto.ListArchiveRequest, out proto.ArchiveService_ListArchiveServer) error {
+	if a.searcher == nil {
+		return fmt.Errorf("no archive configured on the hub")
+	}
+
+	files, err := a.searcher.List(request.FieldFilters)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := out.Send(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *ArchiveServer) ReadArchive(request *proto.ReadArchiveRequest, out proto.ArchiveService_ReadArchiveServer) error {
+	if a.searcher == nil {
+		return fmt.Errorf("no archive configured on the hub")
+	}
+
+	in, err := a.searcher.Open(request.Key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	buffer := make([]byte, archiveDataChunkSize)
+	for {
+		n, err := in.Read(buffer)
+		if n != 0 {
+			if sendErr := out.Send(&proto.ArchiveData{Data: buffer[:n]}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %q: %v", request.Key, err)
+		}
+	}
+}
+
+func (a *ArchiveServer) PruneArchive(ctx context.Context, request *proto.PruneArchiveRequest) (*proto.PruneArchiveResponse, error) {
+	if a.reader == nil {
+		return nil, fmt.Errorf("no archive configured on the hub")
+	}
+
+	removed, err := a.prune(request.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	return archive.PruneResponse(removed), nil
+}
+
+func (a *ArchiveServer) VerifyArchive(ctx context.Context, request *proto.VerifyArchiveRequest) (*proto.VerifyArchiveResponse, error) {
+	if a.reader == nil {
+		return nil, fmt.Errorf("no archive configured on the hub")
+	}
+
+	return archive.Verify(a.reader)
+}
+
+func (a *ArchiveServer) prune(dryRun bool) ([]*archive.Manifest, error) {
+	a.pruneMutex.Lock()
+	defer a.pruneMutex.Unlock()
+
+	return archive.Prune(a.reader, &a.policy, time.Now(), dryRun)
+}
+
+// runPruner prunes the archive every interval
+func (a *ArchiveServer) runPruner(interval time.Duration) {
+	for {
+		removed, err := a.prune(false)
+		if err != nil {
+			glog.Warningf("error pruning archive: %v", err)
+		} else {
+			var size int64
+			for _, m := range removed {
+				size += m.File.Size
+			}
+			glog.Infof("pruned %d archived files (%d bytes)", len(removed), size)
+		}
+
+		time.Sleep(interval)
+	}
+}
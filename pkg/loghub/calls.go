@@ -0,0 +1,199 @@
+package loghub
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"hash/fnv"
+	"kope.io/klogs/pkg/grpc"
+	"kope.io/klogs/pkg/proto"
+	"sync"
+	"time"
+)
+
+// callPolicy controls the timeouts and retries of our calls to members; zero values disable each
+type callPolicy struct {
+	connectTimeout time.Duration
+	// firstByteTimeout applies to listing streams
+	firstByteTimeout time.Duration
+	// searchIdleTimeout limits the wait for each result of a search, which may legitimately take a while to find any
+	searchIdleTimeout time.Duration
+	retries           int
+	backoff           time.Duration
+	maxBackoff        time.Duration
+}
+
+// retryableError marks a failed call to a member that is safe to retry
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// attempt is a single attempt at a call to a member; it is cancelled if a phase of the call exceeds its timeout
+type attempt struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex   sync.Mutex
+	phase   string
+	timeout time.Duration
+	timer   *time.Timer
+	expired bool
+}
+
+func (s *DistributedOp) newAttempt() *attempt {
+	ctx, cancel := context.WithCancel(s.ctx)
+	return &attempt{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// startPhase starts timing a phase of the call, e.g. connecting; a zero timeout does not time the phase
+func (a *attempt) startPhase(phase string, timeout time.Duration) {
+	a.endPhase()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if timeout == 0 {
+		return
+	}
+	a.phase = phase
+	a.timeout = timeout
+	a.timer = time.AfterFunc(timeout, func() {
+		a.mutex.Lock()
+		a.expired = true
+		a.mutex.Unlock()
+		a.cancel()
+	})
+}
+
+// endPhase stops timing the current phase
+func (a *attempt) endPhase() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+}
+
+func (a *attempt) close() {
+	a.endPhase()
+	a.cancel()
+}
+
+// memberError wraps an error from the member, marking it as retryable if it was a timeout or a transient failure
+func (a *attempt) memberError(format string, err error) error {
+	a.mutex.Lock()
+	expired := a.expired
+	a.mutex.Unlock()
+
+	if expired {
+		return &retryableError{err: fmt.Errorf("timed out %s after %v", a.phase, a.timeout)}
+	}
+	if grpc.IsRetryable(err) {
+		return &retryableError{err: fmt.Errorf(format, err)}
+	}
+	return fmt.Errorf(format, err)
+}
+
+// withRetries calls fn until it succeeds, fails with an error that is not retryable, or we run out of retries.  We
+// wait between attempts, doubling the delay each time.
+func (s *DistributedOp) withRetries(fn func(a *attempt) error) error {
+	backoff := s.policy.backoff
+	for i := 0; ; i++ {
+		a := s.newAttempt()
+		err := fn(a)
+		a.close()
+		if err == nil {
+			return nil
+		}
+		if _, retryable := err.(*retryableError); !retryable || i >= s.policy.retries || s.ctx.Err() != nil {
+			return err
+		}
+
+		glog.Warningf("retrying call to member %q in %v after error: %v", s.member.Id(), backoff, err)
+		select {
+		case <-s.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if s.policy.maxBackoff != 0 && backoff > s.policy.maxBackoff {
+			backoff = s.policy.maxBackoff
+		}
+	}
+}
+
+// sentResults counts the search results we have forwarded from a member, so that when a search fails part-way we
+// can retry it and skip the results we already sent.  Results are identified by their contents, as the member does
+// not search its files in a fixed order.
+type sentResults struct {
+	sent map[uint64]int
+	// seen counts the results of the current attempt
+	seen map[uint64]int
+}
+
+func newSentResults() *sentResults {
+	return &sentResults{
+		sent: make(map[uint64]int),
+	}
+}
+
+// nextAttempt resets the results seen, before we retry the search
+func (r *sentResults) nextAttempt() {
+	r.seen = make(map[uint64]int)
+}
+
+// filter returns the chunk without the results that an earlier attempt already sent, or nil if they all were
+func (r *sentResults) filter(chunk *proto.SearchResultChunk) *proto.SearchResultChunk {
+	prefix := fnv.New64a()
+	if chunk.CommonFields != nil {
+		for _, field := range chunk.CommonFields.Fields {
+			prefix.Write([]byte(field.Key))
+			prefix.Write([]byte{0})
+			prefix.Write([]byte(field.Value))
+			prefix.Write([]byte{0})
+		}
+	}
+	prefixSum := prefix.Sum(nil)
+
+	var items []*proto.SearchResult
+	for _, item := range chunk.Items {
+		var timestamp [8]byte
+		binary.BigEndian.PutUint64(timestamp[:], item.Timestamp)
+
+		h := fnv.New64a()
+		h.Write(prefixSum)
+		h.Write(timestamp[:])
+		h.Write(item.Raw)
+		key := h.Sum64()
+
+		// Identical lines are distinguished by how many times we have seen them
+		r.seen[key]++
+		if r.seen[key] <= r.sent[key] {
+			continue
+		}
+		r.sent[key]++
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+	if len(items) == len(chunk.Items) {
+		return chunk
+	}
+	return &proto.SearchResultChunk{
+		CommonFields: chunk.CommonFields,
+		Items:        items,
+	}
+}
@@ -0,0 +1,403 @@
+package loghub
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"kope.io/klogs/pkg/proto"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPolicy = callPolicy{
+	connectTimeout:    100 * time.Millisecond,
+	firstByteTimeout:  100 * time.Millisecond,
+	searchIdleTimeout: 500 * time.Millisecond,
+	retries:           2,
+	backoff:           10 * time.Millisecond,
+	maxBackoff:        20 * time.Millisecond,
+}
+
+// fakeSpoke is a member whose calls fail in controlled ways; search and getStreams are passed the number of the call
+type fakeSpoke struct {
+	proto.LogServerServer
+	search     func(call int, out proto.LogServer_SearchServer) error
+	getStreams func(call int, out proto.LogServer_GetStreamsServer) error
+	calls      int32
+}
+
+func (s *fakeSpoke) Health(ctx context.Context, request *proto.HealthRequest) (*proto.HealthResponse, error) {
+	return &proto.HealthResponse{}, nil
+}
+
+func (s *fakeSpoke) Search(request *proto.SearchRequest, out proto.LogServer_SearchServer) error {
+	return s.search(int(atomic.AddInt32(&s.calls, 1)), out)
+}
+
+func (s *fakeSpoke) GetStreams(request *proto.GetStreamsRequest, out proto.LogServer_GetStreamsServer) error {
+	return s.getStreams(int(atomic.AddInt32(&s.calls, 1)), out)
+}
+
+func (s *fakeSpoke) callCount() int {
+	return int(atomic.LoadInt32(&s.calls))
+}
+
+// newTestOp returns an operation against a member at url
+func newTestOp(t *testing.T, ctx context.Context, url string) *DistributedOp {
+	m := newTestMesh(t)
+	joinMesh(t, m, "node-1", url)
+	policy := testPolicy
+	return &DistributedOp{
+		ctx:    ctx,
+		member: m.Members()[0],
+		policy: &policy,
+	}
+}
+
+// hangingListener accepts connections but never responds, like a member that is stuck
+func hangingListener(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { l.Close() }
+}
+
+// fakeSearchServer collects the results forwarded by the hub
+type fakeSearchServer struct {
+	proto.LogServer_SearchServer
+	results map[string]int
+}
+
+func (f *fakeSearchServer) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fakeSearchServer) Send(chunk *proto.SearchResultChunk) error {
+	for _, item := range chunk.Items {
+		f.results[chunk.CommonFields.Fields[0].Value+"/"+string(item.Raw)]++
+	}
+	return nil
+}
+
+func sendLine(out proto.LogServer_SearchServer, file string, line string) error {
+	return out.Send(&proto.SearchResultChunk{
+		CommonFields: &proto.Fields{Fields: []*proto.Field{{Key: "file", Value: file}}},
+		Items:        []*proto.SearchResult{{Raw: []byte(line), Timestamp: 1}},
+	})
+}
+
+func searchOp(op *DistributedOp) (map[string]int, error) {
+	out := &fakeSearchServer{results: make(map[string]int)}
+	var sendMutex sync.Mutex
+	err := op.Search(&sendMutex, &proto.SearchRequest{}, out)
+	return out.results, err
+}
+
+func TestCallHangsConnecting(t *testing.T) {
+	url, stop := hangingListener(t)
+	defer stop()
+	op := newTestOp(t, context.Background(), url)
+
+	start := time.Now()
+	_, err := op.ListStreams(&proto.GetStreamsRequest{})
+	elapsed := time.Since(start)
+	if err == nil || !strings.Contains(err.Error(), "timed out connecting after 100ms") {
+		t.Errorf("unexpected error from ListStreams: %v", err)
+	}
+	// Each attempt waits for the connect timeout
+	if elapsed < 3*testPolicy.connectTimeout || elapsed > 3*time.Second {
+		t.Errorf("ListStreams took %v, expected 3 attempts of %v", elapsed, testPolicy.connectTimeout)
+	}
+
+	start = time.Now()
+	_, err = searchOp(op)
+	elapsed = time.Since(start)
+	if err == nil || !strings.Contains(err.Error(), "timed out connecting after 100ms") {
+		t.Errorf("unexpected error from Search: %v", err)
+	}
+	if elapsed < 3*testPolicy.connectTimeout || elapsed > 3*time.Second {
+		t.Errorf("Search took %v, expected 3 attempts of %v", elapsed, testPolicy.connectTimeout)
+	}
+}
+
+func TestListStreamsHangsBeforeFirstByte(t *testing.T) {
+	spoke := &fakeSpoke{
+		getStreams: func(call int, out proto.LogServer_GetStreamsServer) error {
+			<-out.Context().Done()
+			return nil
+		},
+	}
+	url, stop := serveSpoke(t, spoke)
+	defer stop()
+	op := newTestOp(t, context.Background(), url)
+
+	_, err := op.ListStreams(&proto.GetStreamsRequest{})
+	if err == nil || !strings.Contains(err.Error(), "timed out waiting for first result after 100ms") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if spoke.callCount() != 3 {
+		t.Errorf("member was called %d times, expected 3", spoke.callCount())
+	}
+}
+
+func TestSearchSlowFirstResult(t *testing.T) {
+	// The member scans for longer than the first byte timeout of other calls before it finds a match
+	spoke := &fakeSpoke{
+		search: func(call int, out proto.LogServer_SearchServer) error {
+			time.Sleep(3 * testPolicy.firstByteTimeout)
+			return sendLine(out, "a", "x")
+		},
+	}
+	url, stop := serveSpoke(t, spoke)
+	defer stop()
+	op := newTestOp(t, context.Background(), url)
+
+	results, err := searchOp(op)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if expected := map[string]int{"a/x": 1}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("results were %v, expected %v", results, expected)
+	}
+	if spoke.callCount() != 1 {
+		t.Errorf("member was called %d times, expected 1", spoke.callCount())
+	}
+}
+
+func TestSearchHangs(t *testing.T) {
+	grid := []struct {
+		name     string
+		sent     []string
+		expected map[string]int
+	}{
+		{name: "before first result", expected: map[string]int{}},
+		{name: "after first result", sent: []string{"x"}, expected: map[string]int{"a/x": 1}},
+	}
+	for _, g := range grid {
+		sent := g.sent
+		spoke := &fakeSpoke{
+			search: func(call int, out proto.LogServer_SearchServer) error {
+				for _, line := range sent {
+					if err := sendLine(out, "a", line); err != nil {
+						return err
+					}
+				}
+				<-out.Context().Done()
+				return nil
+			},
+		}
+		url, stop := serveSpoke(t, spoke)
+		op := newTestOp(t, context.Background(), url)
+
+		start := time.Now()
+		results, err := searchOp(op)
+		elapsed := time.Since(start)
+		stop()
+
+		if err == nil || !strings.Contains(err.Error(), "timed out waiting for results after 500ms") {
+			t.Errorf("%s: unexpected error: %v", g.name, err)
+		}
+		if !reflect.DeepEqual(results, g.expected) {
+			t.Errorf("%s: results were %v, expected %v", g.name, results, g.expected)
+		}
+		if spoke.callCount() != 3 {
+			t.Errorf("%s: member was called %d times, expected 3", g.name, spoke.callCount())
+		}
+		if elapsed < 3*testPolicy.searchIdleTimeout || elapsed > 10*time.Second {
+			t.Errorf("%s: Search took %v, expected 3 attempts of %v", g.name, elapsed, testPolicy.searchIdleTimeout)
+		}
+	}
+}
+
+func TestSearchRequestDeadline(t *testing.T) {
+	spoke := &fakeSpoke{
+		search: func(call int, out proto.LogServer_SearchServer) error {
+			<-out.Context().Done()
+			return nil
+		},
+	}
+	url, stop := serveSpoke(t, spoke)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	op := newTestOp(t, ctx, url)
+
+	start := time.Now()
+	_, err := searchOp(op)
+	if err == nil || !strings.Contains(err.Error(), "DeadlineExceeded") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Search took %v after the request deadline", elapsed)
+	}
+	if spoke.callCount() != 1 {
+		t.Errorf("member was called %d times, expected 1", spoke.callCount())
+	}
+}
+
+func TestSearchResumesWithoutDuplicates(t *testing.T) {
+	// The member searches its files in a different order each time, and the first failures calls fail after the
+	// first file
+	newSpoke := func(failures int) *fakeSpoke {
+		return &fakeSpoke{
+			search: func(call int, out proto.LogServer_SearchServer) error {
+				files := []string{"a", "b"}
+				if call%2 == 0 {
+					files = []string{"b", "a"}
+				}
+				for i, file := range files {
+					for _, line := range []string{"x", "y", "x"} {
+						if err := sendLine(out, file, line); err != nil {
+							return err
+						}
+					}
+					if call <= failures && i == 0 {
+						return status.Error(codes.Unavailable, "connection reset")
+					}
+				}
+				return nil
+			},
+		}
+	}
+
+	grid := []struct {
+		failures int
+		calls    int
+		expected map[string]int
+		err      string
+	}{
+		{failures: 0, calls: 1, expected: map[string]int{"a/x": 2, "a/y": 1, "b/x": 2, "b/y": 1}},
+		{failures: 1, calls: 2, expected: map[string]int{"a/x": 2, "a/y": 1, "b/x": 2, "b/y": 1}},
+		{failures: 2, calls: 3, expected: map[string]int{"a/x": 2, "a/y": 1, "b/x": 2, "b/y": 1}},
+		{
+			// When we run out of retries, we have still sent each result only once
+			failures: 3,
+			calls:    3,
+			expected: map[string]int{"a/x": 2, "a/y": 1, "b/x": 2, "b/y": 1},
+			err:      "error reading result",
+		},
+	}
+	for _, g := range grid {
+		spoke := newSpoke(g.failures)
+		url, stop := serveSpoke(t, spoke)
+		op := newTestOp(t, context.Background(), url)
+
+		results, err := searchOp(op)
+		stop()
+
+		if g.err == "" && err != nil {
+			t.Errorf("failures=%d: unexpected error: %v", g.failures, err)
+		}
+		if g.err != "" && (err == nil || !strings.Contains(err.Error(), g.err)) {
+			t.Errorf("failures=%d: error was %v, expected %q", g.failures, err, g.err)
+		}
+		if !reflect.DeepEqual(results, g.expected) {
+			t.Errorf("failures=%d: results were %v, expected %v", g.failures, results, g.expected)
+		}
+		if spoke.callCount() != g.calls {
+			t.Errorf("failures=%d: member was called %d times, expected %d", g.failures, spoke.callCount(), g.calls)
+		}
+	}
+}
+
+func TestListStreamsFailsMidStream(t *testing.T) {
+	spoke := &fakeSpoke{
+		getStreams: func(call int, out proto.LogServer_GetStreamsServer) error {
+			for _, name := range []string{"a", "b"} {
+				if err := out.Send(&proto.StreamInfo{PodName: name}); err != nil {
+					return err
+				}
+				if call == 1 {
+					return status.Error(codes.Unavailable, "connection reset")
+				}
+			}
+			return nil
+		},
+	}
+	url, stop := serveSpoke(t, spoke)
+	defer stop()
+	op := newTestOp(t, context.Background(), url)
+
+	streams, err := op.ListStreams(&proto.GetStreamsRequest{})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, stream := range streams {
+		names = append(names, stream.PodName)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("streams were %v, expected [a b]", names)
+	}
+	if spoke.callCount() != 2 {
+		t.Errorf("member was called %d times, expected 2", spoke.callCount())
+	}
+}
+
+func TestCallNotRetryable(t *testing.T) {
+	grid := []struct {
+		err   error
+		calls int
+	}{
+		{err: status.Error(codes.InvalidArgument, "bad request"), calls: 1},
+		{err: status.Error(codes.Internal, "bug in member"), calls: 1},
+		{err: status.Error(codes.PermissionDenied, "denied"), calls: 1},
+		{err: fmt.Errorf("error without a code"), calls: 1},
+		{err: status.Error(codes.Unavailable, "overloaded"), calls: 3},
+		{err: status.Error(codes.ResourceExhausted, "overloaded"), calls: 3},
+	}
+	for _, g := range grid {
+		memberErr := g.err
+		spoke := &fakeSpoke{
+			search: func(call int, out proto.LogServer_SearchServer) error {
+				return memberErr
+			},
+			getStreams: func(call int, out proto.LogServer_GetStreamsServer) error {
+				return memberErr
+			},
+		}
+		url, stop := serveSpoke(t, spoke)
+		op := newTestOp(t, context.Background(), url)
+
+		_, err := searchOp(op)
+		if err == nil || !strings.Contains(err.Error(), status.Convert(g.err).Message()) {
+			t.Errorf("%v: unexpected error from Search: %v", g.err, err)
+		}
+		if spoke.callCount() != g.calls {
+			t.Errorf("%v: Search called member %d times, expected %d", g.err, spoke.callCount(), g.calls)
+		}
+
+		atomic.StoreInt32(&spoke.calls, 0)
+		_, err = op.ListStreams(&proto.GetStreamsRequest{})
+		if err == nil {
+			t.Errorf("%v: expected error from ListStreams", g.err)
+		}
+		if spoke.callCount() != g.calls {
+			t.Errorf("%v: ListStreams called member %d times, expected %d", g.err, spoke.callCount(), g.calls)
+		}
+		stop()
+	}
+}
@@ -6,14 +6,22 @@ import (
 	"golang.org/x/net/context"
 	"io"
 	"kope.io/klogs/pkg/grpc"
+	"kope.io/klogs/pkg/logsearch"
 	"kope.io/klogs/pkg/mesh"
 	"kope.io/klogs/pkg/proto"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type LogServer struct {
 	grpcServer *grpc.GRPCServer
 	mesh       *mesh.Server
+	// archive searches the archived logs, if configured
+	archive *logsearch.ArchiveSearcher
+	// policy controls the timeouts and retries of our calls to members
+	policy callPolicy
 }
 
 var _ proto.LogServerServer = &LogServer{}
@@ -38,115 +46,399 @@ func (s *LogServer) ListenAndServe() error {
 	return s.grpcServer.ListenAndServe()
 }
 
-func (s *LogServer) GetStreams(request *proto.GetStreamsRequest, out proto.LogServer_GetStreamsServer) error {
-	ctx := out.Context()
-
+// selectMembers returns the members to query; if host is set only that member is returned, otherwise all members
+// that are not dead, along with the ids of the dead members we skipped
+func (s *LogServer) selectMembers(host string) ([]*mesh.Member, []string) {
 	members := s.mesh.Members()
-	for _, member := range members {
-		// TODO: Run in parallel?
-
-		client, err := member.LogsClient()
-		if err != nil {
-			// TODO: retries / toleration
-			return fmt.Errorf("error fetching client for %q: %v", member.Id(), err)
+	if host != "" {
+		for _, member := range members {
+			if member.Id() == host {
+				return []*mesh.Member{member}, nil
+			}
 		}
+		return nil, nil
+	}
 
-		stream, err := client.GetStreams(ctx, request)
-		if err != nil {
-			// TODO: retries / toleration
-			return fmt.Errorf("error querying member %q: %v", member.Id(), err)
+	var live []*mesh.Member
+	var dead []string
+	for _, member := range members {
+		if member.State() == proto.MemberState_DEAD {
+			glog.V(2).Infof("skipping dead member %q", member.Id())
+			dead = append(dead, member.Id())
+			continue
 		}
+		live = append(live, member)
+	}
+	return live, dead
+}
 
-		for {
-			in, err := stream.Recv()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				// TODO: retries / toleration
-				return fmt.Errorf("error reading from member %q: %v", member.Id(), err)
-			}
-			err = out.Send(in)
-			if err != nil {
-				return fmt.Errorf("error sending results: %v", err)
-			}
-		}
+// notMemberError is returned for requests for a single host that is not in the mesh
+func notMemberError(host string) error {
+	return fmt.Errorf("host %q is not a member of the mesh", host)
+}
+
+// Health reports the state of each mesh member
+func (s *LogServer) Health(ctx context.Context, request *proto.HealthRequest) (*proto.HealthResponse, error) {
+	response := &proto.HealthResponse{}
+	for _, member := range s.mesh.Members() {
+		response.Members = append(response.Members, member.Health())
 	}
+	sort.Slice(response.Members, func(i, j int) bool { return response.Members[i].Id < response.Members[j].Id })
+	return response, nil
+}
 
-	return nil
+// distributedResult records the members that failed a distributed operation
+type distributedResult struct {
+	members  int
+	failures []string
 }
 
-func (s *LogServer) Search(request *proto.SearchRequest, out proto.LogServer_SearchServer) error {
-	// TODO: Copy-pasted
-	ctx := out.Context()
+func (r *distributedResult) addFailure(id string, err error) {
+	r.failures = append(r.failures, fmt.Sprintf("%s: %v", id, err))
+}
 
-	members := s.mesh.Members()
+// err returns an error naming each member that failed, or nil if none failed
+func (r *distributedResult) err() error {
+	if len(r.failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("error from %d of %d members: %s", len(r.failures), r.members, strings.Join(r.failures, "; "))
+}
+
+// complete finishes a streaming request: failures are reported in the trailer so the client keeps the results from
+// the other members, and the request only fails if every member failed
+func (r *distributedResult) complete(out grpc.TrailerSetter) error {
+	if len(r.failures) == 0 {
+		return nil
+	}
+	if len(r.failures) == r.members {
+		return r.err()
+	}
 
-	glog.Warningf("member filtering not implemented")
+	partial := &grpc.PartialResults{
+		Members:  r.members,
+		Failures: r.failures,
+	}
+	partial.SetTrailer(out)
+	return nil
+}
 
-	var sendMutex sync.Mutex
+// runDistributed runs fn against each member in parallel, recording the members that failed; the dead members that
+// were skipped are recorded as failures
+func (s *LogServer) runDistributed(ctx context.Context, members []*mesh.Member, dead []string, fn func(op *DistributedOp) error) *distributedResult {
 	var wg sync.WaitGroup
 	ops := make([]*DistributedOp, len(members))
 	wg.Add(len(members))
 	for i, member := range members {
-		search := &DistributedOp{
+		op := &DistributedOp{
 			ctx:    ctx,
 			member: member,
+			policy: &s.policy,
 		}
-		ops[i] = search
+		ops[i] = op
 
-		go func(search *DistributedOp) {
-			search.err = search.Search(&sendMutex, request, out)
+		go func(op *DistributedOp) {
+			op.err = fn(op)
 			wg.Done()
-		}(search)
+		}(op)
 	}
 
 	wg.Wait()
 
+	result := &distributedResult{
+		members: len(members) + len(dead),
+	}
 	for _, op := range ops {
 		if op.err != nil {
-			return fmt.Errorf("error from member %q: %v", op.member.Id(), op.err)
+			glog.Warningf("error from member %q: %v", op.member.Id(), op.err)
+			result.addFailure(op.member.Id(), op.err)
 		}
 	}
+	for _, id := range dead {
+		result.addFailure(id, fmt.Errorf("member is dead"))
+	}
+	sort.Strings(result.failures)
 
-	return nil
+	return result
+}
+
+func (s *LogServer) GetStreams(request *proto.GetStreamsRequest, out proto.LogServer_GetStreamsServer) error {
+	members, dead := s.selectMembers(request.Host)
+	if request.Host != "" && len(members) == 0 {
+		return notMemberError(request.Host)
+	}
+
+	var sendMutex sync.Mutex
+	result := s.runDistributed(out.Context(), members, dead, func(op *DistributedOp) error {
+		return op.GetStreams(&sendMutex, request, out)
+	})
+	return result.complete(out)
+}
+
+func (s *LogServer) Search(request *proto.SearchRequest, out proto.LogServer_SearchServer) error {
+	// We can route searches for a single host directly to that member
+	host := ""
+	for _, filter := range request.FieldFilters {
+		if filter.Key == "host" && filter.Op == proto.FieldFilterOperator_EQ {
+			host = filter.Value
+		}
+	}
+	members, dead := s.selectMembers(host)
+	if host != "" && len(members) == 0 {
+		return notMemberError(host)
+	}
+
+	// Archived logs have no host, so only apply when we are searching all hosts
+	searchArchive := s.archive != nil && host == ""
+
+	var live []*proto.StreamInfo
+	if searchArchive {
+		var err error
+		live, err = s.liveStreams(out.Context(), members)
+		if err != nil {
+			// We may return archived entries that are still on that member
+			glog.Warningf("error listing streams for archive search: %v", err)
+		}
+	}
+
+	var sendMutex sync.Mutex
+	result := s.runDistributed(out.Context(), members, dead, func(op *DistributedOp) error {
+		return op.Search(&sendMutex, request, out)
+	})
+
+	if searchArchive {
+		// The archive is reported alongside the members, so a failed archive search still returns the live logs
+		result.members++
+		if err := s.archive.Search(request, live, out); err != nil {
+			glog.Warningf("error searching archive: %v", err)
+			result.addFailure("archive", err)
+		}
+	}
+
+	return result.complete(out)
+}
+
+// liveStreams returns the streams of the members, which the archive search uses to avoid returning duplicates
+func (s *LogServer) liveStreams(ctx context.Context, members []*mesh.Member) ([]*proto.StreamInfo, error) {
+	var mutex sync.Mutex
+	var streams []*proto.StreamInfo
+	result := s.runDistributed(ctx, members, nil, func(op *DistributedOp) error {
+		memberStreams, err := op.ListStreams(&proto.GetStreamsRequest{})
+		mutex.Lock()
+		streams = append(streams, memberStreams...)
+		mutex.Unlock()
+		return err
+	})
+	return streams, result.err()
+}
+
+// watchMemberInterval is how often WatchStreams checks for new mesh members, and the delay before re-watching a failed member
+var watchMemberInterval = 10 * time.Second
+
+// WatchStreams multiplexes the stream events from all members.  When we (re)connect to a member, it sends ADDED
+// events for all its streams, so clients should treat ADDED for a known stream as an update.
+func (s *LogServer) WatchStreams(request *proto.WatchStreamsRequest, out proto.LogServer_WatchStreamsServer) error {
+	ctx := out.Context()
+
+	var sendMutex sync.Mutex
+
+	// watching holds the member we are watching for each id; a node that rejoins after expiry is a new member
+	var watchingMutex sync.Mutex
+	watching := make(map[string]*mesh.Member)
+
+	for {
+		members, _ := s.selectMembers(request.Host)
+		for _, member := range members {
+			watchingMutex.Lock()
+			if watching[member.Id()] == member {
+				watchingMutex.Unlock()
+				continue
+			}
+			watching[member.Id()] = member
+			watchingMutex.Unlock()
+
+			op := &DistributedOp{
+				ctx:    ctx,
+				member: member,
+				policy: &s.policy,
+			}
+			go func(op *DistributedOp) {
+				member := op.member
+				defer func() {
+					watchingMutex.Lock()
+					if watching[member.Id()] == member {
+						delete(watching, member.Id())
+					}
+					watchingMutex.Unlock()
+				}()
+
+				for {
+					err := op.WatchStreams(&sendMutex, request, out)
+					if ctx.Err() != nil {
+						return
+					}
+					if err != nil {
+						glog.Warningf("error watching member %q: %v", member.Id(), err)
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case <-member.Removed():
+						glog.V(2).Infof("stopped watching member %q, which was removed from the mesh", member.Id())
+						return
+					case <-time.After(watchMemberInterval):
+					}
+				}
+			}(op)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchMemberInterval):
+		}
+	}
 }
 
 type DistributedOp struct {
 	ctx    context.Context
 	member *mesh.Member
+	policy *callPolicy
 	err    error
 }
 
+// Search forwards the results of the search on the member.  If the search fails part-way we retry it, skipping the
+// results we have already sent.
 func (s *DistributedOp) Search(sendMutex *sync.Mutex, request *proto.SearchRequest, out proto.LogServer_SearchServer) error {
+	var sent *sentResults
+	if s.policy.retries != 0 {
+		sent = newSentResults()
+	}
+
+	return s.withRetries(func(a *attempt) error {
+		client, err := s.member.LogsClient()
+		if err != nil {
+			return fmt.Errorf("error fetching client: %v", err)
+		}
+
+		a.startPhase("connecting", s.policy.connectTimeout)
+		stream, err := client.Search(a.ctx, request)
+		a.endPhase()
+		if err != nil {
+			return a.memberError("error querying member: %v", err)
+		}
+
+		if sent != nil {
+			sent.nextAttempt()
+		}
+		for {
+			// A member scanning large logs can send nothing for minutes, so we allow much longer than for the first
+			// result of other calls, and restart the timer after each result
+			a.startPhase("waiting for results", s.policy.searchIdleTimeout)
+			in, err := stream.Recv()
+			a.endPhase()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return a.memberError("error reading result: %v", err)
+			}
+			if sent != nil {
+				if in = sent.filter(in); in == nil {
+					continue
+				}
+			}
+			sendMutex.Lock()
+			err = out.Send(in)
+			sendMutex.Unlock()
+			if err != nil {
+				return fmt.Errorf("error sending results: %v", err)
+			}
+		}
+	})
+}
+
+// GetStreams forwards the streams of the member; we list them before sending so that we can retry
+func (s *DistributedOp) GetStreams(sendMutex *sync.Mutex, request *proto.GetStreamsRequest, out proto.LogServer_GetStreamsServer) error {
+	streams, err := s.ListStreams(request)
+
+	// We still send the streams we received from a failed member
+	for _, stream := range streams {
+		sendMutex.Lock()
+		sendErr := out.Send(stream)
+		sendMutex.Unlock()
+		if sendErr != nil {
+			return fmt.Errorf("error sending results: %v", sendErr)
+		}
+	}
+
+	return err
+}
+
+// ListStreams returns the streams of the member
+func (s *DistributedOp) ListStreams(request *proto.GetStreamsRequest) ([]*proto.StreamInfo, error) {
+	var streams []*proto.StreamInfo
+	err := s.withRetries(func(a *attempt) error {
+		streams = nil
+
+		client, err := s.member.LogsClient()
+		if err != nil {
+			return fmt.Errorf("error fetching client: %v", err)
+		}
+
+		a.startPhase("connecting", s.policy.connectTimeout)
+		stream, err := client.GetStreams(a.ctx, request)
+		if err != nil {
+			return a.memberError("error querying member: %v", err)
+		}
+
+		a.startPhase("waiting for first result", s.policy.firstByteTimeout)
+		for {
+			in, err := stream.Recv()
+			a.endPhase()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return a.memberError("error reading result: %v", err)
+			}
+			streams = append(streams, in)
+		}
+	})
+	return streams, err
+}
+
+// WatchStreams forwards the stream events of the member; it is not retried, as WatchStreams re-watches failed members
+func (s *DistributedOp) WatchStreams(sendMutex *sync.Mutex, request *proto.WatchStreamsRequest, out proto.LogServer_WatchStreamsServer) error {
 	client, err := s.member.LogsClient()
 	if err != nil {
-		// TODO: retries / toleration
 		return fmt.Errorf("error fetching client: %v", err)
 	}
 
-	stream, err := client.Search(s.ctx, request)
+	a := s.newAttempt()
+	defer a.close()
+
+	a.startPhase("connecting", s.policy.connectTimeout)
+	stream, err := client.WatchStreams(a.ctx, request)
+	a.endPhase()
 	if err != nil {
-		// TODO: retries / toleration
-		return fmt.Errorf("error querying member: %v", err)
+		return a.memberError("error querying member: %v", err)
 	}
 
 	for {
 		in, err := stream.Recv()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			// TODO: retries / toleration
-			return fmt.Errorf("error reading result: %v", err)
+			return a.memberError("error reading event: %v", err)
 		}
 		sendMutex.Lock()
 		err = out.Send(in)
 		sendMutex.Unlock()
 		if err != nil {
-			return fmt.Errorf("error sending results: %v", err)
+			return fmt.Errorf("error sending event: %v", err)
 		}
 	}
-
-	return nil
 }
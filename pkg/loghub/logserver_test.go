@@ -0,0 +1,280 @@
+package loghub
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	googlegrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"kope.io/klogs/pkg/grpc"
+	"kope.io/klogs/pkg/mesh"
+	"kope.io/klogs/pkg/proto"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveSpoke serves spoke on a local port, returning its url and a func that stops it
+func serveSpoke(t *testing.T, spoke proto.LogServerServer) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	server := googlegrpc.NewServer()
+	proto.RegisterLogServerServer(server, spoke)
+	go server.Serve(l)
+	return "http://" + l.Addr().String(), server.Stop
+}
+
+func newTestMesh(t *testing.T) *mesh.Server {
+	m, err := mesh.NewServer(&grpc.GRPCOptions{Listen: "http://127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("error building mesh: %v", err)
+	}
+	return m
+}
+
+func joinMesh(t *testing.T, m *mesh.Server, id string, url string) {
+	request := &proto.JoinMeshRequest{HostInfo: &proto.HostInfo{Id: id, Url: url}}
+	if _, err := m.JoinMesh(context.Background(), request); err != nil {
+		t.Fatalf("error joining mesh: %v", err)
+	}
+}
+
+// watchSpoke sends an ADDED event for a single stream to each watcher, then waits for the watch to end
+type watchSpoke struct {
+	proto.LogServerServer
+	podName string
+	watches int32
+}
+
+func (s *watchSpoke) Health(ctx context.Context, request *proto.HealthRequest) (*proto.HealthResponse, error) {
+	return &proto.HealthResponse{}, nil
+}
+
+func (s *watchSpoke) WatchStreams(request *proto.WatchStreamsRequest, out proto.LogServer_WatchStreamsServer) error {
+	atomic.AddInt32(&s.watches, 1)
+	event := &proto.StreamEvent{
+		Type:   proto.StreamEventType_ADDED,
+		Stream: &proto.StreamInfo{PodName: s.podName},
+	}
+	if err := out.Send(event); err != nil {
+		return err
+	}
+	<-out.Context().Done()
+	return nil
+}
+
+// fakeWatchServer delivers the events sent by the hub
+type fakeWatchServer struct {
+	proto.LogServer_WatchStreamsServer
+	ctx    context.Context
+	events chan *proto.StreamEvent
+}
+
+func (f *fakeWatchServer) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeWatchServer) Send(event *proto.StreamEvent) error {
+	f.events <- event
+	return nil
+}
+
+func (f *fakeWatchServer) expectEvent(t *testing.T, podName string) {
+	select {
+	case event := <-f.events:
+		if event.Stream.PodName != podName {
+			t.Fatalf("event was for pod %q, expected %q", event.Stream.PodName, podName)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event for pod %q", podName)
+	}
+}
+
+func TestWatchStreamsMemberExpiry(t *testing.T) {
+	defer func(interval time.Duration) { watchMemberInterval = interval }(watchMemberInterval)
+	watchMemberInterval = 20 * time.Millisecond
+
+	m := newTestMesh(t)
+	before := &watchSpoke{podName: "before"}
+	beforeURL, stopBefore := serveSpoke(t, before)
+	defer stopBefore()
+	joinMesh(t, m, "node-1", beforeURL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &fakeWatchServer{ctx: ctx, events: make(chan *proto.StreamEvent, 10)}
+	hub := &LogServer{mesh: m}
+	done := make(chan error)
+	go func() {
+		done <- hub.WatchStreams(&proto.WatchStreamsRequest{}, out)
+	}()
+	out.expectEvent(t, "before")
+
+	expired := m.Members()[0]
+	m.ExpireMembers(time.Now().Add(time.Hour))
+	if len(m.Members()) != 0 {
+		t.Fatalf("member was not expired")
+	}
+	select {
+	case <-expired.Removed():
+	default:
+		t.Errorf("Removed was not closed when the member expired")
+	}
+	if _, err := expired.LogsClient(); err == nil {
+		t.Errorf("LogsClient connected to a removed member")
+	}
+
+	// The node restarts and rejoins at a new address
+	after := &watchSpoke{podName: "after"}
+	afterURL, stopAfter := serveSpoke(t, after)
+	defer stopAfter()
+	joinMesh(t, m, "node-1", afterURL)
+	out.expectEvent(t, "after")
+
+	// Give the old watch time to retry, if it were still running
+	time.Sleep(10 * watchMemberInterval)
+	if watches := atomic.LoadInt32(&before.watches); watches != 1 {
+		t.Errorf("expired member was watched %d times, expected 1", watches)
+	}
+	if watches := atomic.LoadInt32(&after.watches); watches != 1 {
+		t.Errorf("rejoined member was watched %d times, expected 1", watches)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error from WatchStreams: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("WatchStreams did not return when the watch was cancelled")
+	}
+}
+
+func TestSearchRoutesByHost(t *testing.T) {
+	m := newTestMesh(t)
+	spokes := make(map[string]*fakeSpoke)
+	for _, id := range []string{"node-1", "node-2"} {
+		file := id + ".log"
+		spoke := &fakeSpoke{
+			search: func(call int, out proto.LogServer_SearchServer) error {
+				return sendLine(out, file, "line")
+			},
+		}
+		url, stop := serveSpoke(t, spoke)
+		defer stop()
+		joinMesh(t, m, id, url)
+		spokes[id] = spoke
+	}
+	hub := &LogServer{mesh: m, policy: testPolicy}
+
+	grid := []struct {
+		name     string
+		filters  []*proto.FieldFilter
+		expected map[string]int
+		err      string
+	}{
+		{
+			name:     "all hosts",
+			expected: map[string]int{"node-1.log/line": 1, "node-2.log/line": 1},
+		},
+		{
+			name:     "single host",
+			filters:  []*proto.FieldFilter{{Key: "host", Op: proto.FieldFilterOperator_EQ, Value: "node-2"}},
+			expected: map[string]int{"node-2.log/line": 1},
+		},
+		{
+			// Other operators are left to the members
+			name:     "excluded host",
+			filters:  []*proto.FieldFilter{{Key: "host", Op: proto.FieldFilterOperator_NOT_EQ, Value: "node-2"}},
+			expected: map[string]int{"node-1.log/line": 1, "node-2.log/line": 1},
+		},
+		{
+			name:     "unknown host",
+			filters:  []*proto.FieldFilter{{Key: "host", Op: proto.FieldFilterOperator_EQ, Value: "node-3"}},
+			expected: map[string]int{},
+			err:      `host "node-3" is not a member of the mesh`,
+		},
+	}
+	for _, g := range grid {
+		out := &fakeSearchServer{results: make(map[string]int)}
+		err := hub.Search(&proto.SearchRequest{FieldFilters: g.filters}, out)
+		if g.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", g.name, err)
+		} else if g.err != "" && (err == nil || err.Error() != g.err) {
+			t.Errorf("%s: error was %v, expected %q", g.name, err, g.err)
+		}
+		if !reflect.DeepEqual(out.results, g.expected) {
+			t.Errorf("%s: results were %v, expected %v", g.name, out.results, g.expected)
+		}
+	}
+	if calls := spokes["node-1"].callCount(); calls != 2 {
+		t.Errorf("node-1 was searched %d times, expected 2", calls)
+	}
+
+	// Listing streams fails the same way for an unknown host
+	err := hub.GetStreams(&proto.GetStreamsRequest{Host: "node-3"}, nil)
+	if err == nil || err.Error() != `host "node-3" is not a member of the mesh` {
+		t.Errorf("GetStreams error was %v", err)
+	}
+}
+
+// fakeTrailerSetter records the trailer set on a stream
+type fakeTrailerSetter struct {
+	trailer metadata.MD
+}
+
+func (f *fakeTrailerSetter) SetTrailer(md metadata.MD) {
+	f.trailer = metadata.Join(f.trailer, md)
+}
+
+func TestPartialResultsTrailer(t *testing.T) {
+	grid := []struct {
+		name     string
+		members  int
+		failures map[string]error
+		expected *grpc.PartialResults
+		err      string
+	}{
+		{
+			name:    "no failures",
+			members: 3,
+		},
+		{
+			name:     "some members failed",
+			members:  3,
+			failures: map[string]error{"node-1": fmt.Errorf("member is dead"), "node-3": fmt.Errorf("connection\nreset")},
+			// Control characters cannot be sent in the trailer
+			expected: &grpc.PartialResults{Members: 3, Failures: []string{"node-1: member is dead", "node-3: connection reset"}},
+		},
+		{
+			name:     "all members failed",
+			members:  2,
+			failures: map[string]error{"node-1": fmt.Errorf("member is dead"), "node-2": fmt.Errorf("timeout")},
+			err:      "error from 2 of 2 members: node-1: member is dead; node-2: timeout",
+		},
+	}
+	for _, g := range grid {
+		result := &distributedResult{members: g.members}
+		for _, id := range []string{"node-1", "node-2", "node-3"} {
+			if err := g.failures[id]; err != nil {
+				result.addFailure(id, err)
+			}
+		}
+
+		out := &fakeTrailerSetter{}
+		err := result.complete(out)
+		if g.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", g.name, err)
+		} else if g.err != "" && (err == nil || err.Error() != g.err) {
+			t.Errorf("%s: error was %v, expected %q", g.name, err, g.err)
+		}
+
+		actual := grpc.ParsePartialResults(out.trailer)
+		if !reflect.DeepEqual(actual, g.expected) {
+			t.Errorf("%s: partial results were %v, expected %v", g.name, actual, g.expected)
+		}
+	}
+}
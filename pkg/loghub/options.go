@@ -2,18 +2,55 @@ package loghub
 
 import (
 	"github.com/golang/glog"
+	"kope.io/klogs/pkg/archive"
 	"kope.io/klogs/pkg/grpc"
+	"kope.io/klogs/pkg/logsearch"
 	"kope.io/klogs/pkg/mesh"
+	"kope.io/klogs/pkg/proto"
+	"time"
 )
 
 type Options struct {
 	LogGRPC  grpc.GRPCOptions
 	MeshGRPC grpc.GRPCOptions
+
+	// Archive is the location of the archived logs (as written by the spokes), to include in searches; empty disables
+	Archive string
+	// ArchiveRetention is how long archived logs are kept; zero keeps them forever
+	ArchiveRetention time.Duration
+	// ArchiveNamespaceRetention overrides ArchiveRetention for namespaces, as namespace=duration
+	ArchiveNamespaceRetention []string
+	// ArchivePruneInterval is how often we remove archived logs past their retention; zero disables
+	ArchivePruneInterval time.Duration
+
+	// MemberConnectTimeout is how long we wait to start a call to a member; zero waits forever
+	MemberConnectTimeout time.Duration
+	// MemberFirstByteTimeout is how long we wait for a member to send its first stream (or the end of the list) when
+	// listing streams; zero waits forever
+	MemberFirstByteTimeout time.Duration
+	// MemberSearchIdleTimeout is how long we wait for each result (or the end of the results) of a search on a member;
+	// zero waits forever.  It is much longer than MemberFirstByteTimeout, as a member scanning large logs can
+	// legitimately send nothing for minutes.
+	MemberSearchIdleTimeout time.Duration
+	// MemberRetries is how many times we retry a call to a member that timed out or failed transiently.  Only calls
+	// that are safe to repeat are retried; searches resume without repeating the results already returned.
+	MemberRetries int
+	// MemberRetryBackoff is the delay before the first retry, doubling for each subsequent retry
+	MemberRetryBackoff time.Duration
+	// MemberRetryMaxBackoff limits the delay between retries; zero is unlimited
+	MemberRetryMaxBackoff time.Duration
 }
 
 func (o *Options) SetDefaults() {
 	o.LogGRPC.Listen = "https://:7777"
 	o.MeshGRPC.Listen = "http://:7878"
+	o.ArchivePruneInterval = 6 * time.Hour
+	o.MemberConnectTimeout = 5 * time.Second
+	o.MemberFirstByteTimeout = time.Minute
+	o.MemberSearchIdleTimeout = 5 * time.Minute
+	o.MemberRetries = 2
+	o.MemberRetryBackoff = 500 * time.Millisecond
+	o.MemberRetryMaxBackoff = 5 * time.Second
 }
 
 func ListenAndServe(options *Options) error {
@@ -26,6 +63,40 @@ func ListenAndServe(options *Options) error {
 	if err != nil {
 		return err
 	}
+	logServer.policy = callPolicy{
+		connectTimeout:    options.MemberConnectTimeout,
+		firstByteTimeout:  options.MemberFirstByteTimeout,
+		searchIdleTimeout: options.MemberSearchIdleTimeout,
+		retries:           options.MemberRetries,
+		backoff:           options.MemberRetryBackoff,
+		maxBackoff:        options.MemberRetryMaxBackoff,
+	}
+
+	policy := archive.RetentionPolicy{
+		MaxAge: options.ArchiveRetention,
+	}
+	policy.NamespaceMaxAge, err = archive.ParseNamespaceRetention(options.ArchiveNamespaceRetention)
+	if err != nil {
+		return err
+	}
+
+	var archiveReader archive.Reader
+	if options.Archive != "" {
+		archiveReader, err = archive.NewReader(options.Archive)
+		if err != nil {
+			return err
+		}
+		logServer.archive, err = logsearch.NewArchiveSearcher(archiveReader)
+		if err != nil {
+			return err
+		}
+	}
+
+	archiveServer := newArchiveServer(archiveReader, logServer.archive, policy)
+	proto.RegisterArchiveServiceServer(logServer.grpcServer.Server, archiveServer)
+	if archiveReader != nil && options.ArchivePruneInterval != 0 && (policy.MaxAge != 0 || len(policy.NamespaceMaxAge) != 0) {
+		go archiveServer.runPruner(options.ArchivePruneInterval)
+	}
 
 	go func() {
 		if err := m.ListenAndServe(); err != nil {
@@ -0,0 +1,230 @@
+package logsearch
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"kope.io/klogs/pkg/archive"
+	"kope.io/klogs/pkg/proto"
+	"path"
+	"strings"
+	"time"
+)
+
+// ArchiveSearcher searches the archived logs, so logs remain searchable once they are gone from the nodes.
+// The hub uses it alongside the searches of the live nodes.
+type ArchiveSearcher struct {
+	reader archive.Reader
+}
+
+func NewArchiveSearcher(reader archive.Reader) (*ArchiveSearcher, error) {
+	a := &ArchiveSearcher{
+		reader: reader,
+	}
+	return a, nil
+}
+
+// archivePrefixes returns the key prefixes that can hold matching files, using the pod filters of the request
+func archivePrefixes(request *proto.SearchRequest) []string {
+	filters := make(map[string]string)
+	for _, filter := range request.FieldFilters {
+		if filter.Op == proto.FieldFilterOperator_EQ {
+			filters[filter.Key] = filter.Value
+		}
+	}
+
+	containers := "containers"
+	if namespace := filters["pod.namespace"]; namespace != "" {
+		containers = path.Join(containers, namespace)
+		if name := filters["pod.name"]; name != "" {
+			containers = path.Join(containers, name)
+		}
+	}
+
+	pods := "pods"
+	if uid := filters["pod.uid"]; uid != "" {
+		pods = path.Join(pods, uid)
+	}
+
+	return []string{containers, pods}
+}
+
+// archiveFields builds the fields of an archived file: those recorded in its manifest, or else those we can
+// recover from the key
+func archiveFields(manifestFields *proto.Fields, streamInfo *proto.StreamInfo, filePath string) *proto.Fields {
+	fields := &proto.Fields{}
+	addField := func(k, v string) {
+		if v != "" {
+			fields.Fields = append(fields.Fields, &proto.Field{Key: k, Value: v})
+		}
+	}
+	if manifestFields != nil && len(manifestFields.Fields) != 0 {
+		fields.Fields = append(fields.Fields, manifestFields.Fields...)
+	} else {
+		addField("pod.namespace", streamInfo.PodNamespace)
+		addField("pod.name", streamInfo.PodName)
+		addField("pod.uid", streamInfo.PodUid)
+		addField("container.name", streamInfo.ContainerName)
+		addField("container.id", streamInfo.ContainerId)
+		if streamInfo.ContainerId == "" {
+			addField("path", filePath)
+		}
+	}
+	addField("source", "archive")
+	return fields
+}
+
+// Search sends the matching entries from the archive.  live are the streams still on the nodes; for those streams
+// we only return entries from before the data the node holds, so results are not duplicated.
+func (a *ArchiveSearcher) Search(request *proto.SearchRequest, live []*proto.StreamInfo, out proto.LogServer_SearchServer) error {
+	glog.V(2).Infof("Archive search %q", request)
+
+	cutoffs := make(map[string]uint64)
+	for _, streamInfo := range live {
+		k := archive.StreamID(streamInfo)
+		if k == "" {
+			continue
+		}
+		if existing, found := cutoffs[k]; !found || streamInfo.FirstTimestamp < existing {
+			cutoffs[k] = streamInfo.FirstTimestamp
+		}
+	}
+
+	manifests, err := a.findManifests(request)
+	if err != nil {
+		return err
+	}
+
+	search := NewSearch(request, out)
+	for _, m := range manifests {
+		streamInfo, filePath := archive.ParseKey(m.Key)
+		if streamInfo == nil {
+			continue
+		}
+
+		parse := LineParser(ParseTextLine)
+		if streamInfo.ContainerId != "" {
+			parse = ParseDockerLine
+		}
+
+		file := m.File
+		file.Fields = archiveFields(m.File.Fields, streamInfo, filePath)
+		canMatch, unmatched := CanMatch(&file, request)
+		if !canMatch {
+			glog.V(4).Infof("Excluded archived file %s maxTimestamp=%d", m.Key, file.MaxTimestamp)
+			continue
+		}
+
+		scan := &Scan{
+			SourcePath: m.Key,
+			Fields:     file.Fields,
+			Unmatched:  unmatched,
+		}
+		if cutoff, found := cutoffs[archive.StreamID(streamInfo)]; found {
+			if cutoff == 0 {
+				// We can't tell which entries the node still holds
+				continue
+			}
+			scan.Before = cutoff
+		}
+
+		if err := a.searchObject(scan, parse, search); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the archived files matching the filters.  Unlike Search, files are excluded if a filter is on a
+// field that the file does not record.
+func (a *ArchiveSearcher) List(filters []*proto.FieldFilter) ([]*proto.ArchivedFile, error) {
+	request := &proto.SearchRequest{FieldFilters: filters}
+	manifests, err := a.findManifests(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*proto.ArchivedFile
+	for _, m := range manifests {
+		streamInfo, filePath := archive.ParseKey(m.Key)
+		if streamInfo == nil {
+			continue
+		}
+
+		file := m.File
+		file.Fields = archiveFields(m.File.Fields, streamInfo, filePath)
+		canMatch, unmatched := CanMatch(&file, request)
+		if !canMatch {
+			continue
+		}
+		for _, filter := range unmatched {
+			if filter.Key != "@timestamp" {
+				canMatch = false
+			}
+		}
+		if !canMatch {
+			continue
+		}
+
+		files = append(files, &proto.ArchivedFile{
+			Key:  m.Key,
+			File: &file,
+		})
+	}
+	return files, nil
+}
+
+// Open returns the contents of an archived file
+func (a *ArchiveSearcher) Open(key string) (io.ReadCloser, error) {
+	// Only allow reading of archived log files, not arbitrary objects
+	if streamInfo, _ := archive.ParseKey(key); streamInfo == nil || strings.Contains(key, "..") {
+		return nil, fmt.Errorf("invalid archive key %q", key)
+	}
+	return a.reader.Open(key)
+}
+
+// archiveIndexMaxDays is the longest time range for which we use the per-day index, rather than listing by stream
+const archiveIndexMaxDays = 31
+
+// findManifests returns the manifests of the archived files that could match the request
+func (a *ArchiveSearcher) findManifests(request *proto.SearchRequest) ([]*archive.Manifest, error) {
+	for _, filter := range request.FieldFilters {
+		if filter.Key != "@timestamp" || filter.Op != proto.FieldFilterOperator_GTE {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, filter.Value)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		if t.Add(archiveIndexMaxDays * 24 * time.Hour).After(now) {
+			return archive.ListManifestsByDay(a.reader, t, now)
+		}
+	}
+
+	var manifests []*archive.Manifest
+	for _, prefix := range archivePrefixes(request) {
+		prefixManifests, err := archive.ListManifests(a.reader, prefix)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, prefixManifests...)
+	}
+	return manifests, nil
+}
+
+// searchObject sends the matching entries of the archived file, parsing its lines with parse
+func (a *ArchiveSearcher) searchObject(scan *Scan, parse LineParser, search *Search) error {
+	glog.V(2).Infof("search archived file %q: %v", scan.SourcePath, scan.Unmatched)
+
+	in, err := a.reader.Open(scan.SourcePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := scan.SearchLines(in, parse, search); err != nil {
+		return fmt.Errorf("error searching archived file %q: %v", scan.SourcePath, err)
+	}
+	return nil
+}
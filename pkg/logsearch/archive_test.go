@@ -0,0 +1,142 @@
+package logsearch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
+	_ "kope.io/klogs/pkg/archive/filearchive"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeSearchServer collects the results sent by a search
+type fakeSearchServer struct {
+	proto.LogServer_SearchServer
+	chunks []*proto.SearchResultChunk
+}
+
+func (f *fakeSearchServer) Send(chunk *proto.SearchResultChunk) error {
+	f.chunks = append(f.chunks, chunk)
+	return nil
+}
+
+var (
+	t1 = time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)
+	t2 = t1.Add(time.Hour)
+	t3 = t2.Add(time.Hour)
+)
+
+const (
+	podFileKey       = "pods/uid-1/logs/app.log"
+	containerFileKey = "containers/shop/web-0/web/cid-1/0.log"
+)
+
+func podLine(ts time.Time) string {
+	return fmt.Sprintf("%s pod %s", ts.Format(time.RFC3339), ts.Format("15:04"))
+}
+
+// newTestArchive archives a pod volume file and a container log of the same pod, each with entries at t1, t2 and t3
+func newTestArchive(t *testing.T, dir string) archive.Reader {
+	sink, err := archive.NewSink("file://" + filepath.Join(dir, "archive"))
+	if err != nil {
+		t.Fatalf("error building sink: %v", err)
+	}
+
+	var podLines, containerLines string
+	for _, ts := range []time.Time{t1, t2, t3} {
+		podLines += podLine(ts) + "\n"
+		containerLines += fmt.Sprintf("{\"log\":\"container %s\\n\",\"stream\":\"stdout\",\"time\":%q}\n", ts.Format("15:04"), ts.Format(time.RFC3339Nano))
+	}
+
+	fileInfo := &proto.LogFile{
+		Fields: &proto.Fields{Fields: []*proto.Field{
+			{Key: "pod.uid", Value: "uid-1"},
+			{Key: "pod.name", Value: "web-0"},
+			{Key: "pod.namespace", Value: "shop"},
+		}},
+		MinTimestamp: uint64(t1.UnixNano()),
+		MaxTimestamp: uint64(t3.UnixNano()),
+	}
+	for key, data := range map[string]string{podFileKey: podLines, containerFileKey: containerLines} {
+		p := filepath.Join(dir, "source.log")
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatalf("error writing %q: %v", p, err)
+		}
+		if err := sink.AddToArchive(p, key, fileInfo); err != nil {
+			t.Fatalf("error archiving %q: %v", key, err)
+		}
+	}
+	return sink.(archive.Reader)
+}
+
+func TestArchiveSearchLiveCutoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logsearch")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, err := NewArchiveSearcher(newTestArchive(t, dir))
+	if err != nil {
+		t.Fatalf("error building searcher: %v", err)
+	}
+
+	allPod := []string{podLine(t1), podLine(t2), podLine(t3)}
+	allContainer := []string{"container 10:00\n", "container 11:00\n", "container 12:00\n"}
+	grid := []struct {
+		name     string
+		live     []*proto.StreamInfo
+		expected []string
+	}{
+		{name: "nothing live", expected: append(allPod, allContainer...)},
+		{
+			// Pod volume streams have the container name of single-container pods, but no container id
+			name:     "live pod volume",
+			live:     []*proto.StreamInfo{{PodUid: "uid-1", PodName: "web-0", ContainerName: "web", FirstTimestamp: uint64(t2.UnixNano())}},
+			expected: append([]string{podLine(t1)}, allContainer...),
+		},
+		{
+			name:     "live container",
+			live:     []*proto.StreamInfo{{PodUid: "uid-1", ContainerName: "web", ContainerId: "cid-1", FirstTimestamp: uint64(t3.UnixNano())}},
+			expected: append(allPod, "container 10:00\n", "container 11:00\n"),
+		},
+		{
+			name:     "live pod volume without timestamps",
+			live:     []*proto.StreamInfo{{PodUid: "uid-1"}},
+			expected: allContainer,
+		},
+		{
+			name:     "other pod live",
+			live:     []*proto.StreamInfo{{PodUid: "uid-2", FirstTimestamp: uint64(t1.UnixNano())}},
+			expected: append(allPod, allContainer...),
+		},
+	}
+	for _, g := range grid {
+		out := &fakeSearchServer{}
+		if err := a.Search(&proto.SearchRequest{}, g.live, out); err != nil {
+			t.Fatalf("%s: error searching: %v", g.name, err)
+		}
+
+		var actual []string
+		for _, chunk := range out.chunks {
+			for _, item := range chunk.Items {
+				for _, f := range item.Fields.Fields {
+					if f.Key == "log" {
+						actual = append(actual, f.Value)
+					}
+				}
+			}
+		}
+		sort.Strings(actual)
+		expected := append([]string(nil), g.expected...)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: found %q, expected %q", g.name, actual, expected)
+		}
+	}
+}
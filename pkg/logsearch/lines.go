@@ -0,0 +1,115 @@
+package logsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/golang/glog"
+	"io"
+	"kope.io/klogs/pkg/proto"
+	"time"
+)
+
+// LineParser fills in the fields & timestamp of item from a single line, returning the approximate added size
+type LineParser func(line []byte, item *proto.SearchResult) int
+
+// SearchLines sends the matching entries of newline-delimited contents, read from in
+func (s *Scan) SearchLines(in io.Reader, parse LineParser, search *Search) error {
+	w := NewResultWriter(search.Out, s.Fields)
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(search.buffer, cap(search.buffer))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		if !search.Matches(line) {
+			continue
+		}
+
+		item := &proto.SearchResult{}
+		item.Raw = line
+		itemSize := 8 + len(line)
+		itemSize += parse(line, item)
+
+		if !s.Matches(item) {
+			continue
+		}
+		if s.Before != 0 && (item.Timestamp == 0 || item.Timestamp >= s.Before) {
+			continue
+		}
+
+		if err := w.Add(item, itemSize); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := scanner.Err(); err != nil {
+		glog.Warningf("error reading log file %q: %v", s.SourcePath, err)
+	}
+	return nil
+}
+
+type dockerLine struct {
+	Log    string `json:"log,omitempty"`
+	Stream string `json:"stream,omitempty"`
+	Time   string `json:"time,omitempty"`
+}
+
+// ParseDockerLine parses a line of the json-file logs written by docker; lines that are not JSON are treated as text
+func ParseDockerLine(line []byte, item *proto.SearchResult) int {
+	var l dockerLine
+	err := json.Unmarshal(line, &l)
+	if err != nil {
+		return ParseTextLine(line, item)
+	}
+
+	itemSize := 0
+	fields := item.Fields
+	if fields == nil {
+		fields = &proto.Fields{}
+		item.Fields = fields
+	}
+	if l.Log != "" {
+		fields.Fields = append(fields.Fields, &proto.Field{
+			Key:   "log",
+			Value: l.Log,
+		})
+		itemSize += 8 + len(l.Log)
+	}
+	if l.Stream != "" {
+		fields.Fields = append(fields.Fields, &proto.Field{
+			Key:   "stream",
+			Value: l.Stream,
+		})
+		itemSize += 8 + len(l.Stream)
+	}
+	if l.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, l.Time)
+		if err == nil {
+			item.Timestamp = uint64(t.UnixNano())
+		}
+		itemSize += 10
+	}
+	return itemSize
+}
+
+// ParseTextLine parses a plain-text log line, recognizing common timestamp prefixes
+func ParseTextLine(line []byte, item *proto.SearchResult) int {
+	item.Fields = &proto.Fields{
+		Fields: []*proto.Field{
+			{
+				Key:   "log",
+				Value: string(line),
+			},
+		},
+	}
+	itemSize := 8 + len(line)
+	if ts, ok := ParseTextTimestamp(line); ok {
+		item.Timestamp = ts
+		itemSize += 10
+	}
+	return itemSize
+}
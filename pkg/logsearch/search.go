@@ -0,0 +1,234 @@
+package logsearch
+
+import (
+	"bytes"
+	"github.com/golang/glog"
+	"kope.io/klogs/pkg/proto"
+	"time"
+)
+
+// LineBufferSize is the longest line we read from a log file
+const LineBufferSize = 1024 * 1024
+
+const chunkFlushSize = 64 * 1024
+
+// Search holds the state shared by all the file scans of a search
+type Search struct {
+	Request *proto.SearchRequest
+	Out     proto.LogServer_SearchServer
+
+	matchBytes []byte
+	buffer     []byte
+}
+
+func NewSearch(request *proto.SearchRequest, out proto.LogServer_SearchServer) *Search {
+	// TODO: Build callback class
+	var matchBytes []byte
+	if request.Contains != "" {
+		glog.Warningf("JSON match encoding not yet implemented")
+		matchBytes = []byte(request.Contains)
+	}
+
+	return &Search{
+		Request:    request,
+		Out:        out,
+		matchBytes: matchBytes,
+		buffer:     make([]byte, LineBufferSize, LineBufferSize),
+	}
+}
+
+// Matches returns false if the raw entry cannot match the search
+func (s *Search) Matches(line []byte) bool {
+	if s.matchBytes != nil {
+		if bytes.Index(line, s.matchBytes) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan is the search of a single file
+type Scan struct {
+	SourcePath string
+	// Fields are the fields of the file, sent as the common fields of the results
+	Fields *proto.Fields
+	// Unmatched are the filters that could not be resolved against the file metadata
+	Unmatched []*proto.FieldFilter
+	// Before, if set, restricts the results to entries with timestamps before it
+	Before uint64
+}
+
+// CanMatch returns false if the file cannot match the request, judging by its fields and timestamps.
+// Otherwise it returns the filters that must be applied to each entry.
+func CanMatch(file *proto.LogFile, request *proto.SearchRequest) (bool, []*proto.FieldFilter) {
+	if len(request.FieldFilters) == 0 {
+		return true, nil
+	}
+
+	unmatched := make([]*proto.FieldFilter, 0, len(request.FieldFilters))
+
+	for _, filter := range request.FieldFilters {
+		mismatch := false
+		processed := false
+
+		// TODO: Well known fields
+		if filter.Key == "@timestamp" {
+			// TODO: Non-string values
+			t, err := time.Parse(time.RFC3339Nano, filter.Value)
+			if err != nil {
+				glog.Warningf("ignoring error parsing @timestamp value %q", filter.Value)
+				continue
+			}
+
+			switch filter.Op {
+			case proto.FieldFilterOperator_GTE:
+				if file.MaxTimestamp != 0 && file.MaxTimestamp < uint64(t.UnixNano()) {
+					mismatch = true
+				}
+
+			default:
+				glog.Warningf("Unhandled operator: %v", filter)
+			}
+		} else if file.Fields != nil {
+			for _, actual := range file.Fields.Fields {
+				if filter.Key == actual.Key {
+					processed = true
+
+					switch filter.Op {
+					case proto.FieldFilterOperator_NOT_EQ:
+						if actual.Value == filter.Value {
+							mismatch = true
+						}
+					case proto.FieldFilterOperator_EQ:
+						if actual.Value != filter.Value {
+							mismatch = true
+						}
+
+					default:
+						glog.Warningf("Unhandled operator: %v", filter)
+					}
+
+					break
+				}
+			}
+		}
+		if mismatch {
+			return false, nil
+		}
+		if !processed {
+			unmatched = append(unmatched, filter)
+		}
+	}
+
+	return true, unmatched
+}
+
+// Matches applies the filters that could not be resolved against the file metadata
+func (s *Scan) Matches(item *proto.SearchResult) bool {
+	if len(s.Unmatched) == 0 {
+		return true
+	}
+
+	itemFields := item.Fields
+	if itemFields == nil {
+		return false
+	}
+
+	match := true
+	for _, filter := range s.Unmatched {
+		// TODO: Well known fields
+		if filter.Key == "@timestamp" {
+			// TODO: What if no timestamp?
+
+			// TODO: Non-string values
+			t, err := time.Parse(time.RFC3339Nano, filter.Value)
+			if err != nil {
+				glog.Warningf("ignoring error parsing @timestamp value %q", filter.Value)
+				continue
+			}
+
+			switch filter.Op {
+			case proto.FieldFilterOperator_GTE:
+				if !(item.Timestamp >= uint64(t.UnixNano())) {
+					match = false
+				}
+
+			default:
+				glog.Warningf("Unhandled operator: %v", filter)
+			}
+		} else {
+			found := false
+			for _, actual := range itemFields.Fields {
+				if actual.Key == filter.Key {
+					found = true
+					switch filter.Op {
+					case proto.FieldFilterOperator_NOT_EQ:
+						if actual.Value == filter.Value {
+							match = false
+						}
+					case proto.FieldFilterOperator_EQ:
+						if actual.Value != filter.Value {
+							match = false
+						}
+
+					default:
+						glog.Warningf("Unhandled operator: %v", filter)
+					}
+					break
+				}
+			}
+			if !found {
+				match = false
+			}
+		}
+
+		if !match {
+			break
+		}
+	}
+
+	return match
+}
+
+// ResultWriter batches search results into chunks, so we don't send a message per line
+type ResultWriter struct {
+	out          proto.LogServer_SearchServer
+	commonFields *proto.Fields
+
+	chunk     *proto.SearchResultChunk
+	chunkSize int
+}
+
+func NewResultWriter(out proto.LogServer_SearchServer, commonFields *proto.Fields) *ResultWriter {
+	return &ResultWriter{
+		out:          out,
+		commonFields: commonFields,
+	}
+}
+
+// Add queues the result, sending a chunk if enough results are queued; itemSize is the approximate size of item
+func (w *ResultWriter) Add(item *proto.SearchResult, itemSize int) error {
+	if w.chunk == nil {
+		w.chunk = &proto.SearchResultChunk{}
+		w.chunkSize = 32
+		w.chunk.CommonFields = w.commonFields
+	}
+
+	w.chunk.Items = append(w.chunk.Items, item)
+	w.chunkSize += itemSize
+
+	if w.chunkSize > chunkFlushSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush sends the queued results
+func (w *ResultWriter) Flush() error {
+	if w.chunk == nil {
+		return nil
+	}
+	chunk := w.chunk
+	w.chunk = nil
+	return w.out.Send(chunk)
+}
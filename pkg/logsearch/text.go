@@ -0,0 +1,59 @@
+package logsearch
+
+import (
+	"bytes"
+	"time"
+)
+
+// glogTimestampLayout is the timestamp embedded in glog lines, e.g. I1019 16:02:01.123456
+const glogTimestampLayout = "0102 15:04:05.000000"
+
+// ParseTextTimestamp makes a best-effort attempt to find the timestamp of a plain-text log line.
+// We recognize RFC3339 prefixes (docker, journald exports), syslog and glog formats.
+func ParseTextTimestamp(line []byte) (uint64, bool) {
+	// RFC3339, as the first token on the line
+	if i := bytes.IndexByte(line, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, string(line[:i])); err == nil {
+			return uint64(t.UnixNano()), true
+		}
+	}
+
+	// logrus style: time="2017-01-02T15:04:05Z" level=info ...
+	if bytes.HasPrefix(line, []byte("time=\"")) {
+		s := line[len("time=\""):]
+		if i := bytes.IndexByte(s, '"'); i > 0 {
+			if t, err := time.Parse(time.RFC3339Nano, string(s[:i])); err == nil {
+				return uint64(t.UnixNano()), true
+			}
+		}
+	}
+
+	// syslog: Jan _2 15:04:05
+	if len(line) >= len(time.Stamp) {
+		if t, err := time.ParseInLocation(time.Stamp, string(line[:len(time.Stamp)]), time.Local); err == nil {
+			return uint64(withCurrentYear(t).UnixNano()), true
+		}
+	}
+
+	// glog: Lmmdd hh:mm:ss.uuuuuu
+	if len(line) >= 1+len(glogTimestampLayout) {
+		switch line[0] {
+		case 'I', 'W', 'E', 'F':
+			if t, err := time.ParseInLocation(glogTimestampLayout, string(line[1:1+len(glogTimestampLayout)]), time.Local); err == nil {
+				return uint64(withCurrentYear(t).UnixNano()), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// withCurrentYear fills in the year for formats that omit it, assuming the line is not from the future
+func withCurrentYear(t time.Time) time.Time {
+	now := time.Now()
+	t = t.AddDate(now.Year()-t.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
@@ -0,0 +1,75 @@
+package logsearch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTextTimestamp(t *testing.T) {
+	grid := []struct {
+		line     string
+		expected time.Time
+	}{
+		{line: "2017-01-02T03:04:05Z docker style line", expected: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{line: "2017-01-02T03:04:05.123456789+02:00 with nanoseconds and zone", expected: time.Date(2017, 1, 2, 1, 4, 5, 123456789, time.UTC)},
+		{line: `time="2017-01-02T03:04:05Z" level=info msg="logrus style"`, expected: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for _, g := range grid {
+		actual, ok := ParseTextTimestamp([]byte(g.line))
+		if !ok {
+			t.Errorf("ParseTextTimestamp(%q) found no timestamp", g.line)
+		} else if actual != uint64(g.expected.UnixNano()) {
+			t.Errorf("ParseTextTimestamp(%q) was %v, expected %v", g.line, time.Unix(0, int64(actual)).UTC(), g.expected)
+		}
+	}
+}
+
+func TestParseTextTimestampWithoutYear(t *testing.T) {
+	// syslog and glog omit the year, so we assume the most recent time that is not in the future
+	grid := []struct {
+		line  string
+		month time.Month
+		day   int
+		clock string
+	}{
+		{line: "Jan  2 03:04:05 node-1 kernel: syslog line", month: time.January, day: 2, clock: "03:04:05.000000"},
+		{line: "Dec 31 23:59:59 node-1 kubelet[123]: end of year", month: time.December, day: 31, clock: "23:59:59.000000"},
+		{line: "I0102 03:04:05.123456    1 server.go:10] glog info", month: time.January, day: 2, clock: "03:04:05.123456"},
+		{line: "E1231 23:59:59.000001    1 server.go:10] glog error", month: time.December, day: 31, clock: "23:59:59.000001"},
+	}
+	now := time.Now()
+	for _, g := range grid {
+		actual, ok := ParseTextTimestamp([]byte(g.line))
+		if !ok {
+			t.Errorf("ParseTextTimestamp(%q) found no timestamp", g.line)
+			continue
+		}
+		ts := time.Unix(0, int64(actual)).In(time.Local)
+		if ts.Month() != g.month || ts.Day() != g.day || ts.Format("15:04:05.000000") != g.clock {
+			t.Errorf("ParseTextTimestamp(%q) was %v, expected %s %d %s", g.line, ts, g.month, g.day, g.clock)
+		}
+		if ts.After(now.Add(24*time.Hour)) || ts.Year() < now.Year()-1 {
+			t.Errorf("ParseTextTimestamp(%q) was %v, expected within the last year", g.line, ts)
+		}
+	}
+}
+
+func TestParseTextTimestampRejected(t *testing.T) {
+	grid := []string{
+		"",
+		"no timestamp on this line",
+		"2017-01-02 03:04:05 space separated, not RFC3339",
+		"2017-13-02T03:04:05Z invalid month",
+		`time="yesterday" level=info`,
+		`time="2017-01-02T03:04:05Z`,
+		"Foo  2 03:04:05 not a month",
+		"X0102 03:04:05.123456 not a glog level",
+		"I0102 03:04 truncated glog line",
+		"Jan  2",
+	}
+	for _, line := range grid {
+		if actual, ok := ParseTextTimestamp([]byte(line)); ok {
+			t.Errorf("ParseTextTimestamp(%q) was %v, expected no timestamp", line, time.Unix(0, int64(actual)).UTC())
+		}
+	}
+}
@@ -6,21 +6,39 @@ import (
 	"github.com/golang/glog"
 	"io"
 	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
 	"kope.io/klogs/pkg/proto"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
 type ContainersDirectory struct {
 	containersDir string
 	state         *NodeState
+	// labels are globs of the container labels we expose as label.<name> fields
+	labels []string
 }
 
-func NewContainerLogsDirectory(containersDir string, state *NodeState) (*ContainersDirectory, error) {
+func init() {
+	RegisterSource("docker", func(options *Options, state *NodeState) (Source, error) {
+		return NewContainerLogsDirectory(options.ContainerDir, options.ContainerLabels, state)
+	})
+}
+
+func NewContainerLogsDirectory(containersDir string, labels []string, state *NodeState) (*ContainersDirectory, error) {
+	for _, glob := range labels {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", glob, err)
+		}
+	}
+
 	d := &ContainersDirectory{
 		containersDir: containersDir,
 		state:         state,
+		labels:        labels,
 	}
 	return d, nil
 }
@@ -51,21 +69,40 @@ func (d *ContainersDirectory) scanContainersDir(basepath string) error {
 		}
 	}
 
-	d.state.CleanupContainerLogs(names)
+	d.state.CleanupStreams("docker", names)
 
 	return nil
 }
 
 type DockerConfigV2 struct {
+	// Image is the ID of the image
+	Image  string
 	Config DockerConfigV2_Config
+	State  DockerConfigV2_State
 }
 
 type DockerConfigV2_Config struct {
+	// Image is the image reference the container was created from
 	Image  string
 	Labels map[string]string
 }
 
-func tryReadConfig(containerID string, containerDir string) *proto.Fields {
+type DockerConfigV2_State struct {
+	Running    bool
+	StartedAt  string
+	FinishedAt string
+}
+
+// dockerAnnotationPrefix is the label prefix dockershim uses to store CRI annotations on containers
+const dockerAnnotationPrefix = "annotation."
+
+// dockerSandboxLabel identifies the pod infrastructure container, which carries the pod annotations
+const dockerSandboxLabel = "io.kubernetes.docker.type"
+
+// labelFieldPrefix is the prefix of the fields for container labels
+const labelFieldPrefix = "label."
+
+func (d *ContainersDirectory) tryReadConfig(containerID string, containerDir string) (*DockerConfigV2, *proto.Fields) {
 	configPath := path.Join(containerDir, "config.v2.json")
 	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
@@ -75,14 +112,14 @@ func tryReadConfig(containerID string, containerDir string) *proto.Fields {
 		} else {
 			glog.Warningf("error reading file %q: %v", configPath, err)
 		}
-		return nil
+		return nil, nil
 	}
 
 	config := &DockerConfigV2{}
 	err = json.Unmarshal(data, config)
 	if err != nil {
 		glog.Warningf("error parsing file %q: %v", configPath, err)
-		return nil
+		return nil, nil
 	}
 
 	fields := &proto.Fields{}
@@ -90,8 +127,25 @@ func tryReadConfig(containerID string, containerDir string) *proto.Fields {
 		Key:   "container.id",
 		Value: containerID,
 	})
+	if config.Config.Image != "" {
+		fields.Fields = append(fields.Fields, &proto.Field{
+			Key:   "container.image",
+			Value: config.Config.Image,
+		})
+	}
+	if config.Image != "" {
+		fields.Fields = append(fields.Fields, &proto.Field{
+			Key:   "container.imageID",
+			Value: config.Image,
+		})
+	}
 
+	var labels []string
 	for k, v := range config.Config.Labels {
+		if d.exposeLabel(k) {
+			labels = append(labels, k)
+		}
+
 		switch k {
 		case "io.kubernetes.container.name":
 			fields.Fields = append(fields.Fields, &proto.Field{
@@ -113,9 +167,37 @@ func tryReadConfig(containerID string, containerDir string) *proto.Fields {
 				Key:   "pod.uid",
 				Value: v,
 			})
+		case "io.kubernetes.container.restartCount":
+			fields.Fields = append(fields.Fields, &proto.Field{
+				Key:   "container.restartCount",
+				Value: v,
+			})
 		}
 	}
 
+	// Sorted so the fields are stable across scans
+	sort.Strings(labels)
+	for _, k := range labels {
+		fields.Fields = append(fields.Fields, &proto.Field{
+			Key:   labelFieldPrefix + k,
+			Value: config.Config.Labels[k],
+		})
+	}
+
+	// docker uses the zero time for containers that have not started / finished
+	if t, err := time.Parse(time.RFC3339Nano, config.State.StartedAt); err == nil && !t.IsZero() {
+		fields.Fields = append(fields.Fields, &proto.Field{
+			Key:   "container.startedAt",
+			Value: t.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	if t, err := time.Parse(time.RFC3339Nano, config.State.FinishedAt); err == nil && !t.IsZero() {
+		fields.Fields = append(fields.Fields, &proto.Field{
+			Key:   "container.finishedAt",
+			Value: t.UTC().Format(time.RFC3339Nano),
+		})
+	}
+
 	//"io.kubernetes.container.hash": "8053578f",
 	//	"io.kubernetes.container.name": "kubedns",
 	//	"io.kubernetes.container.ports": "[{\"name\":\"dns-local\",\"containerPort\":10053,\"protocol\":\"UDP\"},{\"name\":\"dns-tcp-local\",\"containerPort\":10053,\"protocol\":\"TCP\"}]",
@@ -135,13 +217,67 @@ func tryReadConfig(containerID string, containerDir string) *proto.Fields {
 	//	"io.kubernetes.pod.terminationGracePeriod": "30",
 	//	"io.kubernetes.pod.uid": "86d89496-975a-11e6-b8af-06e5bea45582"
 
-	return fields
+	return config, fields
+}
+
+// exposeLabel returns true if the container label matches one of the label globs
+func (d *ContainersDirectory) exposeLabel(label string) bool {
+	for _, glob := range d.labels {
+		if match, _ := path.Match(glob, label); match {
+			return true
+		}
+	}
+	return false
+}
+
+// podAnnotations returns the pod annotations, if this is the pod sandbox container
+func (c *DockerConfigV2) podAnnotations() map[string]string {
+	if c.Config.Labels[dockerSandboxLabel] != "podsandbox" {
+		return nil
+	}
+
+	annotations := make(map[string]string)
+	for k, v := range c.Config.Labels {
+		if strings.HasPrefix(k, dockerAnnotationPrefix) {
+			annotations[strings.TrimPrefix(k, dockerAnnotationPrefix)] = v
+		}
+	}
+	return annotations
 }
 
 func (d *ContainersDirectory) scanContainerDirectory(containerDir string, containerID string) error {
-	containerState := d.state.GetContainerState(containerID)
+	containerState := d.state.GetStream("docker", containerID)
 
-	fields := tryReadConfig(containerID, containerDir)
+	config, fields := d.tryReadConfig(containerID, containerDir)
+	if config != nil {
+		if annotations := config.podAnnotations(); annotations != nil {
+			containerState.SetPodAnnotations(annotations)
+		}
+	}
+	containerState.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.ContainerId = containerID
+		if config == nil {
+			streamInfo.Status = proto.StreamStatus_UNKNOWN
+			return
+		}
+		if config.State.Running {
+			streamInfo.Status = proto.StreamStatus_RUNNING
+		} else {
+			streamInfo.Status = proto.StreamStatus_TERMINATED
+		}
+		for _, field := range fields.Fields {
+			switch field.Key {
+			case "container.name":
+				streamInfo.ContainerName = field.Value
+			case "pod.name":
+				streamInfo.PodName = field.Value
+			case "pod.namespace":
+				streamInfo.PodNamespace = field.Value
+			case "pod.uid":
+				streamInfo.PodUid = field.Value
+			}
+		}
+	})
 
 	glog.V(4).Infof("Found container: %q", containerID)
 
@@ -175,7 +311,7 @@ func (d *ContainersDirectory) scanContainerDirectory(containerDir string, contai
 			p := path.Join(containerDir, name)
 			glog.Infof("Found container log file %q", p)
 
-			fileMap[name] = struct{}{}
+			fileMap[p] = struct{}{}
 			stat, err := os.Lstat(p)
 			if err != nil {
 				if !os.IsNotExist(err) {
@@ -184,10 +320,25 @@ func (d *ContainersDirectory) scanContainerDirectory(containerDir string, contai
 				continue
 			}
 
-			containerState.foundFile(p, name, stat, fields)
+			containerState.foundFile(p, name, stat, fields, DockerJSONFormat)
+
+			// Once the container has terminated, nothing more will be written to the final segment
+			if config != nil && !config.State.Running {
+				containerState.archiveFile(p, name, archive.ContainerFileKey)
+			} else {
+				containerState.archiveIfIdle(p, name, stat, archive.ContainerFileKey)
+			}
 		}
+	}
+
+	// Forget (after a final archive) the files that docker has rotated away
+	containerState.retainFiles(fileMap)
 
-		glog.Warningf("TODO: Remove files not in fileMap")
+	// Copy the logs of terminated containers, before docker garbage-collects them
+	if config != nil && !config.State.Running && d.state.retention != nil {
+		if err := d.state.retention.Retain(containerState); err != nil {
+			glog.Warningf("error retaining logs for container %q: %v", containerID, err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,127 @@
+package logspoke
+
+import (
+	"io/ioutil"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readTestConfig reads the fields from a docker config.v2.json with the specified contents
+func readTestConfig(t *testing.T, d *ContainersDirectory, config string) *proto.Fields {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.v2.json"), []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	_, fields := d.tryReadConfig("abc123", dir)
+	if fields == nil {
+		t.Fatalf("no fields read from config %s", config)
+	}
+	return fields
+}
+
+func fieldMap(fields *proto.Fields) map[string]string {
+	m := make(map[string]string)
+	for _, f := range fields.Fields {
+		m[f.Key] = f.Value
+	}
+	return m
+}
+
+func TestReadConfigRestartHistory(t *testing.T) {
+	d := &ContainersDirectory{}
+	grid := []struct {
+		config   string
+		expected map[string]string
+	}{
+		{
+			config: `{"Config": {"Labels": {"io.kubernetes.container.restartCount": "2"}},
+				"State": {"StartedAt": "2017-01-02T03:04:05.123456789+02:00", "FinishedAt": "2017-01-02T05:00:00Z"}}`,
+			expected: map[string]string{
+				"container.id":           "abc123",
+				"container.restartCount": "2",
+				"container.startedAt":    "2017-01-02T01:04:05.123456789Z",
+				"container.finishedAt":   "2017-01-02T05:00:00Z",
+			},
+		},
+		{
+			// docker records the zero time for a running container
+			config: `{"Config": {"Labels": {"io.kubernetes.container.restartCount": "0"}},
+				"State": {"Running": true, "StartedAt": "2017-01-02T03:04:05Z", "FinishedAt": "0001-01-01T00:00:00Z"}}`,
+			expected: map[string]string{
+				"container.id":           "abc123",
+				"container.restartCount": "0",
+				"container.startedAt":    "2017-01-02T03:04:05Z",
+			},
+		},
+		{
+			// Containers not run by kubernetes have no restart count
+			config: `{"State": {"StartedAt": "not a time"}}`,
+			expected: map[string]string{
+				"container.id": "abc123",
+			},
+		},
+	}
+	for _, g := range grid {
+		actual := fieldMap(readTestConfig(t, d, g.config))
+		if !reflect.DeepEqual(actual, g.expected) {
+			t.Errorf("fields from %s were %v, expected %v", g.config, actual, g.expected)
+		}
+	}
+}
+
+func TestReadConfigImageAndLabels(t *testing.T) {
+	d, err := NewContainerLogsDirectory("/var/lib/docker/containers", []string{"app", "team.example.com/*"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := `{"Image": "sha256:0123abcd", "Config": {"Image": "nginx:1.11", "Labels": {
+		"app": "web",
+		"team.example.com/owner": "storefront",
+		"team.example.com/cost-center": "42",
+		"secret-token": "not exposed",
+		"io.kubernetes.pod.name": "web-0"}}}`
+	actual := fieldMap(readTestConfig(t, d, config))
+	expected := map[string]string{
+		"container.id":                       "abc123",
+		"container.image":                    "nginx:1.11",
+		"container.imageID":                  "sha256:0123abcd",
+		"pod.name":                           "web-0",
+		"label.app":                          "web",
+		"label.team.example.com/cost-center": "42",
+		"label.team.example.com/owner":       "storefront",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("fields were %v, expected %v", actual, expected)
+	}
+
+	// The label fields are in a stable order across scans
+	for i := 0; i < 10; i++ {
+		var labels []string
+		for _, f := range readTestConfig(t, d, config).Fields {
+			if strings.HasPrefix(f.Key, labelFieldPrefix) {
+				labels = append(labels, f.Key)
+			}
+		}
+		expectedLabels := []string{"label.app", "label.team.example.com/cost-center", "label.team.example.com/owner"}
+		if !reflect.DeepEqual(labels, expectedLabels) {
+			t.Fatalf("label fields were %v, expected %v", labels, expectedLabels)
+		}
+	}
+}
+
+func TestNewContainerLogsDirectoryInvalidGlob(t *testing.T) {
+	_, err := NewContainerLogsDirectory("/var/lib/docker/containers", []string{"app", "team["}, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), `invalid glob "team["`) {
+		t.Errorf("error was %v, expected invalid glob", err)
+	}
+}
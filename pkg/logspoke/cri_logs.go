@@ -0,0 +1,178 @@
+package logspoke
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"kope.io/klogs/pkg/logsearch"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CRILogsDirectory collects the container logs written by CRI runtimes (containerd, cri-o),
+// in the kubelet layout /var/log/pods/<namespace>_<name>_<uid>/<container>/<restart>.log
+type CRILogsDirectory struct {
+	basedir string
+	state   *NodeState
+}
+
+// CRIFormat reads the CRI logging format: <timestamp> <stream> <P|F> <message>
+var CRIFormat LogFormat = &lineFormat{parse: parseCRILine}
+
+func init() {
+	RegisterSource("cri", func(options *Options, state *NodeState) (Source, error) {
+		return NewCRILogsDirectory(options.CRILogDir, state)
+	})
+}
+
+func NewCRILogsDirectory(basedir string, state *NodeState) (*CRILogsDirectory, error) {
+	d := &CRILogsDirectory{
+		basedir: basedir,
+		state:   state,
+	}
+	return d, nil
+}
+
+func (d *CRILogsDirectory) Scan() error {
+	podDirs, err := ioutil.ReadDir(d.basedir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.V(4).Infof("No CRI logs directory %q", d.basedir)
+			return nil
+		}
+		return fmt.Errorf("error reading directory %q: %v", d.basedir, err)
+	}
+
+	var ids []string
+	for _, podDir := range podDirs {
+		if !podDir.IsDir() {
+			continue
+		}
+
+		namespace, name, uid := parseCRIPodDirectory(podDir.Name())
+
+		containerDirs, err := ioutil.ReadDir(filepath.Join(d.basedir, podDir.Name()))
+		if err != nil {
+			glog.Warningf("error reading directory %q: %v", podDir.Name(), err)
+			continue
+		}
+
+		for _, containerDir := range containerDirs {
+			if !containerDir.IsDir() {
+				continue
+			}
+
+			id := podDir.Name() + "/" + containerDir.Name()
+			ids = append(ids, id)
+
+			stream := d.state.GetStream("cri", id)
+			stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+				streamInfo.PodNamespace = namespace
+				streamInfo.PodName = name
+				streamInfo.PodUid = uid
+				streamInfo.ContainerName = containerDir.Name()
+			})
+
+			if err := d.scanContainerDirectory(id, stream, namespace, name, uid, containerDir.Name()); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.state.CleanupStreams("cri", ids)
+
+	return nil
+}
+
+// parseCRIPodDirectory extracts the pod identity from the directory name; older kubelets named it just <uid>
+func parseCRIPodDirectory(dirName string) (string, string, string) {
+	tokens := strings.Split(dirName, "_")
+	if len(tokens) != 3 {
+		return "", "", dirName
+	}
+	return tokens[0], tokens[1], tokens[2]
+}
+
+func (d *CRILogsDirectory) scanContainerDirectory(id string, stream *StreamState, namespace, name, uid, containerName string) error {
+	containerDir := filepath.Join(d.basedir, id)
+	files, err := ioutil.ReadDir(containerDir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %q: %v", containerDir, err)
+	}
+
+	fields := &proto.Fields{}
+	addField := func(k, v string) {
+		if v != "" {
+			fields.Fields = append(fields.Fields, &proto.Field{Key: k, Value: v})
+		}
+	}
+	addField("pod.namespace", namespace)
+	addField("pod.name", name)
+	addField("pod.uid", uid)
+	addField("container.name", containerName)
+
+	fileMap := make(map[string]struct{})
+	for _, stat := range files {
+		// With dockershim these are symlinks to the docker json logs, which the docker source collects
+		if !stat.Mode().IsRegular() {
+			continue
+		}
+		if !strings.Contains(stat.Name(), ".log") {
+			continue
+		}
+
+		// Files are named <restartCount>.log, with a suffix when rotated
+		fileFields := fields
+		if restartCount := strings.SplitN(stat.Name(), ".", 2)[0]; restartCount != "" {
+			if _, err := strconv.Atoi(restartCount); err == nil {
+				fileFields = &proto.Fields{}
+				fileFields.Fields = append(fileFields.Fields, fields.Fields...)
+				fileFields.Fields = append(fileFields.Fields, &proto.Field{Key: "container.restartCount", Value: restartCount})
+			}
+		}
+
+		p := filepath.Join(containerDir, stat.Name())
+		glog.V(4).Infof("Found CRI log file %q", p)
+		fileMap[p] = struct{}{}
+		stream.foundFile(p, filepath.Join(id, stat.Name()), stat, fileFields, CRIFormat)
+	}
+
+	stream.retainFiles(fileMap)
+
+	return nil
+}
+
+func parseCRILine(line []byte, item *proto.SearchResult) int {
+	tokens := bytes.SplitN(line, []byte(" "), 4)
+	if len(tokens) != 4 {
+		return logsearch.ParseTextLine(line, item)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, string(tokens[0]))
+	if err != nil {
+		return logsearch.ParseTextLine(line, item)
+	}
+	item.Timestamp = uint64(t.UnixNano())
+
+	// TODO: Join partial (P) lines
+	stream := string(tokens[1])
+	message := string(tokens[3])
+	item.Fields = &proto.Fields{
+		Fields: []*proto.Field{
+			{
+				Key:   "log",
+				Value: message,
+			},
+			{
+				Key:   "stream",
+				Value: stream,
+			},
+		},
+	}
+	return 10 + 16 + len(message) + len(stream)
+}
@@ -0,0 +1,118 @@
+package logspoke
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HostLogSource is a named set of log files on the node, e.g. kubelet=kubelet.log*
+type HostLogSource struct {
+	Name string
+	Glob string
+}
+
+type HostLogsDirectory struct {
+	basedir string
+	sources []*HostLogSource
+	state   *NodeState
+}
+
+func init() {
+	RegisterSource("host", func(options *Options, state *NodeState) (Source, error) {
+		return NewHostLogsDirectory(options.HostLogDir, options.HostLogs, state)
+	})
+}
+
+// ParseHostLogSource parses a host log source in the form name=glob
+func ParseHostLogSource(s string) (*HostLogSource, error) {
+	tokens := strings.SplitN(s, "=", 2)
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return nil, fmt.Errorf("invalid host log source %q, expected name=glob", s)
+	}
+	source := &HostLogSource{
+		Name: strings.TrimSpace(tokens[0]),
+		Glob: strings.TrimSpace(tokens[1]),
+	}
+	if _, err := filepath.Match(source.Glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob in host log source %q: %v", s, err)
+	}
+	return source, nil
+}
+
+func NewHostLogsDirectory(basedir string, specs []string, state *NodeState) (*HostLogsDirectory, error) {
+	d := &HostLogsDirectory{
+		basedir: basedir,
+		state:   state,
+	}
+	for _, spec := range specs {
+		source, err := ParseHostLogSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		d.sources = append(d.sources, source)
+	}
+	return d, nil
+}
+
+func (d *HostLogsDirectory) Scan() error {
+	for _, source := range d.sources {
+		if err := d.scanSource(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *HostLogsDirectory) scanSource(source *HostLogSource) error {
+	pattern := filepath.Join(d.basedir, source.Glob)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("error expanding %q: %v", pattern, err)
+	}
+
+	stream := d.state.GetStream("host", source.Name)
+	stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.Source = source.Name
+	})
+
+	fields := &proto.Fields{}
+	fields.Fields = append(fields.Fields, &proto.Field{
+		Key:   "host",
+		Value: d.state.host,
+	})
+	fields.Fields = append(fields.Fields, &proto.Field{
+		Key:   "source",
+		Value: source.Name,
+	})
+
+	fileMap := make(map[string]struct{})
+	for _, p := range matches {
+		stat, err := os.Lstat(p)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Warningf("error doing lstat on file %q: %v", p, err)
+			}
+			continue
+		}
+		if !stat.Mode().IsRegular() {
+			continue
+		}
+
+		relativePath, err := filepath.Rel(d.basedir, p)
+		if err != nil {
+			relativePath = filepath.Base(p)
+		}
+
+		glog.V(4).Infof("Found host log file %q", p)
+		fileMap[p] = struct{}{}
+		stream.foundFile(p, relativePath, stat, fields, TextFormat)
+	}
+
+	stream.retainFiles(fileMap)
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package logspoke
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"kope.io/klogs/pkg/journal"
+	"kope.io/klogs/pkg/logsearch"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+)
+
+// JournalSourceName is the name of the host source for systemd journal entries
+const JournalSourceName = "journal"
+
+// JournalDirectory collects the on-disk files of the systemd journal, typically /var/log/journal/<machine-id>/
+type JournalDirectory struct {
+	basedir string
+	state   *NodeState
+}
+
+func init() {
+	RegisterSource(JournalSourceName, func(options *Options, state *NodeState) (Source, error) {
+		return NewJournalDirectory(options.JournalDir, state)
+	})
+}
+
+func NewJournalDirectory(basedir string, state *NodeState) (*JournalDirectory, error) {
+	d := &JournalDirectory{
+		basedir: basedir,
+		state:   state,
+	}
+	return d, nil
+}
+
+func (d *JournalDirectory) Scan() error {
+	if d.basedir == "" {
+		return nil
+	}
+
+	_, err := os.Lstat(d.basedir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.V(4).Infof("No journal directory %q", d.basedir)
+			return nil
+		}
+		return fmt.Errorf("error doing stat on %q: %v", d.basedir, err)
+	}
+
+	stream := d.state.GetStream(JournalSourceName, JournalSourceName)
+	stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.Source = JournalSourceName
+	})
+
+	fields := &proto.Fields{}
+	fields.Fields = append(fields.Fields, &proto.Field{
+		Key:   "host",
+		Value: d.state.host,
+	})
+	fields.Fields = append(fields.Fields, &proto.Field{
+		Key:   "source",
+		Value: JournalSourceName,
+	})
+
+	fileMap := make(map[string]struct{})
+	err = filepath.Walk(d.basedir, func(p string, stat os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !stat.Mode().IsRegular() || !journal.IsJournalFile(p) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(d.basedir, p)
+		if err != nil {
+			relativePath = filepath.Base(p)
+		}
+
+		glog.V(4).Infof("Found journal file %q", p)
+		fileMap[p] = struct{}{}
+		stream.foundFile(p, relativePath, stat, fields, JournalFormat)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error scanning journal directory %q: %v", d.basedir, err)
+	}
+
+	stream.retainFiles(fileMap)
+
+	return nil
+}
+
+// journalFormat is the LogFormat for systemd journal files
+type journalFormat struct{}
+
+// JournalFormat reads the binary files written by systemd-journald
+var JournalFormat LogFormat = &journalFormat{}
+
+// Timestamps returns the min & max timestamps from the journal file header, without reading the entries
+func (f *journalFormat) Timestamps(sourcePath string) (uint64, uint64, error) {
+	j, err := journal.Open(sourcePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer j.Close()
+
+	// journal timestamps are in microseconds
+	return j.HeadRealtime() * 1000, j.TailRealtime() * 1000, nil
+}
+
+// journalFields are the journal fields we expose on each search result
+var journalFields = []string{"_SYSTEMD_UNIT", "PRIORITY", "_PID"}
+
+func (f *journalFormat) Search(s *fileScanOperation, search *logsearch.Search) error {
+	j, err := journal.Open(s.SourcePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.V(2).Infof("ignoring journal file that no longer exists %q", s.SourcePath)
+			return nil
+		}
+		glog.Warningf("ignoring error opening journal file %q: %v", s.SourcePath, err)
+		return nil
+	}
+	defer j.Close()
+
+	w := logsearch.NewResultWriter(search.Out, s.Fields)
+
+	var sendErr error
+	err = j.Entries(func(e *journal.Entry) error {
+		message := e.Fields["MESSAGE"]
+		if !search.Matches(message) {
+			return nil
+		}
+
+		item := &proto.SearchResult{
+			Raw:       message,
+			Timestamp: e.Realtime * 1000,
+			Fields:    &proto.Fields{},
+		}
+		itemSize := 18 + len(message)
+
+		item.Fields.Fields = append(item.Fields.Fields, &proto.Field{
+			Key:   "log",
+			Value: string(message),
+		})
+		itemSize += 8 + len(message)
+
+		for _, k := range journalFields {
+			v, found := e.Fields[k]
+			if !found {
+				continue
+			}
+			item.Fields.Fields = append(item.Fields.Fields, &proto.Field{
+				Key:   k,
+				Value: string(v),
+			})
+			itemSize += 8 + len(k) + len(v)
+		}
+
+		if !s.Matches(item) {
+			return nil
+		}
+
+		sendErr = w.Add(item, itemSize)
+		return sendErr
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		glog.Warningf("error reading journal file %q: %v", s.SourcePath, err)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,101 @@
+package logspoke
+
+import (
+	"kope.io/klogs/pkg/logsearch"
+	"kope.io/klogs/pkg/proto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const journalFixture = "../journal/testdata/compact/system.journal"
+
+// fakeSearchServer collects the results sent by a search
+type fakeSearchServer struct {
+	proto.LogServer_SearchServer
+	chunks []*proto.SearchResultChunk
+}
+
+func (f *fakeSearchServer) Send(chunk *proto.SearchResultChunk) error {
+	f.chunks = append(f.chunks, chunk)
+	return nil
+}
+
+func (f *fakeSearchServer) results() []*proto.SearchResult {
+	var results []*proto.SearchResult
+	for _, chunk := range f.chunks {
+		results = append(results, chunk.Items...)
+	}
+	return results
+}
+
+func TestJournalTimestamps(t *testing.T) {
+	minTimestamp, maxTimestamp, err := JournalFormat.Timestamps(journalFixture)
+	if err != nil {
+		t.Fatalf("error reading timestamps: %v", err)
+	}
+
+	results := searchJournal(t, &proto.SearchRequest{}, nil)
+	if len(results) == 0 {
+		t.Fatalf("no results")
+	}
+	if minTimestamp != results[0].Timestamp || maxTimestamp != results[len(results)-1].Timestamp {
+		t.Errorf("timestamps were %d-%d, expected %d-%d", minTimestamp, maxTimestamp, results[0].Timestamp, results[len(results)-1].Timestamp)
+	}
+}
+
+func searchJournal(t *testing.T, request *proto.SearchRequest, unmatched []*proto.FieldFilter) []*proto.SearchResult {
+	out := &fakeSearchServer{}
+	op := &fileScanOperation{
+		Scan: logsearch.Scan{
+			SourcePath: journalFixture,
+			Fields:     &proto.Fields{},
+			Unmatched:  unmatched,
+		},
+		format: JournalFormat,
+	}
+	if err := JournalFormat.Search(op, logsearch.NewSearch(request, out)); err != nil {
+		t.Fatalf("error searching journal: %v", err)
+	}
+	return out.results()
+}
+
+func TestJournalSearch(t *testing.T) {
+	all := searchJournal(t, &proto.SearchRequest{}, nil)
+	if len(all) != 6 {
+		t.Fatalf("expected 6 results, got %d", len(all))
+	}
+	// Entries after the timestamp of the fourth entry ("kubelet started")
+	after := time.Unix(0, int64(all[3].Timestamp)).Format(time.RFC3339Nano)
+
+	grid := []struct {
+		contains  string
+		unmatched []*proto.FieldFilter
+		expected  []string
+	}{
+		{contains: "active", expected: []string{"first active message", "second active message"}},
+		{contains: "no such message", expected: nil},
+		{
+			unmatched: []*proto.FieldFilter{{Key: "@timestamp", Op: proto.FieldFilterOperator_GTE, Value: after}},
+			expected:  []string{"kubelet started", "second active message", "Journal stopped"},
+		},
+	}
+	for _, g := range grid {
+		var actual []string
+		for _, result := range searchJournal(t, &proto.SearchRequest{Contains: g.contains}, g.unmatched) {
+			actual = append(actual, string(result.Raw))
+		}
+		if !reflect.DeepEqual(actual, g.expected) {
+			t.Errorf("search %q %v returned %q, expected %q", g.contains, g.unmatched, actual, g.expected)
+		}
+	}
+
+	// The entry fields we expose
+	fields := make(map[string]string)
+	for _, f := range all[2].Fields.Fields {
+		fields[f.Key] = f.Value
+	}
+	if fields["log"] != "key=value message" || fields["PRIORITY"] != "3" || fields["_PID"] == "" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
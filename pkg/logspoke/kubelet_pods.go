@@ -0,0 +1,141 @@
+package logspoke
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kubeletPodsTimeout bounds each request to the kubelet
+var kubeletPodsTimeout = 10 * time.Second
+
+// KubeletPods reads the specs of the pods on the node from the kubelet API (GET /pods).  Unlike the container
+// sources, this works with every container runtime, and includes the volumes that are not materialized in the
+// kubelet pod directory, such as hostPath volumes.
+type KubeletPods struct {
+	url       string
+	tokenFile string
+	client    *http.Client
+
+	mutex sync.Mutex
+	pods  map[string]*kubeletPod
+}
+
+// kubeletPod is the subset of the v1.Pod we use
+type kubeletPod struct {
+	Metadata struct {
+		UID         string            `json:"uid"`
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Volumes []struct {
+			Name     string `json:"name"`
+			HostPath *struct {
+				Path string `json:"path"`
+			} `json:"hostPath"`
+		} `json:"volumes"`
+	} `json:"spec"`
+}
+
+type kubeletPodList struct {
+	Items []*kubeletPod `json:"items"`
+}
+
+// NewKubeletPods builds a KubeletPods for the kubelet at url, e.g. https://10.0.0.1:10250.  The bearer token is read
+// from tokenFile (if set) on each request, so rotated service account tokens are picked up.  The kubelet serving
+// certificate is verified against caFile, unless insecure is set.
+func NewKubeletPods(url string, tokenFile string, caFile string, insecure bool) (*KubeletPods, error) {
+	tlsConfig := &tls.Config{}
+	if insecure {
+		tlsConfig.InsecureSkipVerify = true
+	} else if caFile != "" {
+		pemData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %q: %v", caFile, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("unable to parse ca certificate file %q", caFile)
+		}
+	}
+
+	return &KubeletPods{
+		url:       strings.TrimSuffix(url, "/"),
+		tokenFile: tokenFile,
+		client: &http.Client{
+			Timeout:   kubeletPodsTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Refresh fetches the pods from the kubelet; on error we keep the pods from the last successful refresh
+func (k *KubeletPods) Refresh() error {
+	request, err := http.NewRequest("GET", k.url+"/pods", nil)
+	if err != nil {
+		return fmt.Errorf("error building kubelet request: %v", err)
+	}
+	if k.tokenFile != "" {
+		token, err := ioutil.ReadFile(k.tokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading token file %q: %v", k.tokenFile, err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	response, err := k.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("error querying kubelet pods: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("error reading kubelet pods: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status querying kubelet pods: %s", response.Status)
+	}
+
+	podList := &kubeletPodList{}
+	if err := json.Unmarshal(body, podList); err != nil {
+		return fmt.Errorf("error parsing kubelet pods: %v", err)
+	}
+
+	pods := make(map[string]*kubeletPod)
+	for _, pod := range podList.Items {
+		pods[pod.Metadata.UID] = pod
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.pods = pods
+	return nil
+}
+
+// find returns the pod with the uid, or nil if the kubelet did not report it
+func (k *KubeletPods) find(uid string) *kubeletPod {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.pods[uid]
+}
+
+// hostPaths returns the paths of the pod's hostPath volumes, by volume name
+func (p *kubeletPod) hostPaths() map[string]string {
+	hostPaths := make(map[string]string)
+	for _, volume := range p.Spec.Volumes {
+		if volume.HostPath != nil && volume.HostPath.Path != "" {
+			hostPaths[volume.Name] = volume.HostPath.Path
+		}
+	}
+	return hostPaths
+}
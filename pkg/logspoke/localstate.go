@@ -1,228 +1,274 @@
 package logspoke
 
 import (
-	"bufio"
-	"bytes"
-	"compress/gzip"
-	"encoding/json"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"github.com/golang/glog"
-	"io"
-	"k8s.io/client-go/pkg/api/v1"
+	"golang.org/x/net/context"
 	"kope.io/klogs/pkg/archive"
+	"kope.io/klogs/pkg/logsearch"
 	"kope.io/klogs/pkg/proto"
-	"math"
 	"os"
-	"strings"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const LineBufferSize = 1024 * 1024
-
-const chunkFlushSize = 64 * 1024
-
 var idlePeriod = time.Minute * 15
 
+// maxHeldFiles is the most archivable files we hold open; beyond it we hold files with hard links in the hold
+// directory, so we do not run out of file descriptors on nodes with many log files
+var maxHeldFiles = 256
+
+// NodeState is the catalog of the log streams on the node; it is populated by the sources
 type NodeState struct {
+	host        string
 	nodeFields  *proto.Fields
 	archiveSink archive.Sink
-
-	mutex      sync.Mutex
-	pods       map[string]*PodState
-	containers map[string]*ContainerState
-}
-
-type PodState struct {
-	nodeState *NodeState
-	uid       string
-
-	mutex      sync.Mutex
-	streamInfo proto.StreamInfo
-	podObject  *v1.Pod
-	logs       *LogsState
+	// retention keeps copies of the logs of terminated containers, if enabled
+	retention *RetentionStore
+	// holdDir is where we hard link archivable files once we hold maxHeldFiles open; empty if not configured
+	holdDir string
+	// heldFiles is the number of files we hold open
+	heldFiles int32
+
+	mutex   sync.Mutex
+	streams map[string]*StreamState
+
+	// watchMutex guards the watchers, and the stream info we last sent them
+	watchMutex sync.Mutex
+	watchers   map[*streamWatcher]struct{}
+	published  map[string]*proto.StreamInfo
 }
 
-type ContainerState struct {
+// StreamState is a single stream in the catalog: a container, the log volumes of a pod, a host log etc
+type StreamState struct {
 	nodeState *NodeState
+	source    string
 	id        string
 
 	mutex      sync.Mutex
 	streamInfo proto.StreamInfo
 	logs       *LogsState
-	labels     map[string]string
+	// archiveKey is how the source archives files of the stream; nil if it does not archive them
+	archiveKey archiveKeyFunc
+	// podAnnotations are the annotations of the pod, if the source knows them
+	podAnnotations map[string]string
 }
 
 type LogsState struct {
 	mutex    sync.Mutex
 	logs     map[string]*LogFile
 	archived map[string]*LogFile
+	// held are the archivable files we hold, by source path, so we can still archive them once deleted
+	held map[string]*heldFile
 }
 
-type LogFile struct {
-	model proto.LogFile
+// heldFile keeps a file reachable after it is deleted: through an open handle, or else a hard link in the hold
+// directory
+type heldFile struct {
+	handle *os.File
+	link   string
 }
 
-func (l *LogFile) canMatch(request *proto.SearchRequest) (bool, []*proto.FieldFilter) {
-	if len(request.FieldFilters) == 0 {
-		return true, nil
+func (h *heldFile) stat() (os.FileInfo, error) {
+	if h.handle != nil {
+		return h.handle.Stat()
 	}
+	return os.Stat(h.link)
+}
 
-	unmatched := make([]*proto.FieldFilter, 0, len(request.FieldFilters))
-
-	for _, filter := range request.FieldFilters {
-		mismatch := false
-		processed := false
+type LogFile struct {
+	model  proto.LogFile
+	format LogFormat
+}
 
-		// TODO: Well known fields
-		if filter.Key == "@timestamp" {
-			// TODO: Non-string values
-			t, err := time.Parse(time.RFC3339Nano, filter.Value)
-			if err != nil {
-				glog.Warningf("ignoring error parsing @timestamp value %q", filter.Value)
-				continue
-			}
+func newNodeState(host string, archiveSink archive.Sink) *NodeState {
+	s := &NodeState{
+		host:        host,
+		archiveSink: archiveSink,
+		streams:     make(map[string]*StreamState),
+		watchers:    make(map[*streamWatcher]struct{}),
+		published:   make(map[string]*proto.StreamInfo),
+	}
+	return s
+}
 
-			switch filter.Op {
-			case proto.FieldFilterOperator_GTE:
-				if l.model.MaxTimestamp != 0 && l.model.MaxTimestamp < uint64(t.UnixNano()) {
-					mismatch = true
-				}
+// setHoldDir sets the directory for the hard links of held files, removing any left by a previous run.  It should be
+// on the same filesystem as the logs.
+func (s *NodeState) setHoldDir(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error removing %q: %v", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating %q: %v", dir, err)
+	}
+	s.holdDir = dir
+	return nil
+}
 
-			default:
-				glog.Warningf("Unhandled operator: %v", filter)
-			}
-		} else {
-			for _, actual := range l.model.Fields.Fields {
-				if filter.Key == actual.Key {
-					processed = true
-
-					switch filter.Op {
-					case proto.FieldFilterOperator_NOT_EQ:
-						if actual.Value == filter.Value {
-							mismatch = true
-						}
-					case proto.FieldFilterOperator_EQ:
-						if actual.Value != filter.Value {
-							mismatch = true
-						}
-
-					default:
-						glog.Warningf("Unhandled operator: %v", filter)
-					}
-
-					break
-				}
-			}
-		}
-		if mismatch {
-			return false, nil
-		}
-		if !processed {
-			unmatched = append(unmatched, filter)
+// hold keeps the file reachable, with an open handle while we hold fewer than maxHeldFiles, else with a hard link
+func (s *NodeState) hold(sourcePath string) (*heldFile, error) {
+	if atomic.AddInt32(&s.heldFiles, 1) <= int32(maxHeldFiles) {
+		f, err := os.Open(sourcePath)
+		if err != nil {
+			atomic.AddInt32(&s.heldFiles, -1)
+			return nil, err
 		}
+		return &heldFile{handle: f}, nil
 	}
+	atomic.AddInt32(&s.heldFiles, -1)
 
-	return true, unmatched
+	if s.holdDir == "" {
+		return nil, fmt.Errorf("already holding %d files open", maxHeldFiles)
+	}
+	hash := sha1.Sum([]byte(sourcePath))
+	link := filepath.Join(s.holdDir, hex.EncodeToString(hash[:]))
+	// Sinks recognize files that are already compressed by the suffix
+	if filepath.Ext(sourcePath) == ".gz" {
+		link += ".gz"
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.Link(sourcePath, link); err != nil {
+		return nil, err
+	}
+	return &heldFile{link: link}, nil
 }
 
-func newNodeState(archiveSink archive.Sink) *NodeState {
-	s := &NodeState{
-		archiveSink: archiveSink,
-		pods:        make(map[string]*PodState),
-		containers:  make(map[string]*ContainerState),
+// release closes or removes the link of a held file
+func (s *NodeState) release(h *heldFile) {
+	if h.handle != nil {
+		h.handle.Close()
+		atomic.AddInt32(&s.heldFiles, -1)
+	}
+	if h.link != "" {
+		if err := os.Remove(h.link); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("error removing %q: %v", h.link, err)
+		}
 	}
-	return s
 }
 
-func (s *NodeState) CleanupPodLogs(ids []string) {
-	idMap := make(map[string]struct{}, len(ids))
-	for _, k := range ids {
-		idMap[k] = struct{}{}
-	}
+// GetStream returns the catalog entry for the stream id of source, creating it if needed
+func (s *NodeState) GetStream(source string, id string) *StreamState {
+	key := source + "/" + id
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	for _, p := range s.pods {
-		func() {
-			p.mutex.Lock()
-			defer p.mutex.Unlock()
-
-			_, found := idMap[p.uid]
-			if !found {
-				glog.V(2).Infof("Removing pod logs state: %q", p.uid)
-				p.logs = nil
-				glog.Warningf("TODO: Remove pods when no state left")
-			}
-		}()
+	stream := s.streams[key]
+	if stream == nil {
+		stream = &StreamState{
+			nodeState: s,
+			source:    source,
+			id:        id,
+		}
+		stream.streamInfo.Host = s.host
+		s.streams[key] = stream
 	}
+	return stream
 }
 
-func (s *NodeState) CleanupContainerLogs(ids []string) {
+// CleanupStreams removes the streams of source that are not in ids, archiving any data not yet archived
+func (s *NodeState) CleanupStreams(source string, ids []string) {
 	idMap := make(map[string]struct{}, len(ids))
 	for _, k := range ids {
 		idMap[k] = struct{}{}
 	}
 
+	var removed []*StreamState
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	for _, p := range s.containers {
-		func() {
-			p.mutex.Lock()
-			defer p.mutex.Unlock()
+	for k, stream := range s.streams {
+		if stream.source != source {
+			continue
+		}
+		if _, found := idMap[stream.id]; !found {
+			glog.V(2).Infof("Removing %s logs state: %q", source, stream.id)
+			delete(s.streams, k)
+			removed = append(removed, stream)
+		}
+	}
+	s.mutex.Unlock()
 
-			_, found := idMap[p.id]
-			if !found {
-				glog.V(2).Infof("Removing container logs state: %q", p.id)
-				p.logs = nil
-				glog.Warningf("TODO: Remove containers when no state left")
-			}
-		}()
+	for _, stream := range removed {
+		stream.retainFiles(nil)
 	}
 }
 
-func (s *NodeState) GetPodState(uid string) *PodState {
+// hasStream returns true if the stream is in the catalog
+func (s *NodeState) hasStream(source string, id string) bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	pod := s.pods[uid]
-	if pod == nil {
-		pod = &PodState{
-			nodeState: s,
-			uid:       uid,
-		}
-		pod.streamInfo.PodUid = uid
-		s.pods[uid] = pod
-	}
-	return pod
+	return s.streams[source+"/"+id] != nil
 }
 
-func (s *NodeState) GetContainerState(containerid string) *ContainerState {
+// snapshotStreams returns the current streams, so callers need not hold the node lock
+func (s *NodeState) snapshotStreams() []*StreamState {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	container := s.containers[containerid]
-	if container == nil {
-		container = &ContainerState{
-			nodeState: s,
-			id:        containerid,
+	streams := make([]*StreamState, 0, len(s.streams))
+	for _, stream := range s.streams {
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// podInfo is what the container sources know about a pod
+type podInfo struct {
+	name        string
+	namespace   string
+	annotations map[string]string
+}
+
+// findPod gathers the metadata recorded by the container streams belonging to the pod
+func (s *NodeState) findPod(podUID string) *podInfo {
+	var info *podInfo
+	for _, stream := range s.snapshotStreams() {
+		stream.mutex.Lock()
+		streamInfo := stream.streamInfo
+		annotations := stream.podAnnotations
+		stream.mutex.Unlock()
+
+		if streamInfo.PodUid != podUID || stream.source == "pods" {
+			continue
+		}
+		if info == nil {
+			info = &podInfo{}
+		}
+		if streamInfo.PodName != "" {
+			info.name = streamInfo.PodName
+			info.namespace = streamInfo.PodNamespace
+		}
+		if annotations != nil {
+			info.annotations = annotations
 		}
-		s.containers[containerid] = container
 	}
-	return container
+	return info
+}
+
+// SetPodAnnotations records the annotations of the pod the stream belongs to
+func (p *StreamState) SetPodAnnotations(annotations map[string]string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.podAnnotations = annotations
 }
 
 func newLogsState() *LogsState {
 	l := &LogsState{
 		logs:     make(map[string]*LogFile),
 		archived: make(map[string]*LogFile),
+		held:     make(map[string]*heldFile),
 	}
 	return l
 }
 
-func (l *LogsState) foundFile(sourcePath string, relativePath string, stat os.FileInfo, fields *proto.Fields) error {
+func (l *LogsState) foundFile(sourcePath string, relativePath string, stat os.FileInfo, fields *proto.Fields, format LogFormat) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
@@ -235,6 +281,7 @@ func (l *LogsState) foundFile(sourcePath string, relativePath string, stat os.Fi
 			modified = false
 			glog.V(4).Infof("File not modified: %q", sourcePath)
 		}
+		logFile.model.Fields = fields
 	} else {
 		logFile = &LogFile{
 			model: proto.LogFile{
@@ -243,16 +290,26 @@ func (l *LogsState) foundFile(sourcePath string, relativePath string, stat os.Fi
 				Size:         stat.Size(),
 				Fields:       fields,
 			},
+			format: format,
 		}
 		l.logs[sourcePath] = logFile
 	}
 
 	if modified {
-		_, maxTimestamp, err := findMaxTimestamp(sourcePath)
+		logFile.model.LastModified = modTime.Unix()
+		logFile.model.Size = stat.Size()
+
+		minTimestamp, maxTimestamp, err := format.Timestamps(sourcePath)
 		if err != nil {
 			glog.Warningf("error finding max timestamp for %q: %v", sourcePath, err)
+			logFile.model.MinTimestamp = 0
 			logFile.model.MaxTimestamp = 0
 		} else {
+			if minTimestamp > maxTimestamp {
+				// No timestamped entries
+				minTimestamp = 0
+			}
+			logFile.model.MinTimestamp = uint64(minTimestamp)
 			logFile.model.MaxTimestamp = uint64(maxTimestamp)
 		}
 	}
@@ -260,18 +317,47 @@ func (l *LogsState) foundFile(sourcePath string, relativePath string, stat os.Fi
 	return nil
 }
 
-func (p *ContainerState) foundFile(sourcePath string, relativePath string, stat os.FileInfo, fields *proto.Fields) error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+// removedFile is a file we have stopped tracking, which we may still need to archive
+type removedFile struct {
+	sourcePath string
+	model      proto.LogFile
+	held       *heldFile
+	// archived is what we last archived of the file, if anything
+	archived *LogFile
+}
 
-	if p.logs == nil {
-		p.logs = newLogsState()
+// retainFiles forgets about any files that are not in keep (e.g. rotated away), returning the removed files
+func (l *LogsState) retainFiles(keep map[string]struct{}) []*removedFile {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var removed []*removedFile
+	for k, logFile := range l.logs {
+		if _, found := keep[k]; !found {
+			glog.V(2).Infof("Removing log file state: %q", k)
+			delete(l.logs, k)
+
+			removed = append(removed, &removedFile{
+				sourcePath: k,
+				model:      logFile.model,
+				held:       l.held[k],
+				archived:   l.archived[logFile.model.Path],
+			})
+			delete(l.held, k)
+		}
 	}
+	return removed
+}
+
+// UpdateStreamInfo lets the source fill in the metadata for the stream
+func (p *StreamState) UpdateStreamInfo(fn func(streamInfo *proto.StreamInfo)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	return p.logs.foundFile(sourcePath, relativePath, stat, fields)
+	fn(&p.streamInfo)
 }
 
-func (p *PodState) foundFile(sourcePath string, relativePath string, stat os.FileInfo, fields *proto.Fields) error {
+func (p *StreamState) foundFile(sourcePath string, relativePath string, stat os.FileInfo, fields *proto.Fields, format LogFormat) error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
@@ -279,409 +365,355 @@ func (p *PodState) foundFile(sourcePath string, relativePath string, stat os.Fil
 		p.logs = newLogsState()
 	}
 
-	// TODO: Move to shared LogState.foundFile code; move archiving elsewhere
-	modTime := stat.ModTime()
-	now := time.Now()
-
-	if modTime.Add(idlePeriod).Before(now) {
-		logFile := p.logs.logs[sourcePath]
-		modified := false
-		if logFile != nil {
-			if logFile.model.LastModified == modTime.Unix() && logFile.model.Size == stat.Size() {
-				glog.V(4).Infof("File not modified: %q", sourcePath)
-			} else {
-				modified = true
-			}
-		} else {
-			logFile = &LogFile{
-				model: proto.LogFile{
-					Path:         relativePath,
-					LastModified: modTime.Unix(),
-					Size:         stat.Size(),
-					Fields:       fields,
-				},
-			}
-			p.logs.logs[sourcePath] = logFile
-			modified = true
-		}
+	return p.logs.foundFile(sourcePath, relativePath, stat, fields, format)
+}
 
-		if modified && p.nodeState.archiveSink != nil {
-			archived := p.logs.archived[relativePath]
-			if archived == nil || *archived != *logFile {
-				glog.Warningf("Should not hold lock while archiving file")
-				err := p.nodeState.archiveSink.AddToArchive(sourcePath, p.uid, &logFile.model)
-				if err != nil {
-					glog.Warningf("error adding file %q to archive: %v", sourcePath, err)
-				} else {
-					p.logs.archived[relativePath] = logFile
-				}
-			}
+func (p *StreamState) retainFiles(keep map[string]struct{}) {
+	p.mutex.Lock()
+	var removed []*removedFile
+	if p.logs != nil {
+		removed = p.logs.retainFiles(keep)
+	}
+	key := p.archiveKey
+	streamInfo := p.streamInfo
+	p.mutex.Unlock()
+
+	for _, f := range removed {
+		if f.held == nil {
+			continue
+		}
+		if key != nil {
+			p.archiveRemovedFile(f, key(&streamInfo, &f.model))
 		}
+		p.nodeState.release(f.held)
 	}
-	return nil
 }
 
-var _ proto.LogServerServer = &NodeState{}
+// archiveRemovedFile archives the final contents of a file we have stopped tracking, unless already archived.
+// The file may have been deleted (e.g. with its pod), so we read it through the handle or link we held.
+func (p *StreamState) archiveRemovedFile(f *removedFile, key string) {
+	archiveSink := p.nodeState.archiveSink
+	if archiveSink == nil {
+		return
+	}
 
-func (s *NodeState) GetStreams(request *proto.GetStreamsRequest, out proto.LogServer_GetStreamsServer) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	stat, err := f.held.stat()
+	if err != nil {
+		glog.Warningf("error reading removed file %q: %v", f.sourcePath, err)
+		return
+	}
+	if f.archived != nil && f.archived.model.Size == stat.Size() && f.archived.model.LastModified == stat.ModTime().Unix() {
+		glog.V(4).Infof("Removed file already archived: %q", f.sourcePath)
+		return
+	}
+	f.model.Size = stat.Size()
+	f.model.LastModified = stat.ModTime().Unix()
+
+	glog.V(2).Infof("Archiving final contents of removed file %q", f.sourcePath)
+	if current, err := os.Stat(f.sourcePath); err == nil && os.SameFile(current, stat) {
+		err = archiveSink.AddToArchive(f.sourcePath, key, &f.model)
+	} else if f.held.link != "" {
+		// The upload queue pins the file before we remove the link
+		err = archiveSink.AddToArchive(f.held.link, key, &f.model)
+	} else if openFileSink, ok := archiveSink.(archive.OpenFileSink); ok {
+		err = openFileSink.AddOpenFileToArchive(f.held.handle, key, &f.model)
+	} else {
+		err = fmt.Errorf("file has been deleted")
+	}
+	if err != nil {
+		glog.Warningf("error archiving removed file %q: %v", f.sourcePath, err)
+	}
+}
 
-	glog.V(2).Infof("GetStreamsRequest %q", request)
-	for _, p := range s.pods {
-		err := func() error {
-			p.mutex.Lock()
-			defer p.mutex.Unlock()
+// holdOpen holds the file (see NodeState.hold), so we can archive its final contents even if it is deleted before we
+// next scan
+func (p *StreamState) holdOpen(sourcePath string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-			err := out.Send(&p.streamInfo)
-			if err != nil {
-				return err
-			}
-			return nil
-		}()
-		if err != nil {
-			return err
-		}
+	if p.logs == nil || p.logs.logs[sourcePath] == nil {
+		return
 	}
-	for _, p := range s.containers {
-		err := func() error {
-			p.mutex.Lock()
-			defer p.mutex.Unlock()
 
-			err := out.Send(&p.streamInfo)
-			if err != nil {
-				return err
+	if h := p.logs.held[sourcePath]; h != nil {
+		held, err := h.stat()
+		if err == nil {
+			current, err := os.Stat(sourcePath)
+			if err != nil || os.SameFile(held, current) {
+				return
 			}
-			return nil
-		}()
-		if err != nil {
-			return err
 		}
+		// Replaced, e.g. by log rotation; the old file is tracked under its new name
+		p.nodeState.release(h)
+		delete(p.logs.held, sourcePath)
 	}
 
-	return nil
+	h, err := p.nodeState.hold(sourcePath)
+	if err != nil {
+		glog.V(2).Infof("unable to hold %q: %v", sourcePath, err)
+		return
+	}
+	p.logs.held[sourcePath] = h
 }
 
-type fileScanOperation struct {
+// streamFile is a copy of the catalog entry for a file
+type streamFile struct {
 	sourcePath string
-	fields     *proto.Fields
-	unmatched  []*proto.FieldFilter
+	model      proto.LogFile
+	format     LogFormat
 }
 
-func (s *NodeState) Search(request *proto.SearchRequest, out proto.LogServer_SearchServer) error {
-	glog.Warningf("TODO: Scan files before search?")
-
-	var ops []*fileScanOperation
+// snapshotFiles returns copies of the catalog entries for the files in the stream
+func (p *StreamState) snapshotFiles() []*streamFile {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	func() {
-		s.mutex.Lock()
-		defer s.mutex.Unlock()
-
-		glog.V(2).Infof("Search %q", request)
-		for _, p := range s.pods {
-			func() {
-				p.mutex.Lock()
-				defer p.mutex.Unlock()
-
-				if p.logs != nil {
-					for k, l := range p.logs.logs {
-						canMatch, unmatched := l.canMatch(request)
-						if !canMatch {
-							continue
-						}
-						ops = append(ops, &fileScanOperation{
-							sourcePath: k,
-							fields:     l.model.Fields,
-							unmatched:  unmatched,
-						})
-					}
-				}
-			}()
-		}
+	if p.logs == nil {
+		return nil
+	}
 
-		for _, p := range s.containers {
-			func() {
-				p.mutex.Lock()
-				defer p.mutex.Unlock()
-
-				if p.logs != nil {
-					for k, l := range p.logs.logs {
-						canMatch, unmatched := l.canMatch(request)
-						if !canMatch {
-							glog.V(2).Infof("Excluded file %s size=%d maxTimestamp=%d %v", k, l.model.Size, l.model.MaxTimestamp, l.model.Fields)
-							continue
-						}
-						// TODO: Skip if size 0? ... maybe only if file is "closed"
-
-						glog.V(2).Infof("Unable to exclude file %s size=%d maxTimestamp=%d %v", k, l.model.Size, l.model.MaxTimestamp, l.model.Fields)
-						ops = append(ops, &fileScanOperation{
-							sourcePath: k,
-							fields:     l.model.Fields,
-							unmatched:  unmatched,
-						})
-					}
-				}
-			}()
-		}
-	}()
+	p.logs.mutex.Lock()
+	defer p.logs.mutex.Unlock()
 
-	// TODO: Build callback class
-	var matchBytes []byte
-	if request.Contains != "" {
-		glog.Warningf("JSON match encoding not yet implemented")
-		matchBytes = []byte(request.Contains)
+	var files []*streamFile
+	for k, l := range p.logs.logs {
+		files = append(files, &streamFile{
+			sourcePath: k,
+			model:      l.model,
+			format:     l.format,
+		})
 	}
-	matcher := func(line []byte) bool {
-		if matchBytes != nil {
-			if bytes.Index(line, matchBytes) == -1 {
-				return false
-			}
-		}
-		return true
+	return files
+}
+
+// archiveKeyFunc builds the key under which a file of the stream is archived
+type archiveKeyFunc func(stream *proto.StreamInfo, fileInfo *proto.LogFile) string
+
+// archiveIfIdle uploads the file to the archive sink, once it has not been written to for idlePeriod
+func (p *StreamState) archiveIfIdle(sourcePath string, relativePath string, stat os.FileInfo, key archiveKeyFunc) {
+	if p.nodeState.archiveSink == nil {
+		return
 	}
 
-	buffer := make([]byte, LineBufferSize, LineBufferSize)
-	for _, l := range ops {
-		err := l.searchLogFile(buffer, matcher, request, out)
-		if err != nil {
-			glog.Warningf("error searching log file %q: %v", l, err)
-			return fmt.Errorf("error searching log file %q: %v", l, err)
-		}
+	p.mutex.Lock()
+	p.archiveKey = key
+	p.mutex.Unlock()
+	p.holdOpen(sourcePath)
+
+	if !stat.ModTime().Add(idlePeriod).Before(time.Now()) {
+		return
 	}
-	return nil
-}
 
-type dockerLine struct {
-	Log    string `json:"log,omitempty"`
-	Stream string `json:"stream,omitempty"`
-	Time   string `json:"time,omitempty"`
+	p.archiveFile(sourcePath, relativePath, key)
 }
 
-func (s *fileScanOperation) searchLogFile(buffer []byte, matcher func([]byte) bool, request *proto.SearchRequest, out proto.LogServer_SearchServer) error {
-	glog.V(2).Infof("search log file %q: %v", s.sourcePath, s.unmatched)
+// archiveFile uploads the file to the archive sink, unless it has been uploaded and not changed since
+func (p *StreamState) archiveFile(sourcePath string, relativePath string, key archiveKeyFunc) {
+	archiveSink := p.nodeState.archiveSink
+	if archiveSink == nil {
+		return
+	}
+	p.holdOpen(sourcePath)
 
-	// TODO: Skip if size 0?
+	p.mutex.Lock()
+	p.archiveKey = key
+	if p.logs == nil {
+		p.mutex.Unlock()
+		return
+	}
+	logFile := p.logs.logs[sourcePath]
+	if logFile == nil {
+		p.mutex.Unlock()
+		return
+	}
 
-	var in io.Reader
-	f, err := os.OpenFile(s.sourcePath, os.O_RDONLY, 0)
+	archived := p.logs.archived[relativePath]
+	if archived != nil && archived.model.LastModified == logFile.model.LastModified && archived.model.Size == logFile.model.Size {
+		glog.V(4).Infof("File already archived: %q", sourcePath)
+		p.mutex.Unlock()
+		return
+	}
+
+	snapshot := *logFile
+	archiveKey := key(&p.streamInfo, &snapshot.model)
+	p.mutex.Unlock()
+
+	// The sink is normally an UploadQueue, which uploads in the background
+	err := archiveSink.AddToArchive(sourcePath, archiveKey, &snapshot.model)
 	if err != nil {
-		if os.IsNotExist(err) {
-			glog.V(2).Infof("ignoring log file that no longer exists %q", s.sourcePath)
-			return nil
-		} else {
-			glog.Warningf("ignoring error opening log file %q: %v", s.sourcePath, err)
-			return nil
-		}
+		glog.Warningf("error adding file %q to archive: %v", sourcePath, err)
+		return
 	}
-	defer f.Close()
 
-	in = f
-	if strings.HasSuffix(s.sourcePath, ".gz") {
-		gz, err := gzip.NewReader(in)
-		if err != nil {
-			return fmt.Errorf("error building gzip decompressor for %q: %v", s.sourcePath, err)
-		}
-		defer gz.Close()
-		in = gz
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.logs != nil {
+		p.logs.archived[relativePath] = &snapshot
 	}
+}
 
-	var chunk *proto.SearchResultChunk
-	var chunkSize int
+var _ proto.LogServerServer = &NodeState{}
 
-	scanner := bufio.NewScanner(in)
-	scanner.Buffer(buffer, cap(buffer))
-	for scanner.Scan() {
-		line := scanner.Bytes()
+// Health reports that we are serving; the hub uses it to probe its members
+func (s *NodeState) Health(ctx context.Context, request *proto.HealthRequest) (*proto.HealthResponse, error) {
+	return &proto.HealthResponse{Host: s.host}, nil
+}
 
-		if !matcher(line) {
+func (s *NodeState) GetStreams(request *proto.GetStreamsRequest, out proto.LogServer_GetStreamsServer) error {
+	glog.V(2).Infof("GetStreamsRequest %q", request)
+
+	if request.Host != "" && request.Host != s.host {
+		return nil
+	}
+
+	for _, p := range s.snapshotStreams() {
+		streamInfo := p.buildStreamInfo()
+		if !matchStreamFilters(streamInfo, request.FieldFilters) {
 			continue
 		}
-		if chunk == nil {
-			chunk = &proto.SearchResultChunk{}
-			chunkSize = 32
-			chunk.CommonFields = s.fields
+		err := out.Send(streamInfo)
+		if err != nil {
+			return err
 		}
+	}
 
-		item := &proto.SearchResult{}
-		item.Raw = line
+	return nil
+}
 
-		var l dockerLine
-		err = json.Unmarshal(line, &l)
-		if err == nil {
-			fields := item.Fields
-			if fields == nil {
-				fields = &proto.Fields{}
-				item.Fields = fields
-			}
-			if l.Log != "" {
-				fields.Fields = append(fields.Fields, &proto.Field{
-					Key:   "log",
-					Value: l.Log,
-				})
-				chunkSize += 8 + len(l.Log)
-			}
-			if l.Stream != "" {
-				fields.Fields = append(fields.Fields, &proto.Field{
-					Key:   "stream",
-					Value: l.Stream,
-				})
-				chunkSize += 8 + len(l.Stream)
+// buildStreamInfo returns a copy of the stream info, with the statistics of the current files
+func (p *StreamState) buildStreamInfo() *proto.StreamInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	streamInfo := p.streamInfo
+	if p.logs != nil {
+		p.logs.mutex.Lock()
+		defer p.logs.mutex.Unlock()
+
+		for _, l := range p.logs.logs {
+			streamInfo.FileCount++
+			streamInfo.Size += l.model.Size
+			if l.model.MinTimestamp != 0 && (streamInfo.FirstTimestamp == 0 || l.model.MinTimestamp < streamInfo.FirstTimestamp) {
+				streamInfo.FirstTimestamp = l.model.MinTimestamp
 			}
-			if l.Time != "" {
-				t, err := time.Parse(time.RFC3339Nano, l.Time)
-				if err == nil {
-					item.Timestamp = uint64(t.UnixNano())
-				}
-				chunkSize += 10
+			if l.model.MaxTimestamp > streamInfo.LastTimestamp {
+				streamInfo.LastTimestamp = l.model.MaxTimestamp
 			}
 		}
+	}
+	return &streamInfo
+}
 
-		if len(s.unmatched) != 0 {
-			itemFields := item.Fields
-			if itemFields == nil {
-				continue
-			}
-
-			match := true
-			for _, filter := range s.unmatched {
-				// TODO: Well known fields
-				if filter.Key == "@timestamp" {
-					// TODO: What if no timestamp?
-
-					// TODO: Non-string values
-					t, err := time.Parse(time.RFC3339Nano, filter.Value)
-					if err != nil {
-						glog.Warningf("ignoring error parsing @timestamp value %q", filter.Value)
-						continue
-					}
-
-					switch filter.Op {
-					case proto.FieldFilterOperator_GTE:
-						if !(item.Timestamp >= uint64(t.UnixNano())) {
-							match = false
-						}
-
-					default:
-						glog.Warningf("Unhandled operator: %v", filter)
-					}
-				} else {
-					found := false
-					for _, actual := range itemFields.Fields {
-						if actual.Key == filter.Key {
-							found = true
-							switch filter.Op {
-							case proto.FieldFilterOperator_NOT_EQ:
-								if actual.Value == filter.Value {
-									match = false
-								}
-							case proto.FieldFilterOperator_EQ:
-								if actual.Value != filter.Value {
-									match = false
-								}
-
-							default:
-								glog.Warningf("Unhandled operator: %v", filter)
-							}
-							break
-						}
-					}
-					if !found {
-						match = false
-					}
-				}
+// streamFields returns the stream metadata as fields, using the same names as the search fields
+func streamFields(streamInfo *proto.StreamInfo) map[string]string {
+	return map[string]string{
+		"host":           streamInfo.Host,
+		"pod.namespace":  streamInfo.PodNamespace,
+		"pod.name":       streamInfo.PodName,
+		"pod.uid":        streamInfo.PodUid,
+		"container.name": streamInfo.ContainerName,
+		"container.id":   streamInfo.ContainerId,
+		"source":         streamInfo.Source,
+	}
+}
 
-				if !match {
-					break
-				}
-			}
+// matchStreamFilters returns true if the stream matches all the filters; unknown fields are treated as empty
+func matchStreamFilters(streamInfo *proto.StreamInfo, filters []*proto.FieldFilter) bool {
+	if len(filters) == 0 {
+		return true
+	}
 
-			if !match {
+	fields := streamFields(streamInfo)
+	for _, filter := range filters {
+		if filter.Key == "@timestamp" {
+			t, err := time.Parse(time.RFC3339Nano, filter.Value)
+			if err != nil {
+				glog.Warningf("ignoring error parsing @timestamp value %q", filter.Value)
 				continue
 			}
-		}
 
-		chunk.Items = append(chunk.Items, item)
-		chunkSize += 8 + len(line)
-
-		if chunkSize > chunkFlushSize {
-			if err := out.Send(chunk); err != nil {
-				return err
+			switch filter.Op {
+			case proto.FieldFilterOperator_GTE:
+				if streamInfo.LastTimestamp != 0 && streamInfo.LastTimestamp < uint64(t.UnixNano()) {
+					return false
+				}
+			default:
+				glog.Warningf("Unhandled operator: %v", filter)
 			}
-			chunk = nil
+			continue
 		}
-	}
 
-	if chunk != nil {
-		if err := out.Send(chunk); err != nil {
-			return err
+		actual := fields[filter.Key]
+		switch filter.Op {
+		case proto.FieldFilterOperator_NOT_EQ:
+			if actual == filter.Value {
+				return false
+			}
+		case proto.FieldFilterOperator_EQ:
+			if actual != filter.Value {
+				return false
+			}
+		default:
+			glog.Warningf("Unhandled operator: %v", filter)
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		glog.Warningf("error reading log file %q: %v", s.sourcePath, err)
-	}
-	return nil
+	return true
 }
 
-func findMaxTimestamp(sourcePath string) (uint64, uint64, error) {
-	buffer := make([]byte, LineBufferSize, LineBufferSize)
+// fileScanOperation is the search of a file in the catalog, in its format
+type fileScanOperation struct {
+	logsearch.Scan
+	format LogFormat
+}
 
-	glog.V(2).Infof("findMaxTimestamp for %q", sourcePath)
+func (s *NodeState) Search(request *proto.SearchRequest, out proto.LogServer_SearchServer) error {
+	glog.Warningf("TODO: Scan files before search?")
 
-	var in io.Reader
-	f, err := os.OpenFile(sourcePath, os.O_RDONLY, 0)
-	if err != nil {
-		if os.IsNotExist(err) {
-			glog.V(2).Infof("ignoring log file that no longer exists %q", sourcePath)
-			return 0, 0, err
-		} else {
-			return 0, 0, err
-		}
-	}
-	defer f.Close()
+	glog.V(2).Infof("Search %q", request)
 
-	// TODO: rotate, attach metadata
-	glog.Warningf("findMaxTimestamp is very inefficient")
+	var ops []*fileScanOperation
+	for _, p := range s.snapshotStreams() {
+		func() {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
 
-	in = f
-	if strings.HasSuffix(sourcePath, ".gz") {
-		gz, err := gzip.NewReader(in)
-		if err != nil {
-			return 0, 0, fmt.Errorf("error building gzip decompressor for %q: %v", sourcePath, err)
-		}
-		defer gz.Close()
-		in = gz
-	}
+			if p.logs == nil {
+				return
+			}
 
-	minTimestamp := uint64(math.MaxUint64)
-	maxTimestamp := uint64(0)
-	scanner := bufio.NewScanner(in)
-	scanner.Buffer(buffer, cap(buffer))
-	for scanner.Scan() {
-		line := scanner.Bytes()
+			p.logs.mutex.Lock()
+			defer p.logs.mutex.Unlock()
 
-		// TODO: Don't bother parsing unless we are at the end?
-		var l dockerLine
-		err = json.Unmarshal(line, &l)
-		if err == nil {
-			if l.Time != "" {
-				t, err := time.Parse(time.RFC3339Nano, l.Time)
-				if err == nil {
-					ts := uint64(t.UnixNano())
-					if ts > maxTimestamp {
-						maxTimestamp = ts
-					}
-					if ts < minTimestamp {
-						minTimestamp = ts
-					}
+			for k, l := range p.logs.logs {
+				canMatch, unmatched := logsearch.CanMatch(&l.model, request)
+				if !canMatch {
+					glog.V(2).Infof("Excluded file %s size=%d maxTimestamp=%d %v", k, l.model.Size, l.model.MaxTimestamp, l.model.Fields)
+					continue
 				}
+				// TODO: Skip if size 0? ... maybe only if file is "closed"
+
+				glog.V(2).Infof("Unable to exclude file %s size=%d maxTimestamp=%d %v", k, l.model.Size, l.model.MaxTimestamp, l.model.Fields)
+				ops = append(ops, &fileScanOperation{
+					Scan: logsearch.Scan{
+						SourcePath: k,
+						Fields:     l.model.Fields,
+						Unmatched:  unmatched,
+					},
+					format: l.format,
+				})
 			}
-		}
+		}()
 	}
 
-	if err := scanner.Err(); err != nil {
-		return 0, 0, fmt.Errorf("error reading log file %q: %v", sourcePath, err)
+	search := logsearch.NewSearch(request, out)
+	for _, l := range ops {
+		glog.V(2).Infof("search log file %q: %v", l.SourcePath, l.Unmatched)
+		err := l.format.Search(l, search)
+		if err != nil {
+			glog.Warningf("error searching log file %q: %v", l.SourcePath, err)
+			return fmt.Errorf("error searching log file %q: %v", l.SourcePath, err)
+		}
 	}
-
-	return minTimestamp, maxTimestamp, nil
+	return nil
 }
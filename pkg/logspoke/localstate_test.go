@@ -0,0 +1,159 @@
+package logspoke
+
+import (
+	"io"
+	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
+	"kope.io/klogs/pkg/proto"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeArchiveSink records the contents of each file when it is added
+type fakeArchiveSink struct {
+	archived map[string]string
+}
+
+var _ archive.OpenFileSink = &fakeArchiveSink{}
+
+func (s *fakeArchiveSink) AddToArchive(sourcePath string, key string, fileInfo *proto.LogFile) error {
+	data, err := ioutil.ReadFile(sourcePath)
+	if err != nil {
+		return err
+	}
+	s.archived[key] = string(data)
+	return nil
+}
+
+func (s *fakeArchiveSink) AddOpenFileToArchive(in *os.File, key string, fileInfo *proto.LogFile) error {
+	data, err := ioutil.ReadAll(io.NewSectionReader(in, 0, math.MaxInt64))
+	if err != nil {
+		return err
+	}
+	s.archived[key] = string(data)
+	return nil
+}
+
+func TestArchiveRemovedFile(t *testing.T) {
+	defer func(n int) { maxHeldFiles = n }(maxHeldFiles)
+
+	const key = "pods/uid-1/logs/app.log"
+	grid := []struct {
+		name         string
+		maxHeldFiles int
+		holdDir      bool
+		archived     bool
+	}{
+		{name: "held open", maxHeldFiles: 10, archived: true},
+		// Over the limit of open files, we hold the file with a hard link
+		{name: "hard link", maxHeldFiles: 0, holdDir: true, archived: true},
+		{name: "not held", maxHeldFiles: 0, archived: false},
+	}
+	for _, g := range grid {
+		maxHeldFiles = g.maxHeldFiles
+
+		dir, err := ioutil.TempDir("", "logspoke")
+		if err != nil {
+			t.Fatalf("error creating temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		sink := &fakeArchiveSink{archived: make(map[string]string)}
+		state := newNodeState("node-1", sink)
+		holdDir := filepath.Join(dir, "held")
+		if g.holdDir {
+			if err := state.setHoldDir(holdDir); err != nil {
+				t.Fatalf("error setting hold directory: %v", err)
+			}
+		}
+
+		stream := state.GetStream("pods", "uid-1")
+		stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+			streamInfo.PodUid = "uid-1"
+		})
+		p := filepath.Join(dir, "volume", "app.log")
+		writeTestFile(t, p)
+		stat, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("error doing stat on %q: %v", p, err)
+		}
+		stream.foundFile(p, "logs/app.log", stat, &proto.Fields{}, TextFormat)
+		// The file is still being written, so it is held but not archived
+		stream.archiveIfIdle(p, "logs/app.log", stat, archive.PodFileKey)
+		if len(sink.archived) != 0 {
+			t.Fatalf("%s: active file was archived", g.name)
+		}
+
+		// The last line is written, then the pod is removed before we scan again
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("error opening %q: %v", p, err)
+		}
+		f.Write([]byte("2017-01-02T03:04:06Z goodbye\n"))
+		f.Close()
+		if err := os.RemoveAll(filepath.Dir(p)); err != nil {
+			t.Fatalf("error removing %q: %v", p, err)
+		}
+		stream.retainFiles(nil)
+
+		expected := "2017-01-02T03:04:05Z hello\n2017-01-02T03:04:06Z goodbye\n"
+		actual, found := sink.archived[key]
+		if found != g.archived {
+			t.Errorf("%s: archived was %v, expected %v", g.name, found, g.archived)
+		} else if found && actual != expected {
+			t.Errorf("%s: archived %q, expected %q", g.name, actual, expected)
+		}
+
+		// Nothing is held once the file has been archived
+		if state.heldFiles != 0 {
+			t.Errorf("%s: still holding %d files open", g.name, state.heldFiles)
+		}
+		if links, _ := ioutil.ReadDir(holdDir); len(links) != 0 {
+			t.Errorf("%s: %d links left in the hold directory", g.name, len(links))
+		}
+	}
+}
+
+func TestMatchStreamFilters(t *testing.T) {
+	streamInfo := &proto.StreamInfo{
+		Host:          "node-1",
+		PodNamespace:  "shop",
+		PodName:       "web-0",
+		ContainerName: "web",
+		// 2017-01-02T03:04:05Z
+		LastTimestamp: 1483326245000000000,
+	}
+
+	grid := []struct {
+		filters  []*proto.FieldFilter
+		expected bool
+	}{
+		{filters: nil, expected: true},
+		{filters: []*proto.FieldFilter{{Key: "host", Op: proto.FieldFilterOperator_EQ, Value: "node-1"}}, expected: true},
+		{filters: []*proto.FieldFilter{{Key: "host", Op: proto.FieldFilterOperator_EQ, Value: "node-2"}}, expected: false},
+		{filters: []*proto.FieldFilter{{Key: "pod.namespace", Op: proto.FieldFilterOperator_NOT_EQ, Value: "kube-system"}}, expected: true},
+		{filters: []*proto.FieldFilter{{Key: "pod.namespace", Op: proto.FieldFilterOperator_NOT_EQ, Value: "shop"}}, expected: false},
+		// All the filters must match
+		{
+			filters: []*proto.FieldFilter{
+				{Key: "pod.name", Op: proto.FieldFilterOperator_EQ, Value: "web-0"},
+				{Key: "container.name", Op: proto.FieldFilterOperator_EQ, Value: "sidecar"},
+			},
+			expected: false,
+		},
+		// Unknown fields are empty
+		{filters: []*proto.FieldFilter{{Key: "label.app", Op: proto.FieldFilterOperator_EQ, Value: ""}}, expected: true},
+		{filters: []*proto.FieldFilter{{Key: "label.app", Op: proto.FieldFilterOperator_EQ, Value: "web"}}, expected: false},
+		// Streams whose last entry is before the start of the search are excluded
+		{filters: []*proto.FieldFilter{{Key: "@timestamp", Op: proto.FieldFilterOperator_GTE, Value: "2017-01-02T00:00:00Z"}}, expected: true},
+		{filters: []*proto.FieldFilter{{Key: "@timestamp", Op: proto.FieldFilterOperator_GTE, Value: "2017-01-03T00:00:00Z"}}, expected: false},
+		{filters: []*proto.FieldFilter{{Key: "@timestamp", Op: proto.FieldFilterOperator_GTE, Value: "not a time"}}, expected: true},
+	}
+	for _, g := range grid {
+		if actual := matchStreamFilters(streamInfo, g.filters); actual != g.expected {
+			t.Errorf("matchStreamFilters(%v) was %v, expected %v", g.filters, actual, g.expected)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package logspoke
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"kope.io/klogs/pkg/logsearch"
+	"kope.io/klogs/pkg/proto"
+	"math"
+	"os"
+	"strings"
+)
+
+// lineFormat is a LogFormat for newline-delimited files, optionally gzipped (rotated files)
+type lineFormat struct {
+	parse logsearch.LineParser
+}
+
+var _ LogFormat = &lineFormat{}
+
+// DockerJSONFormat reads the json-file logs written by docker; lines that are not JSON are treated as text
+var DockerJSONFormat LogFormat = &lineFormat{parse: logsearch.ParseDockerLine}
+
+// TextFormat reads plain-text log files, recognizing common timestamp prefixes
+var TextFormat LogFormat = &lineFormat{parse: logsearch.ParseTextLine}
+
+// logFormats names the formats, so we can record them (e.g. in retained stream metadata)
+var logFormats = map[string]LogFormat{
+	"docker-json": DockerJSONFormat,
+	"text":        TextFormat,
+	"cri":         CRIFormat,
+	"journal":     JournalFormat,
+}
+
+// formatName returns the name of the format in logFormats, or "" if it is not known
+func formatName(format LogFormat) string {
+	for k, v := range logFormats {
+		if v == format {
+			return k
+		}
+	}
+	return ""
+}
+
+// openLogFile opens the file for reading, transparently decompressing .gz files
+func openLogFile(sourcePath string) (io.ReadCloser, error) {
+	f, err := os.OpenFile(sourcePath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(sourcePath, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error building gzip decompressor for %q: %v", sourcePath, err)
+	}
+	return &gzipFile{Reader: gz, f: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+func (f *lineFormat) Search(s *fileScanOperation, search *logsearch.Search) error {
+	// TODO: Skip if size 0?
+
+	in, err := openLogFile(s.SourcePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.V(2).Infof("ignoring log file that no longer exists %q", s.SourcePath)
+		} else {
+			glog.Warningf("ignoring error opening log file %q: %v", s.SourcePath, err)
+		}
+		return nil
+	}
+	defer in.Close()
+
+	return s.SearchLines(in, f.parse, search)
+}
+
+func (f *lineFormat) Timestamps(sourcePath string) (uint64, uint64, error) {
+	glog.V(2).Infof("findMaxTimestamp for %q", sourcePath)
+
+	in, err := openLogFile(sourcePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.V(2).Infof("ignoring log file that no longer exists %q", sourcePath)
+		}
+		return 0, 0, err
+	}
+	defer in.Close()
+
+	// TODO: rotate, attach metadata
+	glog.Warningf("findMaxTimestamp is very inefficient")
+
+	buffer := make([]byte, logsearch.LineBufferSize, logsearch.LineBufferSize)
+
+	minTimestamp := uint64(math.MaxUint64)
+	maxTimestamp := uint64(0)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(buffer, cap(buffer))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// TODO: Don't bother parsing unless we are at the end?
+		item := &proto.SearchResult{}
+		f.parse(line, item)
+		if item.Timestamp == 0 {
+			continue
+		}
+		if item.Timestamp > maxTimestamp {
+			maxTimestamp = item.Timestamp
+		}
+		if item.Timestamp < minTimestamp {
+			minTimestamp = item.Timestamp
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error reading log file %q: %v", sourcePath, err)
+	}
+
+	return minTimestamp, maxTimestamp, nil
+}
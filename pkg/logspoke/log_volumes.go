@@ -4,29 +4,89 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"io"
+	"io/ioutil"
+	"kope.io/klogs/pkg/archive"
 	"kope.io/klogs/pkg/proto"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"time"
 )
 
+// LogVolumesAnnotation lets a pod opt additional volumes in to log collection, e.g. "app-logs,audit".
+// Most volumes are found by name under the kubelet pod directory; hostPath volumes are not materialized
+// there, so we find them in the pod spec from the kubelet API (see KubeletPods).
+const LogVolumesAnnotation = "klogs.kope.io/log-volumes"
+
+// volumePluginPrefix is the prefix of the volume plugin directories in the kubelet pod directory
+const volumePluginPrefix = "kubernetes.io~"
+
+// hostPathVolumeType is the volume type of hostPath volumes, named like the kubelet volume plugins
+const hostPathVolumeType = "host-path"
+
 type PodsDirectory struct {
 	basedir    string
 	idlePeriod time.Duration
 
+	// volumeNames are globs of volume names collected in every pod
+	volumeNames []string
+	// volumeTypes are the volume plugins (e.g. empty-dir, csi) whose volumes are collected by name
+	volumeTypes []string
+	// include and exclude are globs applied to the paths of files within the volumes
+	include []string
+	exclude []string
+
+	// hostRoot is where the host filesystem is mounted, for reading hostPath volumes
+	hostRoot string
+	// kubelet reads the pod specs, if configured; otherwise we learn what we can from the container sources
+	kubelet *KubeletPods
+
 	state *NodeState
 }
 
-func NewPodsDirectory(basedir string, state *NodeState) (*PodsDirectory, error) {
+func init() {
+	RegisterSource("pods", func(options *Options, state *NodeState) (Source, error) {
+		return NewPodsDirectory(options, state)
+	})
+}
+
+func NewPodsDirectory(options *Options, state *NodeState) (*PodsDirectory, error) {
 	d := &PodsDirectory{
-		basedir:    basedir,
-		idlePeriod: idlePeriod,
-		state:      state,
+		basedir:     options.PodDir,
+		idlePeriod:  idlePeriod,
+		volumeNames: options.PodLogVolumes,
+		volumeTypes: options.PodLogVolumeTypes,
+		include:     options.PodLogInclude,
+		exclude:     options.PodLogExclude,
+		hostRoot:    options.HostRoot,
+		state:       state,
+	}
+
+	if options.KubeletURL != "" {
+		kubelet, err := NewKubeletPods(options.KubeletURL, options.KubeletTokenFile, options.KubeletCAFile, options.KubeletInsecureTLS)
+		if err != nil {
+			return nil, err
+		}
+		d.kubelet = kubelet
+	}
+
+	for _, globs := range [][]string{d.volumeNames, d.include, d.exclude} {
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %v", glob, err)
+			}
+		}
 	}
 	return d, nil
 }
 
 func (d *PodsDirectory) Scan() error {
+	if d.kubelet != nil {
+		if err := d.kubelet.Refresh(); err != nil {
+			glog.Warningf("error reading pods from kubelet: %v", err)
+		}
+	}
 	return d.scanPodsDir(d.basedir)
 }
 
@@ -52,40 +112,260 @@ func (d *PodsDirectory) scanPodsDir(basepath string) error {
 		}
 	}
 
-	d.state.CleanupPodLogs(names)
+	d.state.CleanupStreams("pods", names)
 
 	return nil
 }
 
+// podLogs is the identity of a pod whose log volumes we are scanning
+type podLogs struct {
+	uid        string
+	name       string
+	namespace  string
+	containers []string
+
+	stream *StreamState
+}
+
+// logVolume is a volume of a pod that we collect logs from
+type logVolume struct {
+	name string
+	path string
+}
+
 func (d *PodsDirectory) scanPodDirectory(basepath string, podID string) error {
-	p := path.Join(basepath, "volumes/kubernetes.io~empty-dir/logs")
-	stat, err := os.Lstat(p)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+	pod := &podLogs{
+		uid: podID,
+	}
+
+	// The kubelet pod directory doesn't record the pod name; we learn it from the kubelet API, or else from the
+	// pod's containers
+	var annotations map[string]string
+	var hostPaths map[string]string
+	var kubeletPod *kubeletPod
+	if d.kubelet != nil {
+		kubeletPod = d.kubelet.find(podID)
+	}
+	if kubeletPod != nil {
+		pod.name = kubeletPod.Metadata.Name
+		pod.namespace = kubeletPod.Metadata.Namespace
+		annotations = kubeletPod.Metadata.Annotations
+		hostPaths = kubeletPod.hostPaths()
+	} else if info := d.state.findPod(podID); info != nil {
+		pod.name = info.name
+		pod.namespace = info.namespace
+		annotations = info.annotations
+	}
+
+	var annotatedVolumes []string
+	for _, v := range strings.Split(annotations[LogVolumesAnnotation], ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			annotatedVolumes = append(annotatedVolumes, v)
 		}
-		return fmt.Errorf("error doing stat on %q: %v", p, err)
 	}
-	if !stat.IsDir() {
+
+	volumes, err := d.findLogVolumes(basepath, annotatedVolumes, hostPaths)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
 		return nil
 	}
 
-	podState := d.state.GetPodState(podID)
+	pod.containers = readPodContainerNames(basepath)
 
-	glog.V(4).Infof("Found pod logs mount: %q", p)
+	pod.stream = d.state.GetStream("pods", podID)
+	pod.stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.PodUid = pod.uid
+		streamInfo.PodName = pod.name
+		streamInfo.PodNamespace = pod.namespace
+		if len(pod.containers) == 1 {
+			streamInfo.ContainerName = pod.containers[0]
+		}
+	})
 
 	fileMap := make(map[string]struct{})
-	err = d.scanLogsTree(p, podState, "", fileMap)
-	if err != nil {
-		return err
+	for _, volume := range volumes {
+		glog.V(4).Infof("Found pod logs mount: %q", volume.path)
+
+		err = d.scanLogsTree(volume.path, pod, volume, "", fileMap)
+		if err != nil {
+			return err
+		}
 	}
 
-	glog.Warningf("TODO: Remove files not in fileMap")
+	pod.stream.retainFiles(fileMap)
 
 	return nil
 }
 
-func (d *PodsDirectory) scanLogsTree(basepath string, podState *PodState, relativePath string, fileMap map[string]struct{}) error {
+// findLogVolumes returns the volumes of the pod we should collect: those matching the configured
+// names & types, and those named in the pod annotation (regardless of type).  hostPaths are the
+// pod's hostPath volumes, by name.
+func (d *PodsDirectory) findLogVolumes(basepath string, annotatedVolumes []string, hostPaths map[string]string) ([]*logVolume, error) {
+	var volumes []*logVolume
+
+	volumesDir := path.Join(basepath, "volumes")
+	plugins, err := ioutil.ReadDir(volumesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading directory %q: %v", volumesDir, err)
+	}
+	for _, plugin := range plugins {
+		if !plugin.IsDir() || !strings.HasPrefix(plugin.Name(), volumePluginPrefix) {
+			continue
+		}
+		volumeType := strings.TrimPrefix(plugin.Name(), volumePluginPrefix)
+
+		pluginDir := path.Join(volumesDir, plugin.Name())
+		entries, err := ioutil.ReadDir(pluginDir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory %q: %v", pluginDir, err)
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			name := entry.Name()
+			if !d.isLogVolume(volumeType, name) && !containsString(annotatedVolumes, name) {
+				continue
+			}
+
+			p := path.Join(pluginDir, name)
+			if volumeType == "csi" {
+				// CSI volumes are mounted in a subdirectory, alongside the vol_data.json
+				p = path.Join(p, "mount")
+			}
+			volumes = append(volumes, &logVolume{name: name, path: p})
+		}
+	}
+
+	var hostPathNames []string
+	for name := range hostPaths {
+		hostPathNames = append(hostPathNames, name)
+	}
+	sort.Strings(hostPathNames)
+	for _, name := range hostPathNames {
+		if !d.isLogVolume(hostPathVolumeType, name) && !containsString(annotatedVolumes, name) {
+			continue
+		}
+
+		// We only collect directories; hostPath volumes can also mount single files or sockets
+		p := path.Join(d.hostRoot, hostPaths[name])
+		stat, err := os.Stat(p)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Warningf("error doing stat on hostPath volume %q: %v", p, err)
+			}
+			continue
+		}
+		if !stat.IsDir() {
+			glog.V(2).Infof("Ignoring hostPath volume %q that is not a directory", p)
+			continue
+		}
+		volumes = append(volumes, &logVolume{name: name, path: p})
+	}
+
+	return volumes, nil
+}
+
+func (d *PodsDirectory) isLogVolume(volumeType string, name string) bool {
+	if !containsString(d.volumeTypes, volumeType) {
+		return false
+	}
+	for _, glob := range d.volumeNames {
+		if match, _ := path.Match(glob, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// includeFile applies the include & exclude globs to a path within a volume; globs without a
+// slash are matched against the file name
+func (d *PodsDirectory) includeFile(relativePath string) bool {
+	matches := func(globs []string) bool {
+		for _, glob := range globs {
+			s := relativePath
+			if !strings.Contains(glob, "/") {
+				s = path.Base(relativePath)
+			}
+			if match, _ := path.Match(glob, s); match {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(d.include) != 0 && !matches(d.include) {
+		return false
+	}
+	return !matches(d.exclude)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readPodContainerNames returns the names of the containers in the pod, from the kubelet's containers directory
+func readPodContainerNames(basepath string) []string {
+	p := path.Join(basepath, "containers")
+	f, err := os.OpenFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Warningf("error opening %q: %v", p, err)
+		}
+		return nil
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		glog.Warningf("error reading directory %q: %v", p, err)
+		return nil
+	}
+	return names
+}
+
+// fields returns the fields for a file in one of the pod's log volumes.  We know the container if the pod
+// only has one, or if the file is in a directory named after the container.
+func (p *podLogs) fields(volume *logVolume, relativePath string) *proto.Fields {
+	fields := &proto.Fields{}
+	addField := func(k, v string) {
+		if v != "" {
+			fields.Fields = append(fields.Fields, &proto.Field{Key: k, Value: v})
+		}
+	}
+
+	addField("pod.uid", p.uid)
+	addField("pod.name", p.name)
+	addField("pod.namespace", p.namespace)
+
+	containerName := ""
+	if len(p.containers) == 1 {
+		containerName = p.containers[0]
+	} else {
+		dir := strings.SplitN(relativePath, "/", 2)[0]
+		for _, c := range p.containers {
+			if c == dir {
+				containerName = c
+			}
+		}
+	}
+	addField("container.name", containerName)
+	addField("volume", volume.name)
+	addField("path", relativePath)
+
+	return fields
+}
+
+func (d *PodsDirectory) scanLogsTree(basepath string, pod *podLogs, volume *logVolume, relativePath string, fileMap map[string]struct{}) error {
 	f, err := os.OpenFile(basepath, os.O_RDONLY, 0)
 	if err != nil {
 		return fmt.Errorf("error opening %q: %v", basepath, err)
@@ -110,15 +390,21 @@ func (d *PodsDirectory) scanLogsTree(basepath string, podState *PodState, relati
 			}
 
 			if stat.IsDir() {
-				err = d.scanLogsTree(p, podState, path.Join(relativePath, name), fileMap)
+				err = d.scanLogsTree(p, pod, volume, path.Join(relativePath, name), fileMap)
 				if err != nil {
 					return err
 				}
-			} else {
+			} else if stat.Mode().IsRegular() {
 				f := path.Join(relativePath, name)
-				fileMap[f] = struct{}{}
-				fields := &proto.Fields{}
-				podState.foundFile(p, path.Join(relativePath, name), stat, fields)
+				if !d.includeFile(f) {
+					continue
+				}
+
+				// The catalog path includes the volume, so files in different volumes don't collide
+				catalogPath := path.Join(volume.name, f)
+				fileMap[p] = struct{}{}
+				pod.stream.foundFile(p, catalogPath, stat, pod.fields(volume, f), TextFormat)
+				pod.stream.archiveIfIdle(p, catalogPath, stat, archive.PodFileKey)
 			}
 		}
 
@@ -0,0 +1,157 @@
+package logspoke
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const kubeletPodsResponse = `{
+  "kind": "PodList",
+  "items": [
+    {
+      "metadata": {
+        "uid": "uid-1",
+        "name": "web-0",
+        "namespace": "shop",
+        "annotations": {"klogs.kope.io/log-volumes": "app-logs, audit"}
+      },
+      "spec": {
+        "containers": [{"name": "web"}],
+        "volumes": [
+          {"name": "app-logs", "hostPath": {"path": "/var/log/web"}},
+          {"name": "audit", "hostPath": {"path": "/var/log/audit.log", "type": "File"}},
+          {"name": "config", "hostPath": {"path": "/etc/web"}},
+          {"name": "logs", "emptyDir": {}}
+        ]
+      }
+    }
+  ]
+}`
+
+func writeTestFile(t *testing.T, p string) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("error creating directory for %q: %v", p, err)
+	}
+	if err := ioutil.WriteFile(p, []byte("2017-01-02T03:04:05Z hello\n"), 0644); err != nil {
+		t.Fatalf("error writing %q: %v", p, err)
+	}
+}
+
+func TestPodsDirectoryKubeletPods(t *testing.T) {
+	var authorization string
+	kubelet := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(kubeletPodsResponse))
+	}))
+	defer kubelet.Close()
+
+	dir, err := ioutil.TempDir("", "logspoke")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	podDir := filepath.Join(dir, "pods")
+	hostRoot := filepath.Join(dir, "host")
+	writeTestFile(t, filepath.Join(podDir, "uid-1", "volumes", "kubernetes.io~empty-dir", "logs", "app.log"))
+	writeTestFile(t, filepath.Join(podDir, "uid-1", "containers", "web", "0"))
+	writeTestFile(t, filepath.Join(hostRoot, "var", "log", "web", "access.log"))
+	writeTestFile(t, filepath.Join(hostRoot, "var", "log", "audit.log"))
+	writeTestFile(t, filepath.Join(hostRoot, "etc", "web", "web.conf"))
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("error writing token: %v", err)
+	}
+
+	options := &Options{}
+	options.SetDefaults()
+	options.PodDir = podDir
+	options.HostRoot = hostRoot
+	options.KubeletURL = kubelet.URL
+	options.KubeletTokenFile = tokenFile
+	options.KubeletInsecureTLS = true
+
+	state := newNodeState("node-1", nil)
+	d, err := NewPodsDirectory(options, state)
+	if err != nil {
+		t.Fatalf("error building PodsDirectory: %v", err)
+	}
+	if err := d.Scan(); err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+
+	if authorization != "Bearer secret" {
+		t.Errorf("kubelet request had authorization %q", authorization)
+	}
+
+	stream := state.GetStream("pods", "uid-1")
+	streamInfo := stream.buildStreamInfo()
+	if streamInfo.PodName != "web-0" || streamInfo.PodNamespace != "shop" || streamInfo.ContainerName != "web" {
+		t.Errorf("unexpected stream info %v", streamInfo)
+	}
+
+	// The emptyDir is collected by the default configuration, the hostPath directory through the annotation; the
+	// annotated hostPath file and the hostPath directory that is not opted in are skipped
+	var files []string
+	for p := range stream.logs.logs {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	expected := []string{
+		filepath.Join(hostRoot, "var", "log", "web", "access.log"),
+		filepath.Join(podDir, "uid-1", "volumes", "kubernetes.io~empty-dir", "logs", "app.log"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("collected files %q, expected %q", files, expected)
+	}
+
+	fields := make(map[string]string)
+	for _, f := range stream.logs.logs[expected[0]].model.Fields.Fields {
+		fields[f.Key] = f.Value
+	}
+	if fields["volume"] != "app-logs" || fields["path"] != "access.log" || fields["pod.namespace"] != "shop" {
+		t.Errorf("unexpected fields for hostPath file: %v", fields)
+	}
+}
+
+func TestPodsDirectoryHostPathType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspoke")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "var", "log", "web", "access.log"))
+
+	// Without the annotation, hostPath volumes are collected by name when host-path is a configured type
+	grid := []struct {
+		volumeTypes []string
+		expected    int
+	}{
+		{volumeTypes: []string{"empty-dir"}, expected: 0},
+		{volumeTypes: []string{"empty-dir", hostPathVolumeType}, expected: 1},
+	}
+	for _, g := range grid {
+		d := &PodsDirectory{
+			volumeNames: []string{"*logs"},
+			volumeTypes: g.volumeTypes,
+			hostRoot:    dir,
+		}
+		volumes, err := d.findLogVolumes(filepath.Join(dir, "no-such-pod"), nil, map[string]string{"app-logs": "/var/log/web"})
+		if err != nil {
+			t.Fatalf("error finding volumes: %v", err)
+		}
+		if len(volumes) != g.expected {
+			t.Errorf("types %v: found %d volumes, expected %d", g.volumeTypes, len(volumes), g.expected)
+		}
+	}
+}
@@ -5,23 +5,84 @@ import (
 	"github.com/golang/glog"
 	"io/ioutil"
 	"kope.io/klogs/pkg/archive"
-	"kope.io/klogs/pkg/archive/s3archive"
-	"net/url"
+	// Register the archive sinks
+	_ "kope.io/klogs/pkg/archive/filearchive"
+	_ "kope.io/klogs/pkg/archive/s3archive"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 type Options struct {
 	PodDir       string
 	ContainerDir string
+	HostLogDir   string
+	HostLogs     []string
+	JournalDir   string
+	CRILogDir    string
 	ArchiveSink  string
 	Listen       string
 	JoinHub      string
 	NodeName     string
+
+	// Sources are the names of the log sources to scrape, see RegisterSource
+	Sources []string
+
+	// PodLogVolumes are globs of the names of pod volumes to collect logs from
+	PodLogVolumes []string
+	// PodLogVolumeTypes are the volume plugins considered for PodLogVolumes, e.g. empty-dir
+	PodLogVolumeTypes []string
+	// PodLogInclude & PodLogExclude are globs filtering the files within pod log volumes
+	PodLogInclude []string
+	PodLogExclude []string
+
+	// HostRoot is where the host filesystem is mounted, for reading the hostPath volumes of pods
+	HostRoot string
+	// KubeletURL is the kubelet API (e.g. https://<node ip>:10250), which we query for the pod specs; empty disables
+	KubeletURL string
+	// KubeletTokenFile holds the bearer token for the kubelet API
+	KubeletTokenFile string
+	// KubeletCAFile verifies the kubelet serving certificate, unless KubeletInsecureTLS is set
+	KubeletCAFile      string
+	KubeletInsecureTLS bool
+
+	// ArchiveQueueDir is where we journal pending archive uploads, so they survive restarts; empty keeps them in memory.
+	// Files waiting to be archived are hard linked into it, so it should be on the same filesystem as the logs.
+	ArchiveQueueDir string
+	// ArchiveConcurrency is the number of concurrent archive uploads
+	ArchiveConcurrency int
+
+	// ContainerLabels are globs of the docker container labels exposed as label.<name> fields
+	ContainerLabels []string
+
+	// RetentionDir is where we keep copies of the logs of terminated containers; empty disables retention
+	RetentionDir string
+	// RetentionMaxBytes & RetentionMaxAge bound the retained logs; zero means no limit
+	RetentionMaxBytes int64
+	RetentionMaxAge   time.Duration
 }
 
 func (o *Options) SetDefaults() {
+	o.Sources = []string{"docker", "pods", "host", "journal", RetainedSourceName}
 	o.PodDir = "/var/lib/kubelet/pods"
+	o.PodLogVolumes = []string{"logs"}
+	o.PodLogVolumeTypes = []string{"empty-dir"}
+	o.HostRoot = "/"
+	o.KubeletTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	o.KubeletCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
 	o.ContainerDir = "/var/lib/docker/containers"
+	o.HostLogDir = "/var/log"
+	o.HostLogs = []string{
+		"kubelet=kubelet.log*",
+		"docker=docker.log*",
+		"syslog=syslog*",
+		"kernel=kern.log*",
+	}
+	o.JournalDir = "/var/log/journal"
+	o.CRILogDir = "/var/log/pods"
+	o.ArchiveConcurrency = 4
+	o.RetentionMaxBytes = 1024 * 1024 * 1024
+	o.RetentionMaxAge = 7 * 24 * time.Hour
 	o.Listen = "http://:7777"
 	o.NodeName = "@/etc/hostname"
 }
@@ -47,21 +108,23 @@ func NewLogShipper(options *Options) (*LogShipper, error) {
 
 	var archiveSink archive.Sink
 	if options.ArchiveSink != "" {
-		u, err := url.Parse(options.ArchiveSink)
+		var err error
+		archiveSink, err = archive.NewSink(options.ArchiveSink)
 		if err != nil {
-			return nil, fmt.Errorf("invalid ArchiveSink %q: %q", options.ArchiveSink, err)
+			return nil, err
 		}
 
-		if u.Scheme == "s3" {
-			archiveSink, err = s3archive.NewSink(u)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, fmt.Errorf("unknown scheme in ArchivePath %q", options.ArchiveSink)
+		archiveSink, err = archive.NewUploadQueue(archiveSink, options.ArchiveQueueDir, options.ArchiveConcurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	nodeState := newNodeState(options.NodeName, archiveSink)
+	if archiveSink != nil && options.ArchiveQueueDir != "" {
+		if err := nodeState.setHoldDir(filepath.Join(options.ArchiveQueueDir, "held")); err != nil {
+			return nil, err
 		}
 	}
-	nodeState := newNodeState(archiveSink)
 
 	logServer, err := newLogServer(options, nodeState)
 	if err != nil {
@@ -0,0 +1,414 @@
+package logspoke
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetainedSourceName is the source for the copies of logs kept by the RetentionStore
+const RetainedSourceName = "retained"
+
+// retentionMetadataFile is the file in each retained stream directory describing the stream
+const retentionMetadataFile = "metadata.json"
+
+// RetentionStore keeps compressed copies of the logs of terminated containers, so they remain searchable
+// after docker garbage-collects the container.  Copies are evicted by age, then oldest-first to stay under
+// the size limit; the metadata of an evicted stream is kept while the container exists, so we do not copy it again.
+// It is also the Source that makes the copies searchable.
+type RetentionStore struct {
+	basedir  string
+	maxBytes int64
+	maxAge   time.Duration
+	state    *NodeState
+
+	mutex sync.Mutex
+}
+
+// retainedStream is the metadata we record for a retained stream
+type retainedStream struct {
+	Source     string           `json:"source"`
+	ID         string           `json:"id"`
+	StreamInfo proto.StreamInfo `json:"streamInfo"`
+	RetainedAt time.Time        `json:"retainedAt"`
+	Files      []*retainedFile  `json:"files"`
+	// Evicted is set once the copies have been removed
+	Evicted bool `json:"evicted,omitempty"`
+}
+
+type retainedFile struct {
+	// Path is the path of the copy, relative to the stream directory
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	// Model is the catalog entry of the original file
+	Model proto.LogFile `json:"model"`
+}
+
+func init() {
+	RegisterSource(RetainedSourceName, func(options *Options, state *NodeState) (Source, error) {
+		store, err := NewRetentionStore(options.RetentionDir, options.RetentionMaxBytes, options.RetentionMaxAge, state)
+		if err != nil {
+			return nil, err
+		}
+		state.retention = store
+		return store, nil
+	})
+}
+
+func NewRetentionStore(basedir string, maxBytes int64, maxAge time.Duration, state *NodeState) (*RetentionStore, error) {
+	if basedir != "" {
+		if err := os.MkdirAll(basedir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating retention directory %q: %v", basedir, err)
+		}
+	}
+
+	s := &RetentionStore{
+		basedir:  basedir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		state:    state,
+	}
+	return s, nil
+}
+
+func (s *RetentionStore) enabled() bool {
+	return s != nil && s.basedir != ""
+}
+
+// streamDir returns the directory for a retained stream
+func (s *RetentionStore) streamDir(source string, id string) string {
+	key := source + "-" + strings.Replace(id, "/", "_", -1)
+	return filepath.Join(s.basedir, key)
+}
+
+// Retain copies the files of the stream into the store; files that were copied before and have not changed are skipped
+func (s *RetentionStore) Retain(stream *StreamState) error {
+	if !s.enabled() {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := s.streamDir(stream.source, stream.id)
+
+	previous, err := readRetainedStream(dir)
+	if err != nil {
+		glog.Warningf("ignoring error reading retained stream %q: %v", dir, err)
+	}
+	if previous != nil && previous.Evicted {
+		glog.V(4).Infof("not retaining evicted stream %q", dir)
+		return nil
+	}
+	previousFiles := make(map[string]*retainedFile)
+	if previous != nil {
+		for _, f := range previous.Files {
+			previousFiles[f.Model.Path] = f
+		}
+	}
+
+	metadata := &retainedStream{
+		Source:     stream.source,
+		ID:         stream.id,
+		RetainedAt: time.Now(),
+	}
+	// Eviction by age counts from when the container terminated, not from the last change we copied
+	if previous != nil {
+		metadata.RetainedAt = previous.RetainedAt
+	}
+	stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		metadata.StreamInfo = *streamInfo
+	})
+	metadata.StreamInfo.Status = proto.StreamStatus_TERMINATED
+
+	changed := false
+	for _, f := range stream.snapshotFiles() {
+		name := formatName(f.format)
+		if name == "" {
+			glog.Warningf("not retaining file %q with unknown format", f.sourcePath)
+			continue
+		}
+
+		retained := previousFiles[f.model.Path]
+		if retained != nil && retained.Model.Size == f.model.Size && retained.Model.LastModified == f.model.LastModified {
+			metadata.Files = append(metadata.Files, retained)
+			continue
+		}
+
+		retained = &retainedFile{
+			Path:   f.model.Path,
+			Format: name,
+			Model:  f.model,
+		}
+		if !strings.HasSuffix(retained.Path, ".gz") {
+			retained.Path += ".gz"
+		}
+		if err := copyCompressed(f.sourcePath, filepath.Join(dir, retained.Path)); err != nil {
+			return fmt.Errorf("error retaining %q: %v", f.sourcePath, err)
+		}
+		metadata.Files = append(metadata.Files, retained)
+		changed = true
+	}
+
+	if previous != nil && !changed {
+		return nil
+	}
+
+	glog.V(2).Infof("retained %d files for %s %q", len(metadata.Files), stream.source, stream.id)
+	if err := writeRetainedStream(dir, metadata); err != nil {
+		return err
+	}
+
+	return s.evict()
+}
+
+// copyCompressed copies src to dest (via a temporary file), gzipping it unless it is already compressed
+func copyCompressed(src string, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating directory for %q: %v", dest, err)
+	}
+
+	in, err := os.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if strings.HasSuffix(src, ".gz") {
+		_, err = io.Copy(out, in)
+	} else {
+		gz := gzip.NewWriter(out)
+		_, err = io.Copy(gz, in)
+		if err == nil {
+			err = gz.Close()
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %q: %v", tmp, err)
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+func readRetainedStream(dir string) (*retainedStream, error) {
+	p := filepath.Join(dir, retentionMetadataFile)
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	metadata := &retainedStream{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", p, err)
+	}
+	return metadata, nil
+}
+
+func writeRetainedStream(dir string, metadata *retainedStream) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error serializing retained stream metadata: %v", err)
+	}
+
+	p := filepath.Join(dir, retentionMetadataFile)
+	if err := ioutil.WriteFile(p+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("error writing %q: %v", p, err)
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// Scan makes the retained streams searchable, once the original stream has gone from the catalog
+func (s *RetentionStore) Scan() error {
+	if !s.enabled() {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.evict(); err != nil {
+		glog.Warningf("error evicting retained logs: %v", err)
+	}
+
+	dirs, err := ioutil.ReadDir(s.basedir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %q: %v", s.basedir, err)
+	}
+
+	var ids []string
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(s.basedir, d.Name())
+		metadata, err := readRetainedStream(dir)
+		if err != nil || metadata == nil {
+			glog.Warningf("ignoring retained stream %q: %v", dir, err)
+			continue
+		}
+		if metadata.Evicted {
+			continue
+		}
+
+		// While the original is still in the catalog, it is searchable there
+		if s.state.hasStream(metadata.Source, metadata.ID) {
+			continue
+		}
+
+		ids = append(ids, d.Name())
+		stream := s.state.GetStream(RetainedSourceName, d.Name())
+		stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+			*streamInfo = metadata.StreamInfo
+			streamInfo.Host = s.state.host
+		})
+
+		fileMap := make(map[string]struct{})
+		for _, f := range metadata.Files {
+			p := filepath.Join(dir, f.Path)
+			stat, err := os.Stat(p)
+			if err != nil {
+				glog.Warningf("ignoring retained file %q: %v", p, err)
+				continue
+			}
+			format := logFormats[f.Format]
+			if format == nil {
+				glog.Warningf("ignoring retained file %q with unknown format %q", p, f.Format)
+				continue
+			}
+			fileMap[p] = struct{}{}
+			stream.foundFile(p, f.Model.Path, stat, f.Model.Fields, format)
+		}
+		stream.retainFiles(fileMap)
+	}
+
+	s.state.CleanupStreams(RetainedSourceName, ids)
+
+	return nil
+}
+
+// evict removes retained streams older than maxAge, and then the oldest streams until we are under maxBytes
+func (s *RetentionStore) evict() error {
+	dirs, err := ioutil.ReadDir(s.basedir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %q: %v", s.basedir, err)
+	}
+
+	type retained struct {
+		dir        string
+		size       int64
+		retainedAt time.Time
+		metadata   *retainedStream
+	}
+
+	var streams []*retained
+	var totalBytes int64
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		r := &retained{dir: filepath.Join(s.basedir, d.Name())}
+
+		metadata, err := readRetainedStream(r.dir)
+		if err == nil && metadata != nil && metadata.Evicted {
+			// Once the container has gone we will not be asked to retain it again
+			if !s.state.hasStream(metadata.Source, metadata.ID) {
+				if err := os.RemoveAll(r.dir); err != nil {
+					return fmt.Errorf("error removing %q: %v", r.dir, err)
+				}
+			}
+			continue
+		}
+		r.metadata = metadata
+		if err == nil && metadata != nil {
+			r.retainedAt = metadata.RetainedAt
+		} else {
+			// Incomplete copy; treat as oldest
+			r.retainedAt = d.ModTime()
+		}
+
+		filepath.Walk(r.dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				r.size += info.Size()
+			}
+			return nil
+		})
+		totalBytes += r.size
+		streams = append(streams, r)
+	}
+
+	sort.Slice(streams, func(i, j int) bool { return streams[i].retainedAt.Before(streams[j].retainedAt) })
+
+	now := time.Now()
+	for _, r := range streams {
+		expired := s.maxAge != 0 && r.retainedAt.Add(s.maxAge).Before(now)
+		overSize := s.maxBytes != 0 && totalBytes > s.maxBytes
+		if !expired && !overSize {
+			continue
+		}
+
+		glog.Infof("evicting retained logs %q (size=%d, retained at %v)", r.dir, r.size, r.retainedAt)
+		if err := s.evictStream(r.dir, r.metadata); err != nil {
+			return err
+		}
+		totalBytes -= r.size
+	}
+
+	return nil
+}
+
+// evictStream removes the copies of a retained stream.  While the original is still in the catalog we keep its
+// metadata, marked as evicted, so Retain does not copy it again.
+func (s *RetentionStore) evictStream(dir string, metadata *retainedStream) error {
+	if metadata == nil || !s.state.hasStream(metadata.Source, metadata.ID) {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("error removing %q: %v", dir, err)
+		}
+		return nil
+	}
+
+	evicted := *metadata
+	evicted.Evicted = true
+	evicted.Files = nil
+	if err := writeRetainedStream(dir, &evicted); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %q: %v", dir, err)
+	}
+	for _, f := range files {
+		if f.Name() == retentionMetadataFile {
+			continue
+		}
+		p := filepath.Join(dir, f.Name())
+		if err := os.RemoveAll(p); err != nil {
+			return fmt.Errorf("error removing %q: %v", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package logspoke
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"kope.io/klogs/pkg/proto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const retainedLine = `{"log":"hello from a terminated container\n","stream":"stdout","time":"2017-01-02T03:04:05.000000000Z"}` + "\n"
+
+func newTestRetentionStore(t *testing.T, maxBytes int64, maxAge time.Duration) (string, *NodeState, *RetentionStore) {
+	dir, err := ioutil.TempDir("", "retention")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	state := newNodeState("node-1", nil)
+	store, err := NewRetentionStore(filepath.Join(dir, "retained"), maxBytes, maxAge, state)
+	if err != nil {
+		t.Fatalf("error building retention store: %v", err)
+	}
+	state.retention = store
+	return dir, state, store
+}
+
+// addContainerLog writes the log file of a terminated container and adds it to the catalog
+func addContainerLog(t *testing.T, state *NodeState, dir string, containerID string, contents string) *StreamState {
+	p := filepath.Join(dir, "containers", containerID, containerID+"-json.log")
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("error creating directory for %q: %v", p, err)
+	}
+	if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+		t.Fatalf("error writing %q: %v", p, err)
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("error doing stat on %q: %v", p, err)
+	}
+
+	stream := state.GetStream("containers", containerID)
+	stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.PodName = "web-0"
+		streamInfo.ContainerId = containerID
+	})
+	stream.foundFile(p, containerID+"-json.log", stat, &proto.Fields{}, DockerJSONFormat)
+	return stream
+}
+
+func readRetainedCopy(t *testing.T, p string) string {
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatalf("error opening %q: %v", p, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("error reading %q: %v", p, err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("error reading %q: %v", p, err)
+	}
+	return string(data)
+}
+
+func TestRetain(t *testing.T) {
+	dir, state, store := newTestRetentionStore(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	stream := addContainerLog(t, state, dir, "c1", retainedLine)
+	if err := store.Retain(stream); err != nil {
+		t.Fatalf("error retaining: %v", err)
+	}
+
+	streamDir := store.streamDir("containers", "c1")
+	first, err := readRetainedStream(streamDir)
+	if err != nil || first == nil {
+		t.Fatalf("error reading retained stream: %v", err)
+	}
+	if len(first.Files) != 1 || first.Files[0].Format != "docker-json" || first.StreamInfo.Status != proto.StreamStatus_TERMINATED {
+		t.Fatalf("unexpected retained stream %+v", first)
+	}
+	copyPath := filepath.Join(streamDir, first.Files[0].Path)
+	if actual := readRetainedCopy(t, copyPath); actual != retainedLine {
+		t.Errorf("retained copy was %q, expected %q", actual, retainedLine)
+	}
+
+	// The file changes after we first copied it: we copy it again, but it was retained when we first copied it
+	time.Sleep(10 * time.Millisecond)
+	addContainerLog(t, state, dir, "c1", retainedLine+retainedLine)
+	if err := store.Retain(stream); err != nil {
+		t.Fatalf("error retaining: %v", err)
+	}
+	second, err := readRetainedStream(streamDir)
+	if err != nil || second == nil {
+		t.Fatalf("error reading retained stream: %v", err)
+	}
+	if actual := readRetainedCopy(t, copyPath); actual != retainedLine+retainedLine {
+		t.Errorf("retained copy was %q after the file changed", actual)
+	}
+	if !second.RetainedAt.Equal(first.RetainedAt) {
+		t.Errorf("RetainedAt changed from %v to %v", first.RetainedAt, second.RetainedAt)
+	}
+}
+
+func TestRetentionEvictByAge(t *testing.T) {
+	dir, state, store := newTestRetentionStore(t, 0, time.Hour)
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"c1", "c2"} {
+		if err := store.Retain(addContainerLog(t, state, dir, id, retainedLine)); err != nil {
+			t.Fatalf("error retaining %s: %v", id, err)
+		}
+	}
+
+	// c1 terminated long ago
+	oldDir := store.streamDir("containers", "c1")
+	metadata, err := readRetainedStream(oldDir)
+	if err != nil || metadata == nil {
+		t.Fatalf("error reading retained stream: %v", err)
+	}
+	metadata.RetainedAt = time.Now().Add(-2 * time.Hour)
+	if err := writeRetainedStream(oldDir, metadata); err != nil {
+		t.Fatalf("error writing retained stream: %v", err)
+	}
+	copyPath := filepath.Join(oldDir, metadata.Files[0].Path)
+
+	if err := store.evict(); err != nil {
+		t.Fatalf("error evicting: %v", err)
+	}
+	if _, err := os.Stat(copyPath); !os.IsNotExist(err) {
+		t.Errorf("expired copy was not evicted: %v", err)
+	}
+	if evicted, _ := readRetainedStream(store.streamDir("containers", "c2")); evicted == nil || evicted.Evicted {
+		t.Errorf("recent copy was evicted")
+	}
+
+	// The container is still on the node, so the next scan retains it again; we must not copy it back
+	if err := store.Retain(state.GetStream("containers", "c1")); err != nil {
+		t.Fatalf("error retaining: %v", err)
+	}
+	if _, err := os.Stat(copyPath); !os.IsNotExist(err) {
+		t.Errorf("evicted stream was copied again: %v", err)
+	}
+
+	// Once docker removes the container, we forget the stream
+	state.CleanupStreams("containers", []string{"c2"})
+	if err := store.evict(); err != nil {
+		t.Fatalf("error evicting: %v", err)
+	}
+	if _, err := os.Stat(oldDir); !os.IsNotExist(err) {
+		t.Errorf("evicted stream of removed container was kept: %v", err)
+	}
+}
+
+func TestRetentionEvictBySize(t *testing.T) {
+	dir, state, store := newTestRetentionStore(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	if err := store.Retain(addContainerLog(t, state, dir, "c1", retainedLine)); err != nil {
+		t.Fatalf("error retaining: %v", err)
+	}
+	var size int64
+	filepath.Walk(store.streamDir("containers", "c1"), func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	// Room for one copy: retaining the second evicts the oldest
+	store.maxBytes = size * 3 / 2
+	time.Sleep(10 * time.Millisecond)
+	if err := store.Retain(addContainerLog(t, state, dir, "c2", retainedLine)); err != nil {
+		t.Fatalf("error retaining: %v", err)
+	}
+
+	for _, g := range []struct {
+		id      string
+		evicted bool
+	}{
+		{id: "c1", evicted: true},
+		{id: "c2", evicted: false},
+	} {
+		metadata, err := readRetainedStream(store.streamDir("containers", g.id))
+		if err != nil || metadata == nil {
+			t.Fatalf("error reading retained stream %s: %v", g.id, err)
+		}
+		if metadata.Evicted != g.evicted {
+			t.Errorf("%s: evicted was %v, expected %v", g.id, metadata.Evicted, g.evicted)
+		}
+	}
+}
+
+func TestRetainedSource(t *testing.T) {
+	dir, state, store := newTestRetentionStore(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	if err := store.Retain(addContainerLog(t, state, dir, "c1", retainedLine)); err != nil {
+		t.Fatalf("error retaining: %v", err)
+	}
+	retainedID := filepath.Base(store.streamDir("containers", "c1"))
+
+	// While the container is in the catalog, it is searched there
+	if err := store.Scan(); err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+	if state.hasStream(RetainedSourceName, retainedID) {
+		t.Errorf("retained stream was added while the original is in the catalog")
+	}
+
+	// docker garbage-collects the container
+	state.CleanupStreams("containers", nil)
+	if err := store.Scan(); err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+	if !state.hasStream(RetainedSourceName, retainedID) {
+		t.Fatalf("retained stream was not added once the original was removed")
+	}
+	streamInfo := state.GetStream(RetainedSourceName, retainedID).buildStreamInfo()
+	if streamInfo.PodName != "web-0" || streamInfo.ContainerId != "c1" || streamInfo.Host != "node-1" || streamInfo.Status != proto.StreamStatus_TERMINATED {
+		t.Errorf("unexpected retained stream info %v", streamInfo)
+	}
+
+	out := &fakeSearchServer{}
+	if err := state.Search(&proto.SearchRequest{Contains: "terminated container"}, out); err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	results := out.results()
+	if len(results) != 1 || !strings.Contains(string(results[0].Raw), "hello from a terminated container") {
+		t.Errorf("search of retained logs returned %v", results)
+	}
+}
@@ -6,36 +6,32 @@ import (
 )
 
 type Scraper struct {
-	pods       *PodsDirectory
-	containers *ContainersDirectory
+	state   *NodeState
+	sources map[string]Source
+	names   []string
 }
 
 func newScraper(options *Options, nodeState *NodeState) (*Scraper, error) {
-	scraper := &Scraper{}
-
-	pods, err := NewPodsDirectory(options.PodDir, nodeState)
+	sources, err := buildSources(options, nodeState)
 	if err != nil {
 		return nil, err
 	}
-	scraper.pods = pods
 
-	containers, err := NewContainerLogsDirectory(options.ContainerDir, nodeState)
-	if err != nil {
-		return nil, err
+	scraper := &Scraper{
+		state:   nodeState,
+		sources: sources,
+		names:   options.Sources,
 	}
-	scraper.containers = containers
-
 	return scraper, nil
 }
 
 func (s *Scraper) Run() error {
 	for {
-		if err := s.pods.Scan(); err != nil {
-			glog.Warningf("error scanning pods directory: %v", err)
-		}
-
-		if err := s.containers.Scan(); err != nil {
-			glog.Warningf("error scanning containers directory: %v", err)
+		for _, name := range s.names {
+			if err := s.sources[name].Scan(); err != nil {
+				glog.Warningf("error scanning %s logs: %v", name, err)
+			}
+			s.state.publishChanges()
 		}
 
 		time.Sleep(time.Minute)
@@ -0,0 +1,65 @@
+package logspoke
+
+import (
+	"fmt"
+	"kope.io/klogs/pkg/logsearch"
+	"sort"
+)
+
+// Source is a kind of log on the node: docker containers, pod volumes, host log files etc.
+// A source discovers streams and their files, and records them in the NodeState catalog.
+type Source interface {
+	// Scan discovers the current streams and files, updating the catalog
+	Scan() error
+}
+
+// LogFormat reads files of a particular on-disk format; each file in the catalog records its format.
+type LogFormat interface {
+	// Timestamps returns the min and max timestamps of the entries in the file
+	Timestamps(sourcePath string) (uint64, uint64, error)
+
+	// Search sends the entries in the file that match the operation
+	Search(op *fileScanOperation, search *logsearch.Search) error
+}
+
+// SourceFactory builds a source from the spoke options
+type SourceFactory func(options *Options, state *NodeState) (Source, error)
+
+var sourceFactories = make(map[string]SourceFactory)
+
+// RegisterSource makes a source available for selection in Options.Sources
+func RegisterSource(name string, factory SourceFactory) {
+	if _, found := sourceFactories[name]; found {
+		panic(fmt.Sprintf("source %q registered twice", name))
+	}
+	sourceFactories[name] = factory
+}
+
+// SourceNames returns the names of all registered sources
+func SourceNames() []string {
+	var names []string
+	for k := range sourceFactories {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func buildSources(options *Options, state *NodeState) (map[string]Source, error) {
+	sources := make(map[string]Source)
+	for _, name := range options.Sources {
+		if sources[name] != nil {
+			return nil, fmt.Errorf("source %q specified twice", name)
+		}
+		factory := sourceFactories[name]
+		if factory == nil {
+			return nil, fmt.Errorf("unknown source %q (known sources: %v)", name, SourceNames())
+		}
+		source, err := factory(options, state)
+		if err != nil {
+			return nil, fmt.Errorf("error building source %q: %v", name, err)
+		}
+		sources[name] = source
+	}
+	return sources, nil
+}
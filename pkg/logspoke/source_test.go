@@ -0,0 +1,120 @@
+package logspoke
+
+import (
+	"fmt"
+	"kope.io/klogs/pkg/proto"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeSource adds a stream to the catalog on each scan
+type fakeSource struct {
+	name  string
+	state *NodeState
+}
+
+func (s *fakeSource) Scan() error {
+	stream := s.state.GetStream(s.name, "stream-1")
+	stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.ContainerName = s.name
+	})
+	return nil
+}
+
+// registerTestSource registers a source for the duration of a test, returning a func that removes it
+func registerTestSource(name string, factory SourceFactory) func() {
+	RegisterSource(name, factory)
+	return func() {
+		delete(sourceFactories, name)
+	}
+}
+
+func TestBuildSources(t *testing.T) {
+	defer registerTestSource("test-a", func(options *Options, state *NodeState) (Source, error) {
+		return &fakeSource{name: "test-a", state: state}, nil
+	})()
+	defer registerTestSource("test-b", func(options *Options, state *NodeState) (Source, error) {
+		return &fakeSource{name: "test-b", state: state}, nil
+	})()
+	defer registerTestSource("test-broken", func(options *Options, state *NodeState) (Source, error) {
+		return nil, fmt.Errorf("no such directory")
+	})()
+
+	grid := []struct {
+		sources  []string
+		expected []string
+		err      string
+	}{
+		{sources: []string{"test-a", "test-b"}, expected: []string{"test-a", "test-b"}},
+		{sources: []string{"test-b"}, expected: []string{"test-b"}},
+		{sources: nil, expected: []string{}},
+		{sources: []string{"test-a", "test-a"}, err: `source "test-a" specified twice`},
+		{sources: []string{"test-c"}, err: `unknown source "test-c" (known sources: [`},
+		{sources: []string{"test-a", "test-broken"}, err: `error building source "test-broken": no such directory`},
+	}
+	for _, g := range grid {
+		state := newNodeState("node-1", nil)
+		scraper, err := newScraper(&Options{Sources: g.sources}, state)
+		if g.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), g.err) {
+				t.Errorf("newScraper(%v) error was %v, expected %q", g.sources, err, g.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newScraper(%v) returned unexpected error: %v", g.sources, err)
+			continue
+		}
+
+		// Each source records its streams in the shared catalog, under the name of the source
+		for _, name := range scraper.names {
+			if err := scraper.sources[name].Scan(); err != nil {
+				t.Errorf("error scanning %s: %v", name, err)
+			}
+		}
+		actual := []string{}
+		for _, stream := range state.snapshotStreams() {
+			stream.mutex.Lock()
+			actual = append(actual, stream.streamInfo.ContainerName)
+			stream.mutex.Unlock()
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, g.expected) {
+			t.Errorf("newScraper(%v) found streams from %v, expected %v", g.sources, actual, g.expected)
+		}
+	}
+}
+
+func TestDefaultSourcesRegistered(t *testing.T) {
+	options := &Options{}
+	options.SetDefaults()
+	for _, name := range options.Sources {
+		if sourceFactories[name] == nil {
+			t.Errorf("default source %q is not registered (known sources: %v)", name, SourceNames())
+		}
+	}
+	for _, name := range []string{"cri", "docker", "host", "journal", "pods", RetainedSourceName} {
+		found := false
+		for _, known := range SourceNames() {
+			if known == name {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("source %q not in SourceNames() %v", name, SourceNames())
+		}
+	}
+}
+
+func TestRegisterSourceTwice(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("registering a source twice did not panic")
+		}
+	}()
+	RegisterSource("docker", func(options *Options, state *NodeState) (Source, error) {
+		return nil, nil
+	})
+}
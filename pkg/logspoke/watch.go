@@ -0,0 +1,131 @@
+package logspoke
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"kope.io/klogs/pkg/proto"
+)
+
+// watchBufferSize is the number of events we queue for a watcher; a watcher that falls further behind is closed
+const watchBufferSize = 1024
+
+// streamWatcher is a WatchStreams call in progress
+type streamWatcher struct {
+	request *proto.WatchStreamsRequest
+	events  chan *proto.StreamEvent
+	// overflowed is set (under the watch mutex) if the watcher was closed because it fell behind
+	overflowed bool
+}
+
+func (w *streamWatcher) matches(streamInfo *proto.StreamInfo) bool {
+	return matchStreamFilters(streamInfo, w.request.FieldFilters)
+}
+
+// publishChanges compares the streams with the state last sent to watchers, and sends events for the differences.
+// The scraper calls it after scanning each source.
+func (s *NodeState) publishChanges() {
+	current := make(map[string]*proto.StreamInfo)
+	for _, stream := range s.snapshotStreams() {
+		current[stream.source+"/"+stream.id] = stream.buildStreamInfo()
+	}
+
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	var events []*proto.StreamEvent
+	for k, streamInfo := range current {
+		previous := s.published[k]
+		if previous == nil {
+			events = append(events, &proto.StreamEvent{Type: proto.StreamEventType_ADDED, Stream: streamInfo})
+		} else if *previous != *streamInfo {
+			events = append(events, &proto.StreamEvent{Type: proto.StreamEventType_UPDATED, Stream: streamInfo})
+		}
+	}
+	for k, streamInfo := range s.published {
+		if current[k] == nil {
+			events = append(events, &proto.StreamEvent{Type: proto.StreamEventType_REMOVED, Stream: streamInfo})
+		}
+	}
+	s.published = current
+
+	if len(events) == 0 {
+		return
+	}
+	glog.V(2).Infof("publishing %d stream events to %d watchers", len(events), len(s.watchers))
+
+	for w := range s.watchers {
+		for _, event := range events {
+			if !w.matches(event.Stream) {
+				continue
+			}
+			select {
+			case w.events <- event:
+			default:
+				glog.Warningf("closing stream watcher that fell behind")
+				w.overflowed = true
+				close(w.events)
+				delete(s.watchers, w)
+			}
+			if w.overflowed {
+				break
+			}
+		}
+	}
+}
+
+// addWatcher registers a watcher, queueing ADDED events for the current streams
+func (s *NodeState) addWatcher(request *proto.WatchStreamsRequest) *streamWatcher {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	w := &streamWatcher{
+		request: request,
+		events:  make(chan *proto.StreamEvent, watchBufferSize+len(s.published)),
+	}
+	for _, streamInfo := range s.published {
+		if w.matches(streamInfo) {
+			w.events <- &proto.StreamEvent{Type: proto.StreamEventType_ADDED, Stream: streamInfo}
+		}
+	}
+	s.watchers[w] = struct{}{}
+	return w
+}
+
+func (s *NodeState) removeWatcher(w *streamWatcher) {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	if _, found := s.watchers[w]; found {
+		delete(s.watchers, w)
+		close(w.events)
+	}
+}
+
+func (s *NodeState) WatchStreams(request *proto.WatchStreamsRequest, out proto.LogServer_WatchStreamsServer) error {
+	glog.V(2).Infof("WatchStreams %q", request)
+
+	if request.Host != "" && request.Host != s.host {
+		// Not us; wait for the client to go away
+		<-out.Context().Done()
+		return nil
+	}
+
+	w := s.addWatcher(request)
+	defer s.removeWatcher(w)
+
+	ctx := out.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-w.events:
+			if !ok {
+				return fmt.Errorf("watch closed because the client fell behind")
+			}
+			if err := out.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package logspoke
+
+import (
+	"golang.org/x/net/context"
+	"kope.io/klogs/pkg/proto"
+	"testing"
+	"time"
+)
+
+// fakeWatchServer delivers the events sent to the watcher
+type fakeWatchServer struct {
+	proto.LogServer_WatchStreamsServer
+	ctx    context.Context
+	events chan *proto.StreamEvent
+}
+
+func (f *fakeWatchServer) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeWatchServer) Send(event *proto.StreamEvent) error {
+	f.events <- event
+	return nil
+}
+
+func (f *fakeWatchServer) expectEvent(t *testing.T, eventType proto.StreamEventType, containerID string) *proto.StreamEvent {
+	select {
+	case event := <-f.events:
+		if event.Type != eventType || event.Stream.ContainerId != containerID {
+			t.Fatalf("event was %v for %q, expected %v for %q", event.Type, event.Stream.ContainerId, eventType, containerID)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %v event for %q", eventType, containerID)
+	}
+	return nil
+}
+
+func (f *fakeWatchServer) expectNoEvent(t *testing.T) {
+	select {
+	case event := <-f.events:
+		t.Fatalf("unexpected %v event for %q", event.Type, event.Stream.ContainerId)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func addContainerStream(state *NodeState, containerID string, namespace string) *StreamState {
+	stream := state.GetStream("containers", containerID)
+	stream.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.ContainerId = containerID
+		streamInfo.PodNamespace = namespace
+	})
+	return stream
+}
+
+func TestWatchStreams(t *testing.T) {
+	state := newNodeState("node-1", nil)
+	c1 := addContainerStream(state, "c1", "shop")
+	state.publishChanges()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &fakeWatchServer{ctx: ctx, events: make(chan *proto.StreamEvent, 10)}
+	request := &proto.WatchStreamsRequest{
+		FieldFilters: []*proto.FieldFilter{{Key: "pod.namespace", Op: proto.FieldFilterOperator_EQ, Value: "shop"}},
+	}
+	done := make(chan error)
+	go func() {
+		done <- state.WatchStreams(request, out)
+	}()
+
+	// The streams that exist when we start watching are sent as ADDED
+	out.expectEvent(t, proto.StreamEventType_ADDED, "c1")
+
+	addContainerStream(state, "c2", "shop")
+	state.publishChanges()
+	out.expectEvent(t, proto.StreamEventType_ADDED, "c2")
+
+	c1.UpdateStreamInfo(func(streamInfo *proto.StreamInfo) {
+		streamInfo.PodName = "web-0"
+	})
+	state.publishChanges()
+	if event := out.expectEvent(t, proto.StreamEventType_UPDATED, "c1"); event.Stream.PodName != "web-0" {
+		t.Errorf("updated stream had pod name %q", event.Stream.PodName)
+	}
+
+	// Nothing changed
+	state.publishChanges()
+	out.expectNoEvent(t)
+
+	// Streams not matching the filters are not sent
+	addContainerStream(state, "c3", "kube-system")
+	state.publishChanges()
+	out.expectNoEvent(t)
+
+	state.CleanupStreams("containers", []string{"c2", "c3"})
+	state.publishChanges()
+	out.expectEvent(t, proto.StreamEventType_REMOVED, "c1")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error from WatchStreams: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WatchStreams did not return when the watch was cancelled")
+	}
+	if len(state.watchers) != 0 {
+		t.Errorf("watcher was not removed")
+	}
+}
+
+func TestWatchStreamsOtherHost(t *testing.T) {
+	state := newNodeState("node-1", nil)
+	addContainerStream(state, "c1", "shop")
+	state.publishChanges()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &fakeWatchServer{ctx: ctx, events: make(chan *proto.StreamEvent, 10)}
+	done := make(chan error)
+	go func() {
+		done <- state.WatchStreams(&proto.WatchStreamsRequest{Host: "node-2"}, out)
+	}()
+
+	addContainerStream(state, "c2", "shop")
+	state.publishChanges()
+	out.expectNoEvent(t)
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error from WatchStreams: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WatchStreams did not return when the watch was cancelled")
+	}
+}
@@ -3,6 +3,7 @@ package mesh
 import (
 	"fmt"
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"kope.io/klogs/pkg/proto"
 	"net/url"
@@ -10,12 +11,44 @@ import (
 	"time"
 )
 
+// Member liveness: spokes heartbeat by re-joining the mesh every 10 seconds, and we probe each member with the
+// Health RPC.  A member is suspect once a probe fails or we have not heard from it for suspectAfter, and dead once
+// deadProbeFailures probes in a row have failed or we have not heard from it for deadAfter.  Members that have not
+// sent a heartbeat for expireAfter are removed from the mesh.
+var (
+	probeInterval     = 5 * time.Second
+	probeTimeout      = 5 * time.Second
+	suspectAfter      = 30 * time.Second
+	deadAfter         = 2 * time.Minute
+	deadProbeFailures = 3
+	expireAfter       = 10 * time.Minute
+)
+
 type Member struct {
-	id       string
-	hostInfo proto.HostInfo
+	id string
 
 	mutex      sync.Mutex
+	hostInfo   proto.HostInfo
+	conn       *grpc.ClientConn
 	logsClient proto.LogServerClient
+
+	// lastHeartbeat is when the member last joined the mesh
+	lastHeartbeat time.Time
+	// lastProbe is when a health probe last succeeded
+	lastProbe time.Time
+	// probeFailures is the number of consecutive failed probes, and probeError the error from the last
+	probeFailures int
+	probeError    error
+
+	// stop is closed when the member is removed from the mesh
+	stop chan struct{}
+}
+
+func newMember(id string) *Member {
+	return &Member{
+		id:   id,
+		stop: make(chan struct{}),
+	}
 }
 
 func (h *Member) run() {
@@ -24,7 +57,12 @@ func (h *Member) run() {
 		if err != nil {
 			glog.Warningf("error polling host %q: %v", h.id, err)
 		}
-		time.Sleep(5 * time.Second)
+
+		select {
+		case <-h.stop:
+			return
+		case <-time.After(probeInterval):
+		}
 	}
 }
 
@@ -33,19 +71,132 @@ func (h *Member) update(request *proto.JoinMeshRequest) {
 	defer h.mutex.Unlock()
 
 	if h.hostInfo.Url != request.HostInfo.Url {
-		h.logsClient = nil
+		h.closeConnection()
 	}
 	h.hostInfo = *request.HostInfo
+	h.lastHeartbeat = time.Now()
 }
 
+// runOnce probes the health of the member
 func (h *Member) runOnce() error {
+	client, err := h.LogsClient()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		_, err = client.Health(ctx, &proto.HealthRequest{})
+		cancel()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err != nil {
+		h.probeFailures++
+		h.probeError = err
+		return fmt.Errorf("health probe failed: %v", err)
+	}
+	h.probeFailures = 0
+	h.probeError = nil
+	h.lastProbe = time.Now()
 	return nil
 }
 
+// lastSeen returns when we last heard from the member.  Must be called with the mutex held.
+func (h *Member) lastSeen() time.Time {
+	if h.lastProbe.After(h.lastHeartbeat) {
+		return h.lastProbe
+	}
+	return h.lastHeartbeat
+}
+
+// State returns the liveness of the member
+func (h *Member) State() proto.MemberState {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.state(time.Now())
+}
+
+func (h *Member) state(now time.Time) proto.MemberState {
+	silent := now.Sub(h.lastSeen())
+	switch {
+	case h.probeFailures >= deadProbeFailures || silent >= deadAfter:
+		return proto.MemberState_DEAD
+	case h.probeFailures != 0 || silent >= suspectAfter:
+		return proto.MemberState_SUSPECT
+	default:
+		return proto.MemberState_HEALTHY
+	}
+}
+
+// Health reports the liveness of the member
+func (h *Member) Health() *proto.MemberHealth {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	health := &proto.MemberHealth{
+		Id:       h.id,
+		State:    h.state(time.Now()),
+		LastSeen: h.lastSeen().Unix(),
+	}
+	if h.probeError != nil {
+		health.Error = h.probeError.Error()
+	}
+	return health
+}
+
+// expired returns true if the member has stopped sending heartbeats
+func (h *Member) expired(now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return now.Sub(h.lastHeartbeat) >= expireAfter
+}
+
+// remove stops probing the member and closes our connection
+func (h *Member) remove() {
+	close(h.stop)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closeConnection()
+}
+
+// Removed returns a channel that is closed when the member is removed from the mesh
+func (h *Member) Removed() <-chan struct{} {
+	return h.stop
+}
+
+// isRemoved returns true if the member has been removed from the mesh
+func (h *Member) isRemoved() bool {
+	select {
+	case <-h.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeConnection closes the connection to the member.  Must be called with the mutex held.
+func (h *Member) closeConnection() {
+	if h.conn != nil {
+		if err := h.conn.Close(); err != nil {
+			glog.Warningf("error closing connection to %q: %v", h.id, err)
+		}
+	}
+	h.conn = nil
+	h.logsClient = nil
+}
+
 func (h *Member) LogsClient() (proto.LogServerClient, error) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
+	// remove closes our connection, so we must not open another one
+	if h.isRemoved() {
+		return nil, fmt.Errorf("member %q has been removed from the mesh", h.id)
+	}
+
 	client := h.logsClient
 
 	if client == nil {
@@ -61,6 +212,7 @@ func (h *Member) LogsClient() (proto.LogServerClient, error) {
 			return nil, fmt.Errorf("failed to connect to mesh client: %v", err)
 		}
 		client = proto.NewLogServerClient(conn)
+		h.conn = conn
 		h.logsClient = client
 	}
 
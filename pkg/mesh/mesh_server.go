@@ -7,6 +7,7 @@ import (
 	"kope.io/klogs/pkg/grpc"
 	"kope.io/klogs/pkg/proto"
 	"sync"
+	"time"
 )
 
 type Server struct {
@@ -35,9 +36,33 @@ func NewServer(options *grpc.GRPCOptions) (*Server, error) {
 }
 
 func (s *Server) ListenAndServe() error {
+	go s.runExpiry()
+
 	return s.grpc.ListenAndServe()
 }
 
+// runExpiry removes the members that have stopped sending heartbeats, e.g. nodes that were scaled away
+func (s *Server) runExpiry() {
+	for {
+		time.Sleep(probeInterval)
+		s.ExpireMembers(time.Now())
+	}
+}
+
+// ExpireMembers removes the members that have not sent a heartbeat for expireAfter, as of now
+func (s *Server) ExpireMembers(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, m := range s.members {
+		if m.expired(now) {
+			glog.Infof("Removing expired mesh member %q", id)
+			delete(s.members, id)
+			m.remove()
+		}
+	}
+}
+
 func (s *Server) JoinMesh(context context.Context, request *proto.JoinMeshRequest) (*proto.JoinMeshResponse, error) {
 	glog.Infof("JoinMesh %s", request)
 
@@ -55,7 +80,7 @@ func (s *Server) JoinMesh(context context.Context, request *proto.JoinMeshReques
 	h := s.members[id]
 	isNew := false
 	if h == nil {
-		h = &Member{id: id}
+		h = newMember(id)
 		s.members[id] = h
 		isNew = true
 	}
@@ -68,7 +93,7 @@ func (s *Server) JoinMesh(context context.Context, request *proto.JoinMeshReques
 	return response, nil
 }
 
-// Hosts returns a snapshot of the hosts
+// Members returns a snapshot of the members
 func (s *Server) Members() []*Member {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -9,8 +9,13 @@ It is generated from these files:
 	log.proto
 
 It has these top-level messages:
+	HealthRequest
+	HealthResponse
+	MemberHealth
 	GetStreamsRequest
 	StreamInfo
+	WatchStreamsRequest
+	StreamEvent
 	SearchRequest
 	FieldFilter
 	Fields
@@ -18,6 +23,15 @@ It has these top-level messages:
 	SearchResultChunk
 	SearchResult
 	LogFile
+	ListArchiveRequest
+	ReadArchiveRequest
+	ArchiveData
+	PruneArchiveRequest
+	PruneArchiveResponse
+	VerifyArchiveRequest
+	VerifyArchiveResponse
+	ArchiveProblem
+	ArchivedFile
 	HostInfo
 	JoinMeshRequest
 	JoinMeshResponse
@@ -44,6 +58,79 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto1.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type MemberState int32
+
+const (
+	MemberState_HEALTHY MemberState = 0
+	MemberState_SUSPECT MemberState = 1
+	MemberState_DEAD    MemberState = 2
+)
+
+var MemberState_name = map[int32]string{
+	0: "HEALTHY",
+	1: "SUSPECT",
+	2: "DEAD",
+}
+var MemberState_value = map[string]int32{
+	"HEALTHY": 0,
+	"SUSPECT": 1,
+	"DEAD":    2,
+}
+
+func (x MemberState) String() string {
+	return proto1.EnumName(MemberState_name, int32(x))
+}
+func (MemberState) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+// StreamStatus is whether the writer of a stream (e.g. the container) is still running
+type StreamStatus int32
+
+const (
+	StreamStatus_UNKNOWN    StreamStatus = 0
+	StreamStatus_RUNNING    StreamStatus = 1
+	StreamStatus_TERMINATED StreamStatus = 2
+)
+
+var StreamStatus_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "RUNNING",
+	2: "TERMINATED",
+}
+var StreamStatus_value = map[string]int32{
+	"UNKNOWN":    0,
+	"RUNNING":    1,
+	"TERMINATED": 2,
+}
+
+func (x StreamStatus) String() string {
+	return proto1.EnumName(StreamStatus_name, int32(x))
+}
+func (StreamStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+type StreamEventType int32
+
+const (
+	StreamEventType_ADDED   StreamEventType = 0
+	StreamEventType_UPDATED StreamEventType = 1
+	StreamEventType_REMOVED StreamEventType = 2
+)
+
+var StreamEventType_name = map[int32]string{
+	0: "ADDED",
+	1: "UPDATED",
+	2: "REMOVED",
+}
+var StreamEventType_value = map[string]int32{
+	"ADDED":   0,
+	"UPDATED": 1,
+	"REMOVED": 2,
+}
+
+func (x StreamEventType) String() string {
+	return proto1.EnumName(StreamEventType_name, int32(x))
+}
+func (StreamEventType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
 type FieldFilterOperator int32
 
 const (
@@ -66,16 +153,91 @@ var FieldFilterOperator_value = map[string]int32{
 func (x FieldFilterOperator) String() string {
 	return proto1.EnumName(FieldFilterOperator_name, int32(x))
 }
-func (FieldFilterOperator) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+func (FieldFilterOperator) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+type ArchiveProblemType int32
+
+const (
+	ArchiveProblemType_MISSING         ArchiveProblemType = 0
+	ArchiveProblemType_CORRUPTED       ArchiveProblemType = 1
+	ArchiveProblemType_OUT_OF_SEQUENCE ArchiveProblemType = 2
+)
+
+var ArchiveProblemType_name = map[int32]string{
+	0: "MISSING",
+	1: "CORRUPTED",
+	2: "OUT_OF_SEQUENCE",
+}
+var ArchiveProblemType_value = map[string]int32{
+	"MISSING":         0,
+	"CORRUPTED":       1,
+	"OUT_OF_SEQUENCE": 2,
+}
+
+func (x ArchiveProblemType) String() string {
+	return proto1.EnumName(ArchiveProblemType_name, int32(x))
+}
+func (ArchiveProblemType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+type HealthRequest struct {
+}
+
+func (m *HealthRequest) Reset()                    { *m = HealthRequest{} }
+func (m *HealthRequest) String() string            { return proto1.CompactTextString(m) }
+func (*HealthRequest) ProtoMessage()               {}
+func (*HealthRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type HealthResponse struct {
+	// host is the node serving the request; empty for the hub
+	Host string `protobuf:"bytes,1,opt,name=host" json:"host,omitempty"`
+	// members are the mesh members, when served by the hub
+	Members []*MemberHealth `protobuf:"bytes,2,rep,name=members" json:"members,omitempty"`
+}
+
+func (m *HealthResponse) Reset()                    { *m = HealthResponse{} }
+func (m *HealthResponse) String() string            { return proto1.CompactTextString(m) }
+func (*HealthResponse) ProtoMessage()               {}
+func (*HealthResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *HealthResponse) GetMembers() []*MemberHealth {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+type MemberHealth struct {
+	Id    string      `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	State MemberState `protobuf:"varint,2,opt,name=state,enum=proto.MemberState" json:"state,omitempty"`
+	// last_seen is when we last heard from the member, by heartbeat or probe (unix seconds)
+	LastSeen int64 `protobuf:"varint,3,opt,name=last_seen,json=lastSeen" json:"last_seen,omitempty"`
+	// error is the error from the last probe, if it failed
+	Error string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *MemberHealth) Reset()                    { *m = MemberHealth{} }
+func (m *MemberHealth) String() string            { return proto1.CompactTextString(m) }
+func (*MemberHealth) ProtoMessage()               {}
+func (*MemberHealth) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
 type GetStreamsRequest struct {
+	// host restricts the request to a single node
 	Host string `protobuf:"bytes,1,opt,name=host" json:"host,omitempty"`
+	// field_filters are matched against the stream metadata, using the search field names (e.g. pod.namespace)
+	FieldFilters []*FieldFilter `protobuf:"bytes,2,rep,name=field_filters,json=fieldFilters" json:"field_filters,omitempty"`
 }
 
 func (m *GetStreamsRequest) Reset()                    { *m = GetStreamsRequest{} }
 func (m *GetStreamsRequest) String() string            { return proto1.CompactTextString(m) }
 func (*GetStreamsRequest) ProtoMessage()               {}
-func (*GetStreamsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+func (*GetStreamsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *GetStreamsRequest) GetFieldFilters() []*FieldFilter {
+	if m != nil {
+		return m.FieldFilters
+	}
+	return nil
+}
 
 // StreamInfo is metadata about a stream
 type StreamInfo struct {
@@ -85,12 +247,56 @@ type StreamInfo struct {
 	PodUid        string `protobuf:"bytes,4,opt,name=pod_uid,json=podUid" json:"pod_uid,omitempty"`
 	ContainerName string `protobuf:"bytes,5,opt,name=container_name,json=containerName" json:"container_name,omitempty"`
 	ContainerId   string `protobuf:"bytes,6,opt,name=container_id,json=containerId" json:"container_id,omitempty"`
+	// source is the name of a node-level log source, e.g. kubelet
+	Source string `protobuf:"bytes,7,opt,name=source" json:"source,omitempty"`
+	// file_count, size and the timestamps summarize the files currently in the stream
+	FileCount      int32        `protobuf:"varint,8,opt,name=file_count,json=fileCount" json:"file_count,omitempty"`
+	Size           int64        `protobuf:"varint,9,opt,name=size" json:"size,omitempty"`
+	FirstTimestamp uint64       `protobuf:"fixed64,10,opt,name=first_timestamp,json=firstTimestamp" json:"first_timestamp,omitempty"`
+	LastTimestamp  uint64       `protobuf:"fixed64,11,opt,name=last_timestamp,json=lastTimestamp" json:"last_timestamp,omitempty"`
+	Status         StreamStatus `protobuf:"varint,12,opt,name=status,enum=proto.StreamStatus" json:"status,omitempty"`
 }
 
 func (m *StreamInfo) Reset()                    { *m = StreamInfo{} }
 func (m *StreamInfo) String() string            { return proto1.CompactTextString(m) }
 func (*StreamInfo) ProtoMessage()               {}
-func (*StreamInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*StreamInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+type WatchStreamsRequest struct {
+	// host restricts the watch to a single node
+	Host string `protobuf:"bytes,1,opt,name=host" json:"host,omitempty"`
+	// field_filters are matched against the stream metadata, as for GetStreams
+	FieldFilters []*FieldFilter `protobuf:"bytes,2,rep,name=field_filters,json=fieldFilters" json:"field_filters,omitempty"`
+}
+
+func (m *WatchStreamsRequest) Reset()                    { *m = WatchStreamsRequest{} }
+func (m *WatchStreamsRequest) String() string            { return proto1.CompactTextString(m) }
+func (*WatchStreamsRequest) ProtoMessage()               {}
+func (*WatchStreamsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *WatchStreamsRequest) GetFieldFilters() []*FieldFilter {
+	if m != nil {
+		return m.FieldFilters
+	}
+	return nil
+}
+
+type StreamEvent struct {
+	Type   StreamEventType `protobuf:"varint,1,opt,name=type,enum=proto.StreamEventType" json:"type,omitempty"`
+	Stream *StreamInfo     `protobuf:"bytes,2,opt,name=stream" json:"stream,omitempty"`
+}
+
+func (m *StreamEvent) Reset()                    { *m = StreamEvent{} }
+func (m *StreamEvent) String() string            { return proto1.CompactTextString(m) }
+func (*StreamEvent) ProtoMessage()               {}
+func (*StreamEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *StreamEvent) GetStream() *StreamInfo {
+	if m != nil {
+		return m.Stream
+	}
+	return nil
+}
 
 type SearchRequest struct {
 	Contains     string         `protobuf:"bytes,1,opt,name=contains" json:"contains,omitempty"`
@@ -100,7 +306,7 @@ type SearchRequest struct {
 func (m *SearchRequest) Reset()                    { *m = SearchRequest{} }
 func (m *SearchRequest) String() string            { return proto1.CompactTextString(m) }
 func (*SearchRequest) ProtoMessage()               {}
-func (*SearchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*SearchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
 
 func (m *SearchRequest) GetFieldFilters() []*FieldFilter {
 	if m != nil {
@@ -118,7 +324,7 @@ type FieldFilter struct {
 func (m *FieldFilter) Reset()                    { *m = FieldFilter{} }
 func (m *FieldFilter) String() string            { return proto1.CompactTextString(m) }
 func (*FieldFilter) ProtoMessage()               {}
-func (*FieldFilter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*FieldFilter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
 
 type Fields struct {
 	Fields []*Field `protobuf:"bytes,1,rep,name=fields" json:"fields,omitempty"`
@@ -127,7 +333,7 @@ type Fields struct {
 func (m *Fields) Reset()                    { *m = Fields{} }
 func (m *Fields) String() string            { return proto1.CompactTextString(m) }
 func (*Fields) ProtoMessage()               {}
-func (*Fields) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*Fields) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
 
 func (m *Fields) GetFields() []*Field {
 	if m != nil {
@@ -144,7 +350,7 @@ type Field struct {
 func (m *Field) Reset()                    { *m = Field{} }
 func (m *Field) String() string            { return proto1.CompactTextString(m) }
 func (*Field) ProtoMessage()               {}
-func (*Field) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (*Field) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
 
 // SearchResult is an "batch" of search results
 type SearchResultChunk struct {
@@ -155,7 +361,7 @@ type SearchResultChunk struct {
 func (m *SearchResultChunk) Reset()                    { *m = SearchResultChunk{} }
 func (m *SearchResultChunk) String() string            { return proto1.CompactTextString(m) }
 func (*SearchResultChunk) ProtoMessage()               {}
-func (*SearchResultChunk) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (*SearchResultChunk) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
 
 func (m *SearchResultChunk) GetItems() []*SearchResult {
 	if m != nil {
@@ -181,7 +387,7 @@ type SearchResult struct {
 func (m *SearchResult) Reset()                    { *m = SearchResult{} }
 func (m *SearchResult) String() string            { return proto1.CompactTextString(m) }
 func (*SearchResult) ProtoMessage()               {}
-func (*SearchResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+func (*SearchResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
 
 func (m *SearchResult) GetFields() *Fields {
 	if m != nil {
@@ -196,12 +402,13 @@ type LogFile struct {
 	LastModified int64   `protobuf:"varint,3,opt,name=last_modified,json=lastModified" json:"last_modified,omitempty"`
 	Size         int64   `protobuf:"varint,4,opt,name=size" json:"size,omitempty"`
 	MaxTimestamp uint64  `protobuf:"fixed64,5,opt,name=max_timestamp,json=maxTimestamp" json:"max_timestamp,omitempty"`
+	MinTimestamp uint64  `protobuf:"fixed64,6,opt,name=min_timestamp,json=minTimestamp" json:"min_timestamp,omitempty"`
 }
 
 func (m *LogFile) Reset()                    { *m = LogFile{} }
 func (m *LogFile) String() string            { return proto1.CompactTextString(m) }
 func (*LogFile) ProtoMessage()               {}
-func (*LogFile) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+func (*LogFile) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
 
 func (m *LogFile) GetFields() *Fields {
 	if m != nil {
@@ -210,6 +417,131 @@ func (m *LogFile) GetFields() *Fields {
 	return nil
 }
 
+type ListArchiveRequest struct {
+	// field_filters are matched against the fields of the archived files, e.g. pod.namespace, @timestamp
+	FieldFilters []*FieldFilter `protobuf:"bytes,1,rep,name=field_filters,json=fieldFilters" json:"field_filters,omitempty"`
+}
+
+func (m *ListArchiveRequest) Reset()                    { *m = ListArchiveRequest{} }
+func (m *ListArchiveRequest) String() string            { return proto1.CompactTextString(m) }
+func (*ListArchiveRequest) ProtoMessage()               {}
+func (*ListArchiveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *ListArchiveRequest) GetFieldFilters() []*FieldFilter {
+	if m != nil {
+		return m.FieldFilters
+	}
+	return nil
+}
+
+type ReadArchiveRequest struct {
+	Key string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+}
+
+func (m *ReadArchiveRequest) Reset()                    { *m = ReadArchiveRequest{} }
+func (m *ReadArchiveRequest) String() string            { return proto1.CompactTextString(m) }
+func (*ReadArchiveRequest) ProtoMessage()               {}
+func (*ReadArchiveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+type ArchiveData struct {
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *ArchiveData) Reset()                    { *m = ArchiveData{} }
+func (m *ArchiveData) String() string            { return proto1.CompactTextString(m) }
+func (*ArchiveData) ProtoMessage()               {}
+func (*ArchiveData) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+type PruneArchiveRequest struct {
+	// dry_run reports the files that would be removed, without removing them
+	DryRun bool `protobuf:"varint,1,opt,name=dry_run,json=dryRun" json:"dry_run,omitempty"`
+}
+
+func (m *PruneArchiveRequest) Reset()                    { *m = PruneArchiveRequest{} }
+func (m *PruneArchiveRequest) String() string            { return proto1.CompactTextString(m) }
+func (*PruneArchiveRequest) ProtoMessage()               {}
+func (*PruneArchiveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+type PruneArchiveResponse struct {
+	// files are the files that were (or would be) removed
+	Files []*ArchivedFile `protobuf:"bytes,1,rep,name=files" json:"files,omitempty"`
+	// size is the total size of the files
+	Size int64 `protobuf:"varint,2,opt,name=size" json:"size,omitempty"`
+}
+
+func (m *PruneArchiveResponse) Reset()                    { *m = PruneArchiveResponse{} }
+func (m *PruneArchiveResponse) String() string            { return proto1.CompactTextString(m) }
+func (*PruneArchiveResponse) ProtoMessage()               {}
+func (*PruneArchiveResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *PruneArchiveResponse) GetFiles() []*ArchivedFile {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+type VerifyArchiveRequest struct {
+}
+
+func (m *VerifyArchiveRequest) Reset()                    { *m = VerifyArchiveRequest{} }
+func (m *VerifyArchiveRequest) String() string            { return proto1.CompactTextString(m) }
+func (*VerifyArchiveRequest) ProtoMessage()               {}
+func (*VerifyArchiveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+type VerifyArchiveResponse struct {
+	// verified is the number of files that matched their checksums
+	Verified int32 `protobuf:"varint,1,opt,name=verified" json:"verified,omitempty"`
+	// unverified is the number of files archived without a checksum
+	Unverified int32             `protobuf:"varint,2,opt,name=unverified" json:"unverified,omitempty"`
+	Problems   []*ArchiveProblem `protobuf:"bytes,3,rep,name=problems" json:"problems,omitempty"`
+}
+
+func (m *VerifyArchiveResponse) Reset()                    { *m = VerifyArchiveResponse{} }
+func (m *VerifyArchiveResponse) String() string            { return proto1.CompactTextString(m) }
+func (*VerifyArchiveResponse) ProtoMessage()               {}
+func (*VerifyArchiveResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *VerifyArchiveResponse) GetProblems() []*ArchiveProblem {
+	if m != nil {
+		return m.Problems
+	}
+	return nil
+}
+
+// ArchiveProblem is an integrity problem found by VerifyArchive
+type ArchiveProblem struct {
+	// key is the archived file, or the stream for problems with its hash chain
+	Key     string             `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Type    ArchiveProblemType `protobuf:"varint,2,opt,name=type,enum=proto.ArchiveProblemType" json:"type,omitempty"`
+	Message string             `protobuf:"bytes,3,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *ArchiveProblem) Reset()                    { *m = ArchiveProblem{} }
+func (m *ArchiveProblem) String() string            { return proto1.CompactTextString(m) }
+func (*ArchiveProblem) ProtoMessage()               {}
+func (*ArchiveProblem) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+// ArchivedFile is a file in the archive
+type ArchivedFile struct {
+	// key is the location of the file in the archive
+	Key string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	// file is the catalog entry of the original file
+	File *LogFile `protobuf:"bytes,2,opt,name=file" json:"file,omitempty"`
+}
+
+func (m *ArchivedFile) Reset()                    { *m = ArchivedFile{} }
+func (m *ArchivedFile) String() string            { return proto1.CompactTextString(m) }
+func (*ArchivedFile) ProtoMessage()               {}
+func (*ArchivedFile) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+func (m *ArchivedFile) GetFile() *LogFile {
+	if m != nil {
+		return m.File
+	}
+	return nil
+}
+
 type HostInfo struct {
 	Id  string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 	Url string `protobuf:"bytes,2,opt,name=url" json:"url,omitempty"`
@@ -218,7 +550,7 @@ type HostInfo struct {
 func (m *HostInfo) Reset()                    { *m = HostInfo{} }
 func (m *HostInfo) String() string            { return proto1.CompactTextString(m) }
 func (*HostInfo) ProtoMessage()               {}
-func (*HostInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+func (*HostInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
 
 type JoinMeshRequest struct {
 	HostInfo *HostInfo `protobuf:"bytes,1,opt,name=host_info,json=hostInfo" json:"host_info,omitempty"`
@@ -227,7 +559,7 @@ type JoinMeshRequest struct {
 func (m *JoinMeshRequest) Reset()                    { *m = JoinMeshRequest{} }
 func (m *JoinMeshRequest) String() string            { return proto1.CompactTextString(m) }
 func (*JoinMeshRequest) ProtoMessage()               {}
-func (*JoinMeshRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+func (*JoinMeshRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
 
 func (m *JoinMeshRequest) GetHostInfo() *HostInfo {
 	if m != nil {
@@ -242,11 +574,16 @@ type JoinMeshResponse struct {
 func (m *JoinMeshResponse) Reset()                    { *m = JoinMeshResponse{} }
 func (m *JoinMeshResponse) String() string            { return proto1.CompactTextString(m) }
 func (*JoinMeshResponse) ProtoMessage()               {}
-func (*JoinMeshResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+func (*JoinMeshResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
 
 func init() {
+	proto1.RegisterType((*HealthRequest)(nil), "proto.HealthRequest")
+	proto1.RegisterType((*HealthResponse)(nil), "proto.HealthResponse")
+	proto1.RegisterType((*MemberHealth)(nil), "proto.MemberHealth")
 	proto1.RegisterType((*GetStreamsRequest)(nil), "proto.GetStreamsRequest")
 	proto1.RegisterType((*StreamInfo)(nil), "proto.StreamInfo")
+	proto1.RegisterType((*WatchStreamsRequest)(nil), "proto.WatchStreamsRequest")
+	proto1.RegisterType((*StreamEvent)(nil), "proto.StreamEvent")
 	proto1.RegisterType((*SearchRequest)(nil), "proto.SearchRequest")
 	proto1.RegisterType((*FieldFilter)(nil), "proto.FieldFilter")
 	proto1.RegisterType((*Fields)(nil), "proto.Fields")
@@ -254,10 +591,23 @@ func init() {
 	proto1.RegisterType((*SearchResultChunk)(nil), "proto.SearchResultChunk")
 	proto1.RegisterType((*SearchResult)(nil), "proto.SearchResult")
 	proto1.RegisterType((*LogFile)(nil), "proto.LogFile")
+	proto1.RegisterType((*ListArchiveRequest)(nil), "proto.ListArchiveRequest")
+	proto1.RegisterType((*ReadArchiveRequest)(nil), "proto.ReadArchiveRequest")
+	proto1.RegisterType((*ArchiveData)(nil), "proto.ArchiveData")
+	proto1.RegisterType((*PruneArchiveRequest)(nil), "proto.PruneArchiveRequest")
+	proto1.RegisterType((*PruneArchiveResponse)(nil), "proto.PruneArchiveResponse")
+	proto1.RegisterType((*VerifyArchiveRequest)(nil), "proto.VerifyArchiveRequest")
+	proto1.RegisterType((*VerifyArchiveResponse)(nil), "proto.VerifyArchiveResponse")
+	proto1.RegisterType((*ArchiveProblem)(nil), "proto.ArchiveProblem")
+	proto1.RegisterType((*ArchivedFile)(nil), "proto.ArchivedFile")
 	proto1.RegisterType((*HostInfo)(nil), "proto.HostInfo")
 	proto1.RegisterType((*JoinMeshRequest)(nil), "proto.JoinMeshRequest")
 	proto1.RegisterType((*JoinMeshResponse)(nil), "proto.JoinMeshResponse")
+	proto1.RegisterEnum("proto.MemberState", MemberState_name, MemberState_value)
+	proto1.RegisterEnum("proto.StreamStatus", StreamStatus_name, StreamStatus_value)
+	proto1.RegisterEnum("proto.StreamEventType", StreamEventType_name, StreamEventType_value)
 	proto1.RegisterEnum("proto.FieldFilterOperator", FieldFilterOperator_name, FieldFilterOperator_value)
+	proto1.RegisterEnum("proto.ArchiveProblemType", ArchiveProblemType_name, ArchiveProblemType_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -273,6 +623,10 @@ const _ = grpc.SupportPackageIsVersion3
 type LogServerClient interface {
 	GetStreams(ctx context.Context, in *GetStreamsRequest, opts ...grpc.CallOption) (LogServer_GetStreamsClient, error)
 	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (LogServer_SearchClient, error)
+	// WatchStreams sends an ADDED event for each current stream, then events as streams change
+	WatchStreams(ctx context.Context, in *WatchStreamsRequest, opts ...grpc.CallOption) (LogServer_WatchStreamsClient, error)
+	// Health reports that the server is serving; the hub probes its members with it, and reports their states
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
 }
 
 type logServerClient struct {
@@ -347,11 +701,56 @@ func (x *logServerSearchClient) Recv() (*SearchResultChunk, error) {
 	return m, nil
 }
 
+func (c *logServerClient) WatchStreams(ctx context.Context, in *WatchStreamsRequest, opts ...grpc.CallOption) (LogServer_WatchStreamsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_LogServer_serviceDesc.Streams[2], c.cc, "/proto.LogServer/WatchStreams", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logServerWatchStreamsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LogServer_WatchStreamsClient interface {
+	Recv() (*StreamEvent, error)
+	grpc.ClientStream
+}
+
+type logServerWatchStreamsClient struct {
+	grpc.ClientStream
+}
+
+func (x *logServerWatchStreamsClient) Recv() (*StreamEvent, error) {
+	m := new(StreamEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *logServerClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := grpc.Invoke(ctx, "/proto.LogServer/Health", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for LogServer service
 
 type LogServerServer interface {
 	GetStreams(*GetStreamsRequest, LogServer_GetStreamsServer) error
 	Search(*SearchRequest, LogServer_SearchServer) error
+	// WatchStreams sends an ADDED event for each current stream, then events as streams change
+	WatchStreams(*WatchStreamsRequest, LogServer_WatchStreamsServer) error
+	// Health reports that the server is serving; the hub probes its members with it, and reports their states
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
 }
 
 func RegisterLogServerServer(s *grpc.Server, srv LogServerServer) {
@@ -400,10 +799,54 @@ func (x *logServerSearchServer) Send(m *SearchResultChunk) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _LogServer_WatchStreams_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStreamsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogServerServer).WatchStreams(m, &logServerWatchStreamsServer{stream})
+}
+
+type LogServer_WatchStreamsServer interface {
+	Send(*StreamEvent) error
+	grpc.ServerStream
+}
+
+type logServerWatchStreamsServer struct {
+	grpc.ServerStream
+}
+
+func (x *logServerWatchStreamsServer) Send(m *StreamEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _LogServer_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServerServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LogServer/Health",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServerServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _LogServer_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.LogServer",
 	HandlerType: (*LogServerServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Health",
+			Handler:    _LogServer_Health_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "GetStreams",
@@ -415,6 +858,237 @@ var _LogServer_serviceDesc = grpc.ServiceDesc{
 			Handler:       _LogServer_Search_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchStreams",
+			Handler:       _LogServer_WatchStreams_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: fileDescriptor0,
+}
+
+// Client API for ArchiveService service
+
+type ArchiveServiceClient interface {
+	// PruneArchive removes the archived files that are past their retention
+	PruneArchive(ctx context.Context, in *PruneArchiveRequest, opts ...grpc.CallOption) (*PruneArchiveResponse, error)
+	// ListArchive returns the archived files matching the filters
+	ListArchive(ctx context.Context, in *ListArchiveRequest, opts ...grpc.CallOption) (ArchiveService_ListArchiveClient, error)
+	// ReadArchive returns the (decompressed) contents of an archived file
+	ReadArchive(ctx context.Context, in *ReadArchiveRequest, opts ...grpc.CallOption) (ArchiveService_ReadArchiveClient, error)
+	// VerifyArchive re-reads the archived files, checking them against their checksums and hash chains
+	VerifyArchive(ctx context.Context, in *VerifyArchiveRequest, opts ...grpc.CallOption) (*VerifyArchiveResponse, error)
+}
+
+type archiveServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewArchiveServiceClient(cc *grpc.ClientConn) ArchiveServiceClient {
+	return &archiveServiceClient{cc}
+}
+
+func (c *archiveServiceClient) PruneArchive(ctx context.Context, in *PruneArchiveRequest, opts ...grpc.CallOption) (*PruneArchiveResponse, error) {
+	out := new(PruneArchiveResponse)
+	err := grpc.Invoke(ctx, "/proto.ArchiveService/PruneArchive", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *archiveServiceClient) ListArchive(ctx context.Context, in *ListArchiveRequest, opts ...grpc.CallOption) (ArchiveService_ListArchiveClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_ArchiveService_serviceDesc.Streams[0], c.cc, "/proto.ArchiveService/ListArchive", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &archiveServiceListArchiveClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ArchiveService_ListArchiveClient interface {
+	Recv() (*ArchivedFile, error)
+	grpc.ClientStream
+}
+
+type archiveServiceListArchiveClient struct {
+	grpc.ClientStream
+}
+
+func (x *archiveServiceListArchiveClient) Recv() (*ArchivedFile, error) {
+	m := new(ArchivedFile)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *archiveServiceClient) ReadArchive(ctx context.Context, in *ReadArchiveRequest, opts ...grpc.CallOption) (ArchiveService_ReadArchiveClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_ArchiveService_serviceDesc.Streams[1], c.cc, "/proto.ArchiveService/ReadArchive", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &archiveServiceReadArchiveClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ArchiveService_ReadArchiveClient interface {
+	Recv() (*ArchiveData, error)
+	grpc.ClientStream
+}
+
+type archiveServiceReadArchiveClient struct {
+	grpc.ClientStream
+}
+
+func (x *archiveServiceReadArchiveClient) Recv() (*ArchiveData, error) {
+	m := new(ArchiveData)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *archiveServiceClient) VerifyArchive(ctx context.Context, in *VerifyArchiveRequest, opts ...grpc.CallOption) (*VerifyArchiveResponse, error) {
+	out := new(VerifyArchiveResponse)
+	err := grpc.Invoke(ctx, "/proto.ArchiveService/VerifyArchive", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for ArchiveService service
+
+type ArchiveServiceServer interface {
+	// PruneArchive removes the archived files that are past their retention
+	PruneArchive(context.Context, *PruneArchiveRequest) (*PruneArchiveResponse, error)
+	// ListArchive returns the archived files matching the filters
+	ListArchive(*ListArchiveRequest, ArchiveService_ListArchiveServer) error
+	// ReadArchive returns the (decompressed) contents of an archived file
+	ReadArchive(*ReadArchiveRequest, ArchiveService_ReadArchiveServer) error
+	// VerifyArchive re-reads the archived files, checking them against their checksums and hash chains
+	VerifyArchive(context.Context, *VerifyArchiveRequest) (*VerifyArchiveResponse, error)
+}
+
+func RegisterArchiveServiceServer(s *grpc.Server, srv ArchiveServiceServer) {
+	s.RegisterService(&_ArchiveService_serviceDesc, srv)
+}
+
+func _ArchiveService_PruneArchive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PruneArchiveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArchiveServiceServer).PruneArchive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ArchiveService/PruneArchive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArchiveServiceServer).PruneArchive(ctx, req.(*PruneArchiveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ArchiveService_ListArchive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListArchiveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ArchiveServiceServer).ListArchive(m, &archiveServiceListArchiveServer{stream})
+}
+
+type ArchiveService_ListArchiveServer interface {
+	Send(*ArchivedFile) error
+	grpc.ServerStream
+}
+
+type archiveServiceListArchiveServer struct {
+	grpc.ServerStream
+}
+
+func (x *archiveServiceListArchiveServer) Send(m *ArchivedFile) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ArchiveService_ReadArchive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadArchiveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ArchiveServiceServer).ReadArchive(m, &archiveServiceReadArchiveServer{stream})
+}
+
+type ArchiveService_ReadArchiveServer interface {
+	Send(*ArchiveData) error
+	grpc.ServerStream
+}
+
+type archiveServiceReadArchiveServer struct {
+	grpc.ServerStream
+}
+
+func (x *archiveServiceReadArchiveServer) Send(m *ArchiveData) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ArchiveService_VerifyArchive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyArchiveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArchiveServiceServer).VerifyArchive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ArchiveService/VerifyArchive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArchiveServiceServer).VerifyArchive(ctx, req.(*VerifyArchiveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ArchiveService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.ArchiveService",
+	HandlerType: (*ArchiveServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PruneArchive",
+			Handler:    _ArchiveService_PruneArchive_Handler,
+		},
+		{
+			MethodName: "VerifyArchive",
+			Handler:    _ArchiveService_VerifyArchive_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListArchive",
+			Handler:       _ArchiveService_ListArchive_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReadArchive",
+			Handler:       _ArchiveService_ReadArchive_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: fileDescriptor0,
 }
@@ -486,46 +1160,91 @@ var _MeshService_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("log.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 652 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x8c, 0x54, 0xdd, 0x52, 0xd3, 0x40,
-	0x14, 0x26, 0x2d, 0x0d, 0xed, 0x69, 0x0a, 0xe5, 0xc0, 0x48, 0xed, 0x78, 0xa1, 0xab, 0x8e, 0xc8,
-	0x30, 0xe8, 0xc4, 0x0b, 0x6f, 0x64, 0xbc, 0x70, 0x00, 0x71, 0xf8, 0x19, 0x03, 0x5e, 0x67, 0x56,
-	0xb2, 0xa5, 0x19, 0x92, 0x6c, 0xcc, 0x6e, 0xf1, 0xe7, 0x09, 0x7c, 0x0e, 0x5f, 0xc5, 0x17, 0x73,
-	0xff, 0xd2, 0x76, 0x80, 0x71, 0xb8, 0xca, 0xd9, 0xf3, 0x9d, 0x9f, 0xef, 0x9c, 0x7c, 0xbb, 0xd0,
-	0xc9, 0xf8, 0xe5, 0x4e, 0x59, 0x71, 0xc9, 0xb1, 0x65, 0x3e, 0xe4, 0x05, 0xac, 0x1e, 0x30, 0x79,
-	0x26, 0x2b, 0x46, 0x73, 0x11, 0xb1, 0x6f, 0x13, 0x26, 0x24, 0x22, 0x2c, 0x8e, 0xb9, 0x90, 0x03,
-	0xef, 0xb1, 0xb7, 0xd9, 0x89, 0x8c, 0x4d, 0xfe, 0x7a, 0x00, 0x36, 0xec, 0xb0, 0x18, 0xf1, 0xbb,
-	0x42, 0xf0, 0x29, 0xf4, 0x4a, 0x9e, 0xc4, 0x05, 0xcd, 0x99, 0x28, 0xe9, 0x05, 0x1b, 0x34, 0x0c,
-	0x18, 0x28, 0xe7, 0x49, 0xed, 0xc3, 0x87, 0xd0, 0xae, 0x83, 0x06, 0x4d, 0x83, 0x2f, 0x39, 0x1c,
-	0x37, 0x40, 0x9b, 0xf1, 0x24, 0x4d, 0x06, 0x8b, 0x06, 0xf1, 0xd5, 0xf1, 0x4b, 0x9a, 0xe0, 0x73,
-	0x58, 0xbe, 0xe0, 0x85, 0xa4, 0x69, 0xc1, 0x2a, 0x9b, 0xd9, 0x32, 0x78, 0x6f, 0xea, 0x35, 0xf9,
-	0x4f, 0x20, 0x98, 0x85, 0xa9, 0x22, 0xbe, 0x09, 0xea, 0x4e, 0x7d, 0x87, 0x09, 0x49, 0xa0, 0x77,
-	0xc6, 0x68, 0x75, 0x31, 0xae, 0x47, 0x1d, 0x42, 0xdb, 0xe1, 0xc2, 0xcd, 0x32, 0x3d, 0xe3, 0x5b,
-	0xe8, 0x8d, 0x52, 0x96, 0x25, 0xf1, 0x28, 0xcd, 0x24, 0xab, 0x84, 0x9a, 0xa7, 0xb9, 0xd9, 0x0d,
-	0xd1, 0x6e, 0x70, 0x67, 0x5f, 0x63, 0xfb, 0x06, 0x8a, 0x82, 0xd1, 0xec, 0x20, 0x08, 0x85, 0xee,
-	0x1c, 0x88, 0x7d, 0x68, 0x5e, 0xb1, 0x9f, 0xae, 0xbc, 0x36, 0x71, 0x1d, 0x5a, 0xd7, 0x34, 0x9b,
-	0xd4, 0x1b, 0xb2, 0x07, 0xdc, 0x82, 0x06, 0x2f, 0xcd, 0x52, 0x96, 0xc3, 0xe1, 0xed, 0x26, 0xa7,
-	0x25, 0xab, 0xa8, 0xe4, 0x55, 0xa4, 0xa2, 0xc8, 0x0e, 0xf8, 0x06, 0x12, 0xf8, 0x0c, 0x7c, 0xd3,
-	0x5c, 0xf3, 0xd7, 0xf4, 0x82, 0xf9, 0xcc, 0xc8, 0x61, 0xe4, 0x15, 0xb4, 0x8c, 0xe3, 0xbe, 0x64,
-	0x48, 0x05, 0xab, 0xf5, 0xa6, 0xc4, 0x24, 0x93, 0x1f, 0xc6, 0x93, 0xe2, 0x0a, 0x5f, 0x42, 0x2b,
-	0x95, 0x2c, 0xaf, 0x5b, 0xad, 0xb9, 0x56, 0xf3, 0x81, 0x91, 0x8d, 0xc0, 0x10, 0xd4, 0xdf, 0xc9,
-	0x73, 0x5e, 0xc4, 0x8e, 0x9d, 0xae, 0xde, 0x0d, 0x7b, 0xf3, 0xec, 0x44, 0x14, 0xd8, 0x18, 0x7b,
-	0x22, 0x0c, 0x82, 0xf9, 0x52, 0x9a, 0x6b, 0x45, 0xbf, 0x1b, 0xae, 0x41, 0xa4, 0x4d, 0xa5, 0x04,
-	0xff, 0x7f, 0xe5, 0x1c, 0x88, 0x8f, 0xa0, 0x23, 0x53, 0xa5, 0x38, 0x49, 0x73, 0xbb, 0x50, 0x3f,
-	0x9a, 0x39, 0xc8, 0x1f, 0x0f, 0x96, 0x8e, 0xf8, 0xa5, 0xda, 0x2a, 0xd3, 0x3a, 0x2e, 0xa9, 0x1c,
-	0xd7, 0x3a, 0xd6, 0xf6, 0x7d, 0x9b, 0x28, 0xb9, 0x67, 0x54, 0xc8, 0x38, 0xe7, 0x49, 0xaa, 0x5c,
-	0x89, 0x69, 0xd4, 0x8c, 0x02, 0xed, 0x3c, 0x76, 0x3e, 0x5d, 0x5f, 0xa4, 0xbf, 0x98, 0x11, 0x74,
-	0x33, 0x32, 0xb6, 0x4e, 0xcc, 0xe9, 0x8f, 0x78, 0xc6, 0xb0, 0x65, 0x18, 0x06, 0xca, 0x79, 0x3e,
-	0x25, 0xb9, 0x0d, 0xed, 0x8f, 0xea, 0x52, 0x99, 0xcb, 0xb6, 0x0c, 0x0d, 0x25, 0x67, 0x4b, 0x51,
-	0x59, 0x7a, 0x2f, 0x93, 0x2a, 0x73, 0xff, 0x4b, 0x9b, 0xe4, 0x3d, 0xac, 0x7c, 0xe2, 0x69, 0x71,
-	0xcc, 0xc4, 0x54, 0xd9, 0xdb, 0xd0, 0xd1, 0xb7, 0x32, 0x4e, 0x55, 0x05, 0x93, 0xdb, 0x0d, 0x57,
-	0xdc, 0x20, 0x75, 0xe1, 0xa8, 0x3d, 0x76, 0x16, 0x41, 0xe8, 0xcf, 0x0a, 0x88, 0x92, 0x17, 0x82,
-	0x6d, 0x85, 0xb0, 0x76, 0x87, 0xfc, 0xd0, 0x87, 0xc6, 0xde, 0xe7, 0xfe, 0x02, 0x02, 0xf8, 0x27,
-	0xa7, 0xe7, 0xb1, 0xb2, 0x3d, 0x5c, 0x82, 0xe6, 0xc1, 0xf9, 0x5e, 0xbf, 0x11, 0xfe, 0xf6, 0xa0,
-	0xa3, 0x76, 0x7b, 0xc6, 0xaa, 0x6b, 0xa5, 0xfc, 0x5d, 0x80, 0xd9, 0xeb, 0x82, 0x03, 0xd7, 0xfe,
-	0xd6, 0x83, 0x33, 0x5c, 0xad, 0x85, 0x34, 0x7d, 0x60, 0xc8, 0xc2, 0x6b, 0x0f, 0xdf, 0x81, 0x6f,
-	0xf5, 0x80, 0xeb, 0x37, 0x94, 0x66, 0xd3, 0x06, 0x77, 0xe8, 0xcf, 0x08, 0x55, 0x67, 0x87, 0x47,
-	0xd0, 0xd5, 0xe3, 0x68, 0x2a, 0xa9, 0x7a, 0x78, 0x76, 0xa1, 0x5d, 0x4f, 0x88, 0x0f, 0x5c, 0xe2,
-	0x8d, 0x9d, 0x0d, 0x37, 0x6e, 0xf9, 0xed, 0x2a, 0xc8, 0xc2, 0x57, 0xdf, 0x20, 0x6f, 0xfe, 0x05,
-	0x00, 0x00, 0xff, 0xff, 0x98, 0x92, 0x5a, 0x78, 0x43, 0x05, 0x00, 0x00,
+	// 1376 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xb4, 0x56, 0x5d, 0x73, 0xdb, 0x44,
+	0x17, 0x8e, 0xe4, 0x58, 0xb6, 0x8f, 0x65, 0xc7, 0xdd, 0xa4, 0xad, 0xea, 0xf6, 0x7d, 0x27, 0x55,
+	0xdf, 0xbe, 0xb8, 0xa1, 0x0d, 0xd4, 0xcc, 0x50, 0x2e, 0xe8, 0x40, 0x88, 0x95, 0x26, 0x10, 0xdb,
+	0xe9, 0xda, 0x6e, 0x87, 0x2b, 0xa3, 0x58, 0xeb, 0x58, 0x53, 0x5b, 0x12, 0xda, 0x75, 0x68, 0xe0,
+	0x1a, 0x6e, 0xf8, 0x2f, 0xfc, 0x0d, 0xfe, 0x16, 0xb3, 0x1f, 0x92, 0xe5, 0x8f, 0x61, 0x3a, 0xcc,
+	0x70, 0x25, 0xed, 0x39, 0xcf, 0x3e, 0x7b, 0xce, 0xd9, 0xf3, 0xb1, 0x50, 0x9a, 0x86, 0x57, 0x87,
+	0x51, 0x1c, 0xb2, 0x10, 0xe5, 0xc5, 0xc7, 0xde, 0x81, 0xca, 0x29, 0x71, 0xa7, 0x6c, 0x82, 0xc9,
+	0x8f, 0x73, 0x42, 0x99, 0xdd, 0x83, 0x6a, 0x22, 0xa0, 0x51, 0x18, 0x50, 0x82, 0x10, 0x6c, 0x4f,
+	0x42, 0xca, 0x2c, 0x6d, 0x5f, 0x6b, 0x94, 0xb0, 0xf8, 0x47, 0xcf, 0xa0, 0x30, 0x23, 0xb3, 0x4b,
+	0x12, 0x53, 0x4b, 0xdf, 0xcf, 0x35, 0xca, 0xcd, 0x5d, 0x49, 0x7b, 0xd8, 0x16, 0x52, 0xc5, 0x90,
+	0x60, 0xec, 0x5f, 0xc0, 0xcc, 0x2a, 0x50, 0x15, 0x74, 0xdf, 0x53, 0x84, 0xba, 0xef, 0xa1, 0x06,
+	0xe4, 0x29, 0x73, 0x19, 0xb1, 0xf4, 0x7d, 0xad, 0x51, 0x6d, 0xa2, 0x25, 0xb2, 0x1e, 0xd7, 0x60,
+	0x09, 0x40, 0xf7, 0xa1, 0x34, 0x75, 0x29, 0x1b, 0x52, 0x42, 0x02, 0x2b, 0xb7, 0xaf, 0x35, 0x72,
+	0xb8, 0xc8, 0x05, 0x3d, 0x42, 0x02, 0xb4, 0x07, 0x79, 0x12, 0xc7, 0x61, 0x6c, 0x6d, 0x0b, 0x66,
+	0xb9, 0xb0, 0x7f, 0x80, 0x5b, 0xaf, 0x08, 0xeb, 0xb1, 0x98, 0xb8, 0x33, 0xaa, 0xdc, 0xdc, 0xe8,
+	0xd4, 0x0b, 0xa8, 0x8c, 0x7d, 0x32, 0xf5, 0x86, 0x63, 0x7f, 0xca, 0x16, 0xae, 0x25, 0xd6, 0x9c,
+	0x70, 0xdd, 0x89, 0x50, 0x61, 0x73, 0xbc, 0x58, 0x50, 0xfb, 0xf7, 0x1c, 0x80, 0xe4, 0x3f, 0x0b,
+	0xc6, 0xe1, 0x46, 0xee, 0x47, 0x50, 0x89, 0x42, 0x6f, 0x18, 0xb8, 0x33, 0x42, 0x23, 0x77, 0x24,
+	0x3d, 0x2d, 0x61, 0x33, 0x0a, 0xbd, 0x4e, 0x22, 0x43, 0xf7, 0xa0, 0x98, 0x80, 0x84, 0x6f, 0x25,
+	0x5c, 0x50, 0x7a, 0x74, 0x17, 0xf8, 0xef, 0x70, 0xee, 0x7b, 0xca, 0x39, 0x23, 0x0a, 0xbd, 0x81,
+	0xef, 0xa1, 0xc7, 0x50, 0x1d, 0x85, 0x01, 0x73, 0xfd, 0x80, 0xc4, 0x72, 0x67, 0x5e, 0xe8, 0x2b,
+	0xa9, 0x54, 0xec, 0x7f, 0x08, 0xe6, 0x02, 0xe6, 0x7b, 0x96, 0x21, 0x40, 0xe5, 0x54, 0x76, 0xe6,
+	0xa1, 0x3b, 0x60, 0xd0, 0x70, 0x1e, 0x8f, 0x88, 0x55, 0x90, 0x27, 0xc8, 0x15, 0xfa, 0x0f, 0xc0,
+	0xd8, 0x9f, 0x92, 0xe1, 0x28, 0x9c, 0x07, 0xcc, 0x2a, 0xee, 0x6b, 0x8d, 0x3c, 0x2e, 0x71, 0xc9,
+	0x31, 0x17, 0x70, 0x6f, 0xa9, 0xff, 0x33, 0xb1, 0x4a, 0xe2, 0x32, 0xc4, 0x3f, 0xfa, 0x08, 0x76,
+	0xc6, 0x7e, 0x4c, 0xd9, 0x90, 0xf9, 0x33, 0x42, 0x99, 0x3b, 0x8b, 0x2c, 0xd8, 0xd7, 0x1a, 0x06,
+	0xae, 0x0a, 0x71, 0x3f, 0x91, 0x72, 0xeb, 0xa7, 0xee, 0x12, 0xae, 0x2c, 0x70, 0x95, 0xa9, 0x9b,
+	0x85, 0x7d, 0x0c, 0x06, 0xbf, 0xfe, 0x39, 0xb5, 0x4c, 0x91, 0x20, 0x49, 0xb6, 0xc9, 0xa0, 0xf7,
+	0x84, 0x0a, 0x2b, 0x88, 0x7d, 0x09, 0xbb, 0x6f, 0x5d, 0x36, 0x9a, 0xfc, 0x9b, 0x37, 0xee, 0x41,
+	0x59, 0xd2, 0x3b, 0xd7, 0x24, 0x60, 0xe8, 0x00, 0xb6, 0xd9, 0x4d, 0x44, 0x04, 0x77, 0xb5, 0x79,
+	0x67, 0xc9, 0x3a, 0x81, 0xe8, 0xdf, 0x44, 0x04, 0x0b, 0x0c, 0x7a, 0xc2, 0x7d, 0xe1, 0x0a, 0x91,
+	0x02, 0xe5, 0xe6, 0xad, 0x25, 0x34, 0x4f, 0x20, 0xac, 0x00, 0xb6, 0x07, 0x95, 0x1e, 0x71, 0xe3,
+	0x51, 0x52, 0x9c, 0xa8, 0x0e, 0x45, 0x75, 0x63, 0x54, 0xf9, 0x91, 0xae, 0xff, 0xb9, 0x2f, 0x2e,
+	0x94, 0x33, 0x4a, 0x54, 0x83, 0xdc, 0x3b, 0x72, 0xa3, 0xe8, 0xf9, 0x2f, 0x2f, 0xab, 0x6b, 0x77,
+	0x3a, 0x4f, 0x72, 0x56, 0x2e, 0xd0, 0x01, 0xe8, 0x61, 0x24, 0xd2, 0xb4, 0xda, 0xac, 0xaf, 0x1f,
+	0xd2, 0x8d, 0x48, 0xec, 0xb2, 0x30, 0xc6, 0x7a, 0x18, 0xd9, 0x87, 0x60, 0x08, 0x15, 0x45, 0xff,
+	0x03, 0x43, 0x1c, 0xce, 0xed, 0xe7, 0xe6, 0x99, 0xd9, 0x9d, 0x58, 0xe9, 0xec, 0x4f, 0x20, 0x2f,
+	0x04, 0x1f, 0x6a, 0x8c, 0x1d, 0xc3, 0xad, 0x24, 0x52, 0x74, 0x3e, 0x65, 0xc7, 0x93, 0x79, 0xf0,
+	0x0e, 0x3d, 0x81, 0xbc, 0xcf, 0xc8, 0x2c, 0x39, 0x2a, 0x4d, 0x9a, 0x0c, 0x10, 0x4b, 0x04, 0x6a,
+	0x42, 0x65, 0x14, 0xce, 0x66, 0x61, 0x30, 0x54, 0xd6, 0xc9, 0xbb, 0xa9, 0x64, 0xad, 0xa3, 0xd8,
+	0x94, 0x18, 0xb9, 0xb2, 0x09, 0x98, 0x59, 0x2a, 0x6e, 0x6b, 0xec, 0xfe, 0x24, 0x6c, 0x35, 0x31,
+	0xff, 0x45, 0x8f, 0xc1, 0xf8, 0x3b, 0x3a, 0xa5, 0x44, 0x0f, 0xa0, 0xb4, 0xc8, 0xff, 0x9c, 0xc8,
+	0xff, 0x85, 0xc0, 0xfe, 0x53, 0x83, 0xc2, 0x79, 0x78, 0x75, 0xe2, 0x4f, 0x45, 0x2b, 0x8e, 0x5c,
+	0x36, 0x49, 0x72, 0x98, 0xff, 0x7f, 0xe8, 0x21, 0x8f, 0x40, 0xd4, 0xd4, 0x70, 0x16, 0x7a, 0xfe,
+	0xd8, 0x27, 0x9e, 0x6a, 0x9e, 0x26, 0x17, 0xb6, 0x95, 0x2c, 0xad, 0xe5, 0xed, 0x4c, 0x2d, 0x3f,
+	0x82, 0xca, 0xcc, 0x7d, 0x9f, 0xa9, 0xd0, 0xbc, 0xb0, 0xd0, 0x9c, 0xb9, 0xef, 0x17, 0x05, 0xca,
+	0x41, 0x7e, 0x90, 0x01, 0x19, 0x0a, 0xe4, 0x07, 0x29, 0xc8, 0x6e, 0x03, 0x3a, 0xf7, 0x29, 0x3b,
+	0x8a, 0x47, 0x13, 0xff, 0x9a, 0x24, 0x39, 0xbd, 0x96, 0xb7, 0xda, 0x07, 0xe6, 0xed, 0xff, 0x01,
+	0x61, 0xe2, 0x7a, 0x2b, 0x74, 0x6b, 0x19, 0x63, 0x3f, 0x84, 0xb2, 0xc2, 0xb4, 0x5c, 0xe6, 0x72,
+	0x1f, 0x3d, 0x97, 0xb9, 0xea, 0x9e, 0xc4, 0xbf, 0x7d, 0x08, 0xbb, 0x17, 0xf1, 0x3c, 0x20, 0x2b,
+	0x5c, 0x77, 0xa1, 0xe0, 0xc5, 0x37, 0xc3, 0x78, 0x1e, 0x08, 0x74, 0x11, 0x1b, 0x5e, 0x7c, 0x83,
+	0xe7, 0x81, 0x3d, 0x80, 0xbd, 0x65, 0xbc, 0x1a, 0x95, 0x4f, 0x20, 0xcf, 0x1b, 0xe3, 0x6a, 0xc6,
+	0x29, 0x18, 0xb7, 0x9c, 0x60, 0x89, 0x48, 0x43, 0xad, 0x2f, 0x42, 0x6d, 0xdf, 0x81, 0xbd, 0x37,
+	0x24, 0xf6, 0xc7, 0x37, 0xcb, 0x76, 0xd8, 0xbf, 0x69, 0x70, 0x7b, 0x45, 0xa1, 0x0e, 0xac, 0x43,
+	0xf1, 0x9a, 0xc4, 0xf2, 0x42, 0x35, 0xd1, 0x99, 0xd3, 0x35, 0xfa, 0x2f, 0xc0, 0x3c, 0x48, 0xb5,
+	0xba, 0xd0, 0x66, 0x24, 0xe8, 0x39, 0x14, 0xa3, 0x38, 0xbc, 0x9c, 0xf2, 0x0a, 0xc9, 0x09, 0x7b,
+	0x6f, 0x2f, 0xdb, 0x7b, 0x21, 0xb5, 0x38, 0x85, 0xd9, 0xef, 0xa0, 0xba, 0xac, 0xdb, 0x50, 0xa0,
+	0xcf, 0x54, 0x2f, 0x94, 0xa3, 0xfc, 0xde, 0x46, 0xca, 0x4c, 0x3b, 0xb4, 0xf8, 0x4b, 0x82, 0x52,
+	0xf7, 0x2a, 0x1d, 0x79, 0x6a, 0x69, 0xb7, 0xc0, 0xcc, 0x06, 0x6e, 0xc3, 0x51, 0x36, 0x6c, 0xf3,
+	0x60, 0xaa, 0xc4, 0xaf, 0xaa, 0xa3, 0x54, 0xb1, 0x60, 0xa1, 0xb3, 0x9f, 0x42, 0xf1, 0x34, 0xa4,
+	0x4c, 0x0c, 0xe6, 0xd5, 0x67, 0x47, 0x0d, 0x72, 0xf3, 0x78, 0xaa, 0x3a, 0x09, 0xff, 0xb5, 0xbf,
+	0x82, 0x9d, 0x6f, 0x43, 0x3f, 0x68, 0x13, 0x9a, 0xf6, 0xdc, 0xa7, 0x50, 0xe2, 0xb3, 0x62, 0xe8,
+	0x07, 0xe3, 0x50, 0xec, 0x2d, 0x37, 0x77, 0xd4, 0x49, 0x09, 0x31, 0x2e, 0x4e, 0xd4, 0x9f, 0x8d,
+	0xa0, 0xb6, 0x20, 0x90, 0x97, 0x74, 0xf0, 0x1c, 0xca, 0x99, 0x97, 0x0c, 0x2a, 0x43, 0xe1, 0xd4,
+	0x39, 0x3a, 0xef, 0x9f, 0x7e, 0x5f, 0xdb, 0xe2, 0x8b, 0xde, 0xa0, 0x77, 0xe1, 0x1c, 0xf7, 0x6b,
+	0x1a, 0x2a, 0xc2, 0x76, 0xcb, 0x39, 0x6a, 0xd5, 0xf4, 0x83, 0x2f, 0xc0, 0xcc, 0xce, 0x36, 0x0e,
+	0x1b, 0x74, 0xbe, 0xeb, 0x74, 0xdf, 0x76, 0xe4, 0x1e, 0x3c, 0xe8, 0x74, 0xce, 0x3a, 0xaf, 0x6a,
+	0x1a, 0xaa, 0x02, 0xf4, 0x1d, 0xdc, 0x3e, 0xeb, 0x1c, 0xf5, 0x1d, 0xbe, 0xf3, 0x73, 0xd8, 0x59,
+	0x99, 0x3b, 0xa8, 0x04, 0xf9, 0xa3, 0x56, 0xcb, 0x69, 0xc9, 0xad, 0x83, 0x8b, 0x96, 0x80, 0x6a,
+	0x82, 0xc7, 0x69, 0x77, 0xdf, 0x88, 0x7d, 0x4d, 0xd8, 0xdd, 0xd0, 0xbd, 0x91, 0x01, 0xba, 0xf3,
+	0xba, 0xb6, 0x85, 0x00, 0x8c, 0x4e, 0xb7, 0x3f, 0x74, 0x5e, 0xd7, 0x34, 0x54, 0x80, 0xdc, 0xab,
+	0xbe, 0x53, 0xd3, 0x0f, 0x1c, 0x40, 0xeb, 0xf7, 0xca, 0x69, 0xdb, 0x67, 0xbd, 0x1e, 0x37, 0x6f,
+	0x0b, 0x55, 0xa0, 0x74, 0xdc, 0xc5, 0x78, 0x70, 0x21, 0x8f, 0xdc, 0x85, 0x9d, 0xee, 0xa0, 0x3f,
+	0xec, 0x9e, 0x0c, 0x7b, 0xce, 0xeb, 0x81, 0xd3, 0x39, 0x76, 0x6a, 0x7a, 0xf3, 0x57, 0x1d, 0x4a,
+	0xe7, 0xe1, 0x55, 0x8f, 0xc4, 0xd7, 0x24, 0x46, 0x2f, 0x01, 0x16, 0xcf, 0x35, 0x64, 0xa9, 0x50,
+	0xaf, 0xbd, 0xe0, 0xea, 0xeb, 0x73, 0xd3, 0xde, 0xfa, 0x54, 0x43, 0x5f, 0x82, 0x21, 0xbb, 0x32,
+	0xda, 0x5b, 0xe9, 0xf7, 0x72, 0x9b, 0xb5, 0x61, 0x0a, 0x88, 0x71, 0x21, 0x76, 0x7f, 0x03, 0x66,
+	0xf6, 0xed, 0x80, 0x92, 0xc1, 0xb6, 0xe1, 0x41, 0x51, 0x47, 0xeb, 0x63, 0x5e, 0x70, 0xbc, 0x00,
+	0x43, 0x3d, 0x73, 0x13, 0x0b, 0x96, 0x5e, 0xd8, 0xf5, 0xdb, 0x2b, 0x52, 0x99, 0x25, 0xf6, 0x56,
+	0xf3, 0x0f, 0x3d, 0x2d, 0x2f, 0x1e, 0x0b, 0x7f, 0x44, 0xd0, 0x19, 0x98, 0xd9, 0x46, 0x93, 0xda,
+	0xb3, 0xa1, 0x5b, 0xd5, 0xef, 0x6f, 0xd4, 0x25, 0xec, 0xe8, 0x08, 0xca, 0x99, 0xee, 0x8b, 0x92,
+	0xc2, 0x5c, 0xef, 0xc8, 0xf5, 0x4d, 0x6d, 0x4b, 0x78, 0xf6, 0x35, 0x94, 0x33, 0x1d, 0x37, 0xa5,
+	0x58, 0xef, 0xc2, 0x69, 0x6c, 0x32, 0x8d, 0x57, 0x30, 0x9c, 0x43, 0x65, 0xa9, 0x91, 0xa1, 0xc4,
+	0xe8, 0x4d, 0x7d, 0xaf, 0xfe, 0x60, 0xb3, 0x32, 0x0d, 0xd8, 0x39, 0x2f, 0x2c, 0x3a, 0x49, 0x82,
+	0xf5, 0x12, 0x8a, 0x49, 0xed, 0xa1, 0xe4, 0x0d, 0xb6, 0x52, 0xcd, 0xf5, 0xbb, 0x6b, 0xf2, 0x84,
+	0xed, 0xd2, 0x10, 0x9a, 0xcf, 0xfe, 0x1a, 0x00, 0x92, 0x0f, 0x5d, 0x31, 0x25, 0x0d, 0x00, 0x00,
 }